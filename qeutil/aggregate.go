@@ -0,0 +1,228 @@
+package qeutil
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/go-redis/cache"
+	"github.com/jmoiron/sqlx"
+)
+
+// Aggregate functions of an AggregateClause.
+const (
+	// Count counts the rows, or the non-NULL values of the column if given
+	Count string = "count"
+	// Sum sums the values of the column
+	Sum string = "sum"
+	// Avg averages the values of the column
+	Avg string = "avg"
+	// Min returns the minimum value of the column
+	Min string = "min"
+	// Max returns the maximum value of the column
+	Max string = "max"
+)
+
+// aggregateFuncs are the SQL functions of the aggregates.
+var aggregateFuncs = map[string]string{
+	Count: "COUNT",
+	Sum:   "SUM",
+	Avg:   "AVG",
+	Min:   "MIN",
+	Max:   "MAX",
+}
+
+// existsFunc is the internal aggregate of SelectClause.Exists, which selects 1 of the first row.
+const existsFunc = "exists"
+
+// groupedAlias is the alias of a grouped SelectClause wrapped by an AggregateClause.
+const groupedAlias = "t"
+
+// AggregateClause computes an aggregate over the rows of a SelectClause, ignoring its OrderBy, Limit, Offset and
+// After. A SelectClause with GroupBy or Having is wrapped as a subquery, e.g. `SELECT COUNT(*) FROM (...) AS t`
+// counts its groups, and Col then refers to a column of its select list. A SelectClause with a Lock is refused,
+// since the aggregate would not lock its rows.
+type AggregateClause struct {
+	Func  string
+	Col   string // Col is the aggregated column, Count counts the rows if it is empty.
+	Query *SelectClause
+}
+
+// Count returns the AggregateClause counting the rows of the SelectClause, e.g. the total of a paginated list.
+func (sc *SelectClause) Count() *AggregateClause {
+	return &AggregateClause{Func: Count, Query: sc}
+}
+
+// Sum returns the AggregateClause summing the column over the rows of the SelectClause.
+func (sc *SelectClause) Sum(col string) *AggregateClause {
+	return &AggregateClause{Func: Sum, Col: col, Query: sc}
+}
+
+// Avg returns the AggregateClause averaging the column over the rows of the SelectClause.
+func (sc *SelectClause) Avg(col string) *AggregateClause {
+	return &AggregateClause{Func: Avg, Col: col, Query: sc}
+}
+
+// Min returns the AggregateClause of the minimum of the column over the rows of the SelectClause.
+func (sc *SelectClause) Min(col string) *AggregateClause {
+	return &AggregateClause{Func: Min, Col: col, Query: sc}
+}
+
+// Max returns the AggregateClause of the maximum of the column over the rows of the SelectClause.
+func (sc *SelectClause) Max(col string) *AggregateClause {
+	return &AggregateClause{Func: Max, Col: col, Query: sc}
+}
+
+// grouped reports whether the SelectClause is wrapped as a subquery.
+func (ac *AggregateClause) grouped() bool {
+	return len(ac.Query.GroupBy) > 0 || ac.Query.Having != ""
+}
+
+// rows returns a copy of the SelectClause without the order and page, whose rows are aggregated.
+func (ac *AggregateClause) rows() *SelectClause {
+	sc := *ac.Query
+	sc.OrderBy = nil
+	sc.Limit = nil
+	sc.Offset = nil
+	sc.After = ""
+	if !ac.grouped() {
+		sc.Select = nil
+		sc.Subqueries = nil
+	}
+	return &sc
+}
+
+// column returns the aggregate expression, e.g. `SUM(`score`)`, quoting the column by quote.
+func (ac *AggregateClause) column(quote func(string) (string, error)) (string, error) {
+	if ac.Func == existsFunc {
+		return "1", nil
+	}
+	fn, ok := aggregateFuncs[ac.Func]
+	if !ok {
+		return "", fmt.Errorf("unknown aggregate function %q", ac.Func)
+	}
+	if ac.Col == "" || ac.Col == "*" {
+		if ac.Func != Count {
+			return "", fmt.Errorf("aggregate function %v requires a column", ac.Func)
+		}
+		return fn + "(*)", nil
+	}
+	col, err := quote(ac.Col)
+	if err != nil {
+		return "", err
+	}
+	return fn + "(" + col + ")", nil
+}
+
+// check returns an error if the AggregateClause has no SelectClause, or aggregates a locking read whose lock would
+// be dropped. The existence check of the first row keeps the lock.
+func (ac *AggregateClause) check() error {
+	if ac.Query == nil {
+		return errors.New("aggregate query is nil")
+	}
+	if ac.Query.Lock != "" && ac.Func != existsFunc {
+		return fmt.Errorf("aggregate %v of a locking read does not lock its rows", ac.Func)
+	}
+	return ac.Query.Dialect.check()
+}
+
+// SQLStm return a query statment of the Dialect of the SelectClause from the AggregateClause.
+func (ac *AggregateClause) SQLStm() (string, []interface{}, error) {
+	builder, err := ac.builder()
+	if err != nil {
+		return "", nil, err
+	}
+	return builder.ToSql()
+}
+
+// builder returns the select builder of the AggregateClause.
+func (ac *AggregateClause) builder() (sq.SelectBuilder, error) {
+	if err := ac.check(); err != nil {
+		return sq.SelectBuilder{}, err
+	}
+	d := ac.Query.Dialect
+	q := newIdents(d, ac.Query.Schema)
+	if !ac.grouped() {
+		builder, err := ac.rows().selectBuilder(q, ac)
+		if err != nil {
+			return builder, err
+		}
+		return builder.PlaceholderFormat(d.placeholder()), nil
+	}
+
+	inner, err := ac.rows().selectBuilder(q, nil)
+	if err != nil {
+		return inner, err
+	}
+	// Columns of the grouped rows are referred to by their names in the select list
+	col, err := ac.column(func(name string) (string, error) {
+		if err := q.check(name); err != nil {
+			return "", err
+		}
+		return d.Quote(name[strings.LastIndex(name, ".")+1:]), nil
+	})
+	if err != nil {
+		return inner, err
+	}
+	builder := sq.Select(col).FromSelect(inner, d.Quote(groupedAlias))
+	return builder.PlaceholderFormat(d.placeholder()), nil
+}
+
+// CacheKey return a cache key from the AggregateClause, which is the key of the aggregated rows in the
+// namespace of the aggregate, e.g. `answers:agg:count(*):where:{user_id=7}`, so that it is unlinked with them.
+// The function and column are escaped like the other identifiers of the key.
+func (ac *AggregateClause) CacheKey() string {
+	col := "*"
+	if ac.Col != "" && ac.Col != "*" {
+		col = keyIdent(ac.Col)
+	}
+	return ac.rows().cacheKey(keyIdent(ac.Func) + "(" + col + ")")
+}
+
+// Get executes the AggregateClause and scans the result into dest, e.g. a *int64 for Count. The result of
+// Sum, Avg, Min and Max is NULL without rows, which should be scanned into a sql.Null type.
+func (ac *AggregateClause) Get(ctx context.Context, db sqlx.QueryerContext, dest interface{}) error {
+	stm, val, err := ac.SQLStm()
+	if err != nil {
+		return err
+	}
+	return traceQuery(ctx, OpAggregate, ac.Query.From, stm, val, func(ctx context.Context) (int64, error) {
+		if err := db.QueryRowxContext(ctx, stm, val...).Scan(dest); err != nil {
+			return -1, err
+		}
+		return 1, nil
+	})
+}
+
+// GetWithCache reads the result of the AggregateClause from the cache, or from DB and then caches it on cache miss.
+// Results are still read from DB if the cache is unavailable, whose failures are reported to the hooks like QueryWithCache.
+// ErrLockedRead is returned if the SelectClause has a Lock.
+func (ac *AggregateClause) GetWithCache(ctx context.Context, db sqlx.QueryerContext, cc *CacheConfig, dest interface{}) error {
+	rv := reflect.ValueOf(dest)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return errors.New("cache destination must be a non-nil pointer")
+	}
+	if ac.Query != nil && ac.Query.Lock != "" {
+		return ErrLockedRead
+	}
+	if err := ac.check(); err != nil {
+		return err
+	}
+	key := ac.CacheKey()
+
+	cacheErr := cc.Cache.Get(key, dest)
+	if cacheErr == nil {
+		return nil
+	}
+	reportCache(ctx, OpCacheGet, ac.Query.From, key, cacheErr)
+	if err := ac.Get(ctx, db, dest); err != nil {
+		return err
+	}
+	if cacheErr == cache.ErrCacheMiss {
+		reportCache(ctx, OpCacheSet, ac.Query.From, key, setCache(cc.Cache, key, dest, cc.expiry(ac.Query.tables()...), ac.rows().CacheTags))
+	}
+	return nil
+}
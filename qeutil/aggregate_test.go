@@ -0,0 +1,111 @@
+package qeutil
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAggregateClauseSQLStm(t *testing.T) {
+	sc := &SelectClause{
+		Select:  []string{"id", "score"},
+		From:    "answers",
+		Where:   []Wh{Wh{Operator: Eq, Values: map[string]interface{}{"user_id": 7}}},
+		OrderBy: []Order{Desc("score")},
+		Limit:   intPtr(20),
+		Offset:  intPtr(40),
+	}
+	stm, val, err := sc.Count().SQLStm()
+	assert.NoError(t, err)
+	assert.Equal(t, "SELECT COUNT(*) FROM `answers` WHERE `user_id` = ?", stm)
+	assert.Equal(t, []interface{}{7}, val)
+
+	stm, _, err = sc.Sum("score").SQLStm()
+	assert.NoError(t, err)
+	assert.Equal(t, "SELECT SUM(`score`) FROM `answers` WHERE `user_id` = ?", stm)
+
+	// The SelectClause is not changed
+	assert.Equal(t, []string{"id", "score"}, sc.Select)
+	assert.Equal(t, 20, *sc.Limit)
+
+	sc.Joins = []Join{Join{Type: InnerJoin, Table: "users", As: "u", On: []Wh{Wh{Operator: Eq, Values: map[string]interface{}{"u.id": Col("answers.user_id")}}}}}
+	sc.Dialect = PostgreSQL
+	stm, _, err = sc.Max("u.age").SQLStm()
+	assert.NoError(t, err)
+	assert.Equal(t, `SELECT MAX("u"."age") FROM "answers" INNER JOIN "users" AS "u" ON "u"."id" = "answers"."user_id" WHERE "user_id" = $1`, stm)
+
+	_, _, err = sc.Avg("").SQLStm()
+	assert.Error(t, err)
+	_, _, err = (&AggregateClause{Func: "median", Col: "score", Query: sc}).SQLStm()
+	assert.Error(t, err)
+	_, _, err = sc.Min("score; DROP TABLE answers").SQLStm()
+	assert.True(t, errors.Is(err, ErrUnsafeSQL))
+}
+
+func TestAggregateClauseSQLStmGrouped(t *testing.T) {
+	sc := &SelectClause{
+		Select:     []string{"user_id", "score AS total"},
+		From:       "answers",
+		Where:      []Wh{Wh{Operator: Eq, Values: map[string]interface{}{"exam_id": 3}}},
+		GroupBy:    []string{"user_id"},
+		Having:     "SUM(score) > ?",
+		HavingArgs: []interface{}{50},
+		OrderBy:    []Order{Asc("user_id")},
+		Limit:      intPtr(10),
+	}
+	stm, val, err := sc.Count().SQLStm()
+	assert.NoError(t, err)
+	assert.Equal(t, "SELECT COUNT(*) FROM (SELECT `user_id`, `score` AS `total` FROM `answers` WHERE `exam_id` = ? GROUP BY `user_id` HAVING SUM(`score`) > ?) AS `t`", stm)
+	assert.Equal(t, []interface{}{3, 50}, val)
+
+	sc.Dialect = PostgreSQL
+	stm, _, err = sc.Avg("total").SQLStm()
+	assert.NoError(t, err)
+	assert.Equal(t, `SELECT AVG("total") FROM (SELECT "user_id", "score" AS "total" FROM "answers" WHERE "exam_id" = $1 GROUP BY "user_id" HAVING SUM("score") > $2) AS "t"`, stm)
+}
+
+func TestAggregateClauseCacheKey(t *testing.T) {
+	sc := &SelectClause{
+		From:    "answers",
+		Where:   []Wh{Wh{Operator: Eq, Values: map[string]interface{}{"user_id": 7}}},
+		OrderBy: []Order{Desc("score")},
+		Limit:   intPtr(20),
+	}
+	assert.Equal(t, "answers:agg:count(*):where:{user_id=7}", sc.Count().CacheKey())
+	assert.Equal(t, "answers:agg:sum(score):where:{user_id=7}", sc.Sum("score").CacheKey())
+	assert.Equal(t, "answers:agg:max(t.score%3a%2a%7b):where:{user_id=7}", sc.Max("t.score:*{").CacheKey())
+
+	// Cached aggregates are unlinked with the rows of the list
+	uc := UpdateClause{Update: "answers", Set: map[string]interface{}{"score": 1}, Where: sc.Where}
+	fc := newFakeCache()
+	fc.Set(sc.Count().CacheKey(), 1, time.Minute)
+	fc.Set(sc.Sum("score").CacheKey(), 1, time.Minute)
+	fc.UnlinkKeys(uc.ToUnlinks())
+	assert.Empty(t, fc.keys())
+
+	sc.Joins = []Join{Join{Type: InnerJoin, Table: "users"}}
+	fc.Set(sc.Count().CacheKey(), 1, time.Minute)
+	fc.UnlinkKeys((&UpdateClause{Update: "users", Set: map[string]interface{}{"name": "a"}}).ToUnlinks())
+	assert.Empty(t, fc.keys())
+}
+
+func TestAggregateClauseGetWithCache(t *testing.T) {
+	db, conn := newFakeDB(func(stm string, args []driver.Value) fakeResp {
+		return fakeResp{cols: []string{"COUNT(*)"}, rows: [][]driver.Value{{int64(42)}}}
+	})
+	fc := newFakeCache()
+	cc := &CacheConfig{Cache: fc, Expiry: time.Minute}
+	sc := &SelectClause{From: "answers", Limit: intPtr(20)}
+
+	for i := 0; i < 2; i++ {
+		var n int64
+		assert.NoError(t, sc.Count().GetWithCache(context.Background(), db, cc, &n))
+		assert.Equal(t, int64(42), n)
+	}
+	assert.Equal(t, []string{"SELECT COUNT(*) FROM `answers`"}, conn.statements())
+	assert.Equal(t, []string{"answers:agg:count(*)"}, fc.keys())
+}
@@ -0,0 +1,184 @@
+package qeutil
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/go-redis/cache"
+	"github.com/henrycheung19/pkg/rediscli"
+	"github.com/jmoiron/sqlx"
+)
+
+// Cacher defines the cache storage of query results, it is implemented by *rediscli.Client.
+type Cacher interface {
+	Get(key string, obj interface{}) error
+	Set(key string, obj interface{}, exp time.Duration) error
+	UnlinkKeys(keys []string) error
+}
+
+// TagCacher is a Cacher which indexes the cache entries by tags, so that the entries of a write are unlinked by
+// looking up the sets of its tags instead of scanning the keyspace for its patterns. It is implemented by
+// *rediscli.Client.
+// UnlinkTags unlinks the keys in the sets of the tags, except the keys of a tag which are skipped by any of its skip
+// groups: a key is skipped by a group if it is in the set of its first tag and in none of the sets of the others.
+type TagCacher interface {
+	Cacher
+	SetTags(key string, obj interface{}, exp time.Duration, tags []string) error
+	UnlinkTags(tags []string, skips map[string][][]string) error
+}
+
+var _ TagCacher = (*rediscli.Client)(nil)
+
+// CacheConfig defines where and how long the query results are cached.
+type CacheConfig struct {
+	Cache       Cacher                   // Cache stores the query results.
+	Expiry      time.Duration            // Expiry is the default TTL of query results.
+	TableExpiry map[string]time.Duration // TableExpiry overrides Expiry for the results of the given tables.
+	EmptyExpiry time.Duration            // EmptyExpiry is the TTL of empty results, zero disables caching them.
+}
+
+// expiry returns the TTL of the query results of the given tables, which is the shortest one among them.
+func (cc *CacheConfig) expiry(tables ...string) time.Duration {
+	exp := time.Duration(-1)
+	for _, table := range tables {
+		if tableExp, ok := cc.TableExpiry[table]; ok && (exp < 0 || tableExp < exp) {
+			exp = tableExp
+		}
+	}
+	if exp < 0 {
+		return cc.Expiry
+	}
+	return exp
+}
+
+// cacheEntry is the cached form of a query result, Empty marks a result without rows.
+type cacheEntry struct {
+	Empty bool
+	Data  interface{}
+}
+
+// tables returns all tables read by the SelectClause.
+func (sc *SelectClause) tables() []string {
+	tables := []string{sc.From}
+	for i := range sc.Joins {
+		tables = append(tables, sc.Joins[i].Table)
+	}
+	return tables
+}
+
+// QueryWithCache reads the result of the SelectClause from the cache, or from DB and then caches it on cache miss.
+// dest must be a pointer to a slice, which is filled like Query, or a pointer to a single row, which is filled like Get
+// and ErrNotExist is returned when no row is found. Results are still read from DB if the cache is unavailable, whose
+// failures are reported to the hooks as OpCacheGet and OpCacheSet events. ErrLockedRead is returned if the SelectClause has a Lock.
+func (sc *SelectClause) QueryWithCache(ctx context.Context, db sqlx.ExtContext, cc *CacheConfig, dest interface{}) error {
+	rv := reflect.ValueOf(dest)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return errors.New("cache destination must be a non-nil pointer")
+	}
+	if sc.Lock != "" {
+		return ErrLockedRead
+	}
+	isSlice := rv.Elem().Kind() == reflect.Slice && rv.Elem().Type().Elem().Kind() != reflect.Uint8
+	key := sc.CacheKey()
+
+	// Try to get result from cache
+	entry := cacheEntry{Data: dest}
+	cacheErr := cc.Cache.Get(key, &entry)
+	if cacheErr == nil {
+		if !entry.Empty {
+			return nil
+		}
+		if isSlice {
+			rv.Elem().Set(reflect.MakeSlice(rv.Elem().Type(), 0, 0))
+			return nil
+		}
+		return ErrNotExist
+	}
+	reportCache(ctx, OpCacheGet, sc.From, key, cacheErr)
+
+	// Missed for whatever reason, get from DB
+	var err error
+	empty := false
+	if isSlice {
+		err = sc.Query(ctx, db, dest)
+		empty = err == nil && rv.Elem().Len() == 0
+	} else {
+		err = sc.Get(ctx, db, dest)
+		empty = err == ErrNotExist
+	}
+	if err != nil && !empty {
+		return err
+	}
+
+	// Write result to cache only if it is really missed, otherwise the cache is unavailable
+	if cacheErr == cache.ErrCacheMiss {
+		if empty {
+			if cc.EmptyExpiry > 0 {
+				reportCache(ctx, OpCacheSet, sc.From, key, setCache(cc.Cache, key, &cacheEntry{Empty: true}, cc.EmptyExpiry, sc.CacheTags))
+			}
+		} else {
+			reportCache(ctx, OpCacheSet, sc.From, key, setCache(cc.Cache, key, &cacheEntry{Data: dest}, cc.expiry(sc.tables()...), sc.CacheTags))
+		}
+	}
+	return err
+}
+
+// Executor executes the write clauses and then unlinks their cache entries, by their tags if Cache is a TagCacher.
+type Executor struct {
+	DB    sqlx.ExtContext
+	Cache Cacher
+
+	// UnlinkBefore unlinks the cache entries before the write as well, so that a concurrent read which
+	// cached the old rows before the write had been applied cannot outlive it.
+	UnlinkBefore bool
+}
+
+// Insert executes the InsertClause and unlinks the cache entries of its table, the last insert id is returned.
+func (e *Executor) Insert(ctx context.Context, ic *InsertClause) (int64, error) {
+	return e.exec(ic, func() (int64, error) {
+		return ic.Exec(ctx, e.DB)
+	})
+}
+
+// Update executes the UpdateClause and unlinks its cache entries, the number of affected rows is returned.
+func (e *Executor) Update(ctx context.Context, uc *UpdateClause) (int64, error) {
+	return e.exec(uc, func() (int64, error) {
+		return uc.Exec(ctx, e.DB)
+	})
+}
+
+// Delete executes the DeleteClause and unlinks its cache entries, the number of affected rows is returned.
+func (e *Executor) Delete(ctx context.Context, dc *DeleteClause) (int64, error) {
+	return e.exec(dc, func() (int64, error) {
+		return dc.Exec(ctx, e.DB)
+	})
+}
+
+// Restore executes the RestoreClause and unlinks the cache entries of its table, the number of restored rows is returned.
+func (e *Executor) Restore(ctx context.Context, rc *RestoreClause) (int64, error) {
+	return e.exec(rc, func() (int64, error) {
+		return rc.Exec(ctx, e.DB)
+	})
+}
+
+// exec runs the write and unlinks the cache entries of the clause, nothing is unlinked after a failed or no-op write.
+func (e *Executor) exec(w writeClause, write func() (int64, error)) (int64, error) {
+	var unlinks cacheUnlinks
+	unlinks.add(w)
+	if e.UnlinkBefore {
+		if err := unlinks.unlink(e.Cache); err != nil {
+			return 0, fmt.Errorf("unlink cache before write: %w", err)
+		}
+	}
+	n, err := write()
+	if err != nil {
+		return n, err
+	}
+	if err := unlinks.unlink(e.Cache); err != nil {
+		return n, fmt.Errorf("unlink cache after write: %w", err)
+	}
+	return n, nil
+}
@@ -0,0 +1,176 @@
+package qeutil
+
+import (
+	"context"
+	"database/sql/driver"
+	"path"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-redis/cache"
+	"github.com/stretchr/testify/assert"
+	"github.com/vmihailenco/msgpack"
+)
+
+// fakeCache is an in-memory Cacher which serializes objects with msgpack like rediscli.Client.
+type fakeCache struct {
+	mu      sync.Mutex
+	items   map[string][]byte
+	expires map[string]time.Duration
+	unlinks int
+}
+
+func newFakeCache() *fakeCache {
+	return &fakeCache{items: map[string][]byte{}, expires: map[string]time.Duration{}}
+}
+
+func (fc *fakeCache) Get(key string, obj interface{}) error {
+	fc.mu.Lock()
+	b, ok := fc.items[key]
+	fc.mu.Unlock()
+	if !ok {
+		return cache.ErrCacheMiss
+	}
+	return msgpack.Unmarshal(b, obj)
+}
+
+func (fc *fakeCache) Set(key string, obj interface{}, exp time.Duration) error {
+	b, err := msgpack.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	fc.mu.Lock()
+	fc.items[key] = b
+	fc.expires[key] = exp
+	fc.mu.Unlock()
+	return nil
+}
+
+func (fc *fakeCache) UnlinkKeys(keys []string) error {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	fc.unlinks++
+	for _, pattern := range keys {
+		for key := range fc.items {
+			if ok, _ := path.Match(pattern, key); ok {
+				delete(fc.items, key)
+			}
+		}
+	}
+	return nil
+}
+
+func (fc *fakeCache) keys() []string {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	keys := []string{}
+	for key := range fc.items {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func TestSelectClauseQueryWithCache(t *testing.T) {
+	db, conn := newFakeDB(func(stm string, args []driver.Value) fakeResp {
+		return fakeResp{cols: []string{"id", "name"}, rows: [][]driver.Value{{int64(1), "a"}, {int64(2), "b"}}}
+	})
+	fc := newFakeCache()
+	cc := &CacheConfig{Cache: fc, Expiry: time.Minute, TableExpiry: map[string]time.Duration{"users": time.Second}}
+	sc := SelectClause{From: "table"}
+
+	for i := 0; i < 2; i++ {
+		var rows []testRow
+		assert.NoError(t, sc.QueryWithCache(context.Background(), db, cc, &rows))
+		assert.Equal(t, []testRow{{1, "a"}, {2, "b"}}, rows)
+	}
+	assert.Len(t, conn.statements(), 1)
+	assert.Equal(t, time.Minute, fc.expires["table"])
+
+	// Single row with the joined table TTL
+	sc.Joins = []Join{Join{Type: InnerJoin, Table: "users"}}
+	for i := 0; i < 2; i++ {
+		var row testRow
+		assert.NoError(t, sc.QueryWithCache(context.Background(), db, cc, &row))
+		assert.Equal(t, testRow{1, "a"}, row)
+	}
+	assert.Len(t, conn.statements(), 2)
+	assert.Equal(t, time.Second, fc.expires[sc.CacheKey()])
+}
+
+func TestSelectClauseQueryWithCacheEmpty(t *testing.T) {
+	db, conn := newFakeDB(func(stm string, args []driver.Value) fakeResp {
+		return fakeResp{cols: []string{"id", "name"}}
+	})
+	fc := newFakeCache()
+	cc := &CacheConfig{Cache: fc, Expiry: time.Minute}
+	sc := SelectClause{From: "table"}
+
+	// Empty results are not cached without EmptyExpiry
+	var row testRow
+	assert.Equal(t, ErrNotExist, sc.QueryWithCache(context.Background(), db, cc, &row))
+	assert.Empty(t, fc.keys())
+
+	cc.EmptyExpiry = time.Second
+	for i := 0; i < 2; i++ {
+		assert.Equal(t, ErrNotExist, sc.QueryWithCache(context.Background(), db, cc, &row))
+	}
+	assert.Len(t, conn.statements(), 2)
+
+	rows := []testRow{{1, "a"}}
+	assert.NoError(t, sc.QueryWithCache(context.Background(), db, cc, &rows))
+	assert.Empty(t, rows)
+	assert.Len(t, conn.statements(), 2)
+}
+
+func TestExecutor(t *testing.T) {
+	affected := int64(1)
+	db, _ := newFakeDB(func(stm string, args []driver.Value) fakeResp {
+		return fakeResp{lastID: 7, affected: affected}
+	})
+	fc := newFakeCache()
+	for _, key := range []string{"table", "table:where:{id=1}", "table:where:{id=2}", "other:where:{id=1}"} {
+		fc.Set(key, 1, time.Minute)
+	}
+	e := Executor{DB: db, Cache: fc}
+
+	n, err := e.Update(context.Background(), &UpdateClause{
+		Update: "table",
+		Set:    map[string]interface{}{"name": "a"},
+		Where:  []Wh{Wh{Operator: Eq, Values: map[string]interface{}{"id": 2}}},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), n)
+	assert.Equal(t, []string{"other:where:{id=1}"}, fc.keys())
+	assert.Equal(t, 1, fc.unlinks)
+
+	// Nothing is unlinked after a no-op write, except before it with UnlinkBefore
+	fc.Set("table:where:{id=2}", 1, time.Minute)
+	affected = 0
+	_, err = e.Delete(context.Background(), &DeleteClause{
+		From:  "table",
+		Where: []Wh{Wh{Operator: Eq, Values: map[string]interface{}{"id": 1}}},
+	})
+	assert.Equal(t, ErrNotChanged, err)
+	assert.Equal(t, []string{"other:where:{id=1}", "table:where:{id=2}"}, fc.keys())
+	assert.Equal(t, 1, fc.unlinks)
+
+	e.UnlinkBefore = true
+	_, err = e.Delete(context.Background(), &DeleteClause{
+		From:  "table",
+		Where: []Wh{Wh{Operator: Eq, Values: map[string]interface{}{"id": 1}}},
+	})
+	assert.Equal(t, ErrNotChanged, err)
+	assert.Equal(t, []string{"other:where:{id=1}"}, fc.keys())
+	assert.Equal(t, 2, fc.unlinks)
+
+	affected = 1
+	fc.Set("table", 1, time.Minute)
+	id, err := e.Insert(context.Background(), &InsertClause{Into: "table", Values: map[string]interface{}{"name": "a"}})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(7), id)
+	assert.Equal(t, []string{"other:where:{id=1}"}, fc.keys())
+	assert.Equal(t, 4, fc.unlinks)
+}
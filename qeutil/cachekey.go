@@ -0,0 +1,164 @@
+package qeutil
+
+import (
+	"crypto/sha256"
+	"database/sql/driver"
+	"encoding/hex"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// CacheKeyVersion is written at the beginning of every cache key, unlink pattern and tag, e.g.
+	// `v2:answers:where:{id=1}`. Changing it, e.g. after a schema change, invalidates all cached entries at once,
+	// the entries of the previous version are left to expire.
+	CacheKeyVersion string
+	// MaxCacheKeyLen bounds the length of the cache keys if it is positive. The conditions and the following
+	// segments of a longer key are hashed, keeping the table, joins and subqueries readable, e.g.
+	// `answers:#3f2a...`. Hashed keys are unlinked by their tags with a TagCacher, or by the unlink patterns of
+	// their table.
+	MaxCacheKeyLen int
+)
+
+// hashMark marks the hashed segments of a cache key, it never appears elsewhere since it is escaped by keyEscape.
+const hashMark = ":#"
+
+// keyEscape escapes a column or value written into a cache key. Every byte other than letters, digits and
+// `_.$-` is written as `%xx`, so that the delimiters of the key and the special characters of redis patterns,
+// e.g. `{}:&|()=*?[]\`, never appear in a column or value.
+func keyEscape(s string) string {
+	buf := strings.Builder{}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9', c == '_', c == '.', c == '$', c == '-':
+			buf.WriteByte(c)
+		default:
+			fmt.Fprintf(&buf, "%%%02x", c)
+		}
+	}
+	return buf.String()
+}
+
+// keyValue returns the escaped form of a where clause value, which keeps the type of the value so that values
+// compared differently in SQL never share a key: strings are quoted, e.g. `'Bob'`, numbers are bare, e.g. `1` or
+// `-1.5`, and nil and bools are `null`, `true` and `false`. Pointers are dereferenced and driver.Valuer values are
+// written as their driver values like in the statements. A Col is written as `@col`, a subquery as `(key)` with its
+// escaped cache key, and values of other types are quoted after their type, e.g. `time.Time'...'`.
+func keyValue(v interface{}) string {
+	rv := reflect.ValueOf(v)
+	if !rv.IsValid() || rv.Kind() == reflect.Ptr && rv.IsNil() {
+		return "null"
+	}
+	switch val := v.(type) {
+	case Col:
+		return "@" + keyEscape(string(val))
+	case *SelectClause:
+		return "(" + keyEscape(subCacheKey(val)) + ")"
+	case driver.Valuer:
+		if dv, err := val.Value(); err == nil {
+			return keyValue(dv)
+		}
+	case time.Time:
+		return "time.Time'" + keyEscape(val.UTC().Format(time.RFC3339Nano)) + "'"
+	case []byte:
+		return "'" + keyEscape(string(val)) + "'"
+	}
+
+	switch rv.Kind() {
+	case reflect.Ptr:
+		return keyValue(rv.Elem().Interface())
+	case reflect.Bool:
+		return strconv.FormatBool(rv.Bool())
+	case reflect.String:
+		return "'" + keyEscape(rv.String()) + "'"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(rv.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(rv.Uint(), 10)
+	case reflect.Float32, reflect.Float64:
+		return keyEscape(strconv.FormatFloat(rv.Float(), 'g', -1, rv.Type().Bits()))
+	}
+	return keyEscape(fmt.Sprintf("%T", v)) + "'" + keyEscape(fmt.Sprint(v)) + "'"
+}
+
+// keyList returns the elements of a list value, e.g. the values of In. Byte slices are not lists.
+func keyList(v interface{}) ([]interface{}, bool) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array || rv.Type().Elem().Kind() == reflect.Uint8 {
+		return nil, false
+	}
+	list := make([]interface{}, rv.Len())
+	for i := range list {
+		list[i] = rv.Index(i).Interface()
+	}
+	return list, true
+}
+
+// keyValues returns the escaped form of a value which may be a list, whose elements are separated by ",".
+func keyValues(v interface{}) string {
+	list, ok := keyList(v)
+	if !ok {
+		return keyValue(v)
+	}
+	vals := make([]string, len(list))
+	for i := range list {
+		vals[i] = keyValue(list[i])
+	}
+	return strings.Join(vals, ",")
+}
+
+// keyIdent returns the escaped form of an identifier written into a cache key. Identifiers are lowercased since
+// they are case-insensitive, while values keep their case.
+func keyIdent(s string) string {
+	return keyEscape(strings.ToLower(s))
+}
+
+// keyValueSet returns the escaped form of a value which may be a set, e.g. the values of NotIn, whose elements are
+// sorted without duplicates. Elements are ordered regardless of their case first, so that sets differing only in
+// the case of their elements are written in the same order.
+func keyValueSet(v interface{}) string {
+	list, ok := keyList(v)
+	if !ok {
+		return keyValue(v)
+	}
+	vals := make([]string, len(list))
+	for i := range list {
+		vals[i] = keyValue(list[i])
+	}
+	vals = sortedSet(vals)
+	sort.SliceStable(vals, func(i, j int) bool { return strings.ToLower(vals[i]) < strings.ToLower(vals[j]) })
+	return strings.Join(vals, ",")
+}
+
+// sortedSet returns the sorted strings without duplicates.
+func sortedSet(strs []string) []string {
+	set := make(map[string]bool, len(strs))
+	uniq := make([]string, 0, len(strs))
+	for _, s := range strs {
+		if !set[s] {
+			set[s] = true
+			uniq = append(uniq, s)
+		}
+	}
+	sort.Strings(uniq)
+	return uniq
+}
+
+// keyPrefix returns the prefix of the cache keys, patterns and tags of CacheKeyVersion.
+func keyPrefix() string {
+	if CacheKeyVersion == "" {
+		return ""
+	}
+	return keyEscape(CacheKeyVersion) + ":"
+}
+
+// keyHash returns the hashed form of the segments of a cache key, see MaxCacheKeyLen.
+func keyHash(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hashMark + hex.EncodeToString(sum[:16])
+}
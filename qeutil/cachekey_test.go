@@ -0,0 +1,304 @@
+package qeutil
+
+import (
+	"database/sql"
+	"fmt"
+	"math/rand"
+	"net/url"
+	"path"
+	"reflect"
+	"testing"
+	"testing/quick"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyEscape(t *testing.T) {
+	assert.Equal(t, "t.user_id", keyEscape("t.user_id"))
+	assert.Equal(t, "-1.5", keyEscape("-1.5"))
+	assert.Equal(t, "a%20b%2a%3f%5b%5d%7b%7d%3a%26%7c%28%29%3d%25%2f%5c", keyEscape(`a b*?[]{}:&|()=%/\`))
+	assert.Equal(t, "@t.id", keyValue(Col("t.id")))
+	assert.Equal(t, "1,'a%2cb'", keyValues([]interface{}{1, "a,b"}))
+}
+
+func TestKeyValue(t *testing.T) {
+	key := func(val interface{}) string {
+		return (&SelectClause{From: "u", Where: []Wh{Wh{Operator: Eq, Values: map[string]interface{}{"name": val}}}}).CacheKey()
+	}
+	// Values rendered differently in SQL have their own keys
+	assert.Equal(t, "u:where:{name=null}", key(nil))
+	assert.Equal(t, "u:where:{name='%3cnil%3e'}", key("<nil>"))
+	assert.Equal(t, "u:where:{name=true}", key(true))
+	assert.Equal(t, "u:where:{name='true'}", key("true"))
+	assert.Equal(t, "u:where:{name=1}", key(int64(1)))
+	assert.Equal(t, "u:where:{name='1'}", key("1"))
+	assert.Equal(t, "u:where:{name=1.5}", key(float32(1.5)))
+	assert.Equal(t, "u:where:{name=time.Time'2020-01-02T03%3a04%3a05Z'}", key(time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)))
+
+	// Pointers and driver.Valuer values are written as the values sent to DB
+	a, b := 7, 7
+	assert.Equal(t, key(7), key(&a))
+	assert.Equal(t, key(&a), key(&b))
+	assert.Equal(t, key(nil), key((*int)(nil)))
+	assert.Equal(t, key("Bob"), key(sql.NullString{String: "Bob", Valid: true}))
+	assert.Equal(t, key(nil), key(sql.NullString{}))
+	assert.Equal(t, key("Bob"), key([]byte("Bob")))
+
+	// A client cannot reach the key of null with a string
+	ps := &ParamSpec{From: "u", Fields: map[string][]string{"name": {Eq}}}
+	query, err := ps.Parse(url.Values{"name": {"<nil>"}})
+	assert.NoError(t, err)
+	body, err := ps.ParseJSON([]byte(`{"name": null}`))
+	assert.NoError(t, err)
+	assert.NotEqual(t, query.CacheKey(), body.CacheKey())
+}
+
+// matchAny reports whether the key is matched by any of the patterns.
+func matchAny(patterns []string, key string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, key); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func TestUnlinkPatterns(t *testing.T) {
+	eq := func(col string, val interface{}) []Wh {
+		return []Wh{Wh{Operator: Eq, Values: map[string]interface{}{col: val}}}
+	}
+	update := func(whs []Wh) []string {
+		return (&UpdateClause{Update: "answers", Set: map[string]interface{}{"score": 1}, Where: whs}).ToUnlinks()
+	}
+	limit := 10
+	for _, c := range []struct {
+		sc      SelectClause
+		unlinks []string
+		matched bool
+	}{
+		{SelectClause{From: "answers", Where: eq("id", 1)}, update(eq("id", 1)), true},
+		{SelectClause{From: "answers", Where: append(eq("user_id", 7), eq("id", 1)...), OrderBy: []Order{Asc("id")}, Limit: &limit}, update(eq("id", 1)), true},
+		// An unfiltered list may contain the rows of any write
+		{SelectClause{From: "answers"}, (&DeleteClause{From: "answers", Where: eq("id", 1)}).ToUnlinks(), true},
+		{SelectClause{From: "answers", OrderBy: []Order{Desc("score")}, Limit: &limit}, update(eq("id", 1)), true},
+		// Entries on other columns may contain the rows, and those on the set columns gain or lose them
+		{SelectClause{From: "answers", Where: eq("user_id", 7)}, update(eq("id", 1)), true},
+		{SelectClause{From: "answers", Where: eq("score", 0)}, update(eq("id", 1)), true},
+		{SelectClause{From: "answers", Where: eq("score", 1)}, update(eq("id", 1)), true},
+		// Conditions of subqueries belong to their own tables
+		{SelectClause{From: "users", Subqueries: []Subquery{Subquery{Query: &SelectClause{From: "answers", Where: eq("id", 1)}, As: "n"}}}, (&UpdateClause{Update: "users", Where: eq("id", 1)}).ToUnlinks(), true},
+		{SelectClause{From: "users", Where: eq("exam_id", 1), Subqueries: []Subquery{Subquery{Query: &SelectClause{From: "answers", Where: eq("id", 1)}, As: "n"}}}, (&UpdateClause{Update: "exams", Where: eq("id", 1)}).ToUnlinks(), false},
+		{SelectClause{From: "answers_log", Where: eq("id", 1)}, update(eq("id", 1)), false},
+	} {
+		key := c.sc.CacheKey()
+		assert.Equal(t, c.matched, matchAny(c.unlinks, key), "%v %v", key, c.unlinks)
+	}
+}
+
+// unlinkCase is a cached SelectClause and a write, which are related if the write must unlink the cache entry.
+type unlinkCase struct {
+	sc     *SelectClause
+	table  string
+	where  []Wh
+	set    map[string]interface{}
+	insert bool
+}
+
+var (
+	unlinkTables  = []string{"answers", "answers_log", "users"}
+	unlinkColumns = []string{"id", "user_id", "t.id", "name"}
+	unlinkValues  = []interface{}{1, 10, 100, "1", "10", "Bob", "bob", "BOB", "a b", "a,b", "x*y", "{id=1}", "}&{id=1", "[1]", "%31", "@t.id", "id=1", ":where:"}
+)
+
+// Generate implements quick.Generator.
+func (unlinkCase) Generate(r *rand.Rand, size int) reflect.Value {
+	pick := func(list []interface{}) interface{} { return list[r.Intn(len(list))] }
+	list := func() []interface{} {
+		vals := make([]interface{}, 1+r.Intn(3))
+		for i := range vals {
+			vals[i] = pick(unlinkValues)
+		}
+		return vals
+	}
+	var cond func(depth int) Wh
+	cond = func(depth int) Wh {
+		col := unlinkColumns[r.Intn(len(unlinkColumns))]
+		switch n := r.Intn(10); {
+		case n < 4:
+			return Wh{Operator: Eq, Values: map[string]interface{}{col: pick(unlinkValues)}}
+		case n < 6:
+			return Wh{Operator: In, Values: map[string]interface{}{col: list()}}
+		case n == 6:
+			return Wh{Operator: []string{Gt, Like, IsNull}[r.Intn(3)], Values: map[string]interface{}{col: pick(unlinkValues)}}
+		case n == 7:
+			return Wh{Operator: NotIn, Values: map[string]interface{}{col: list()}}
+		case depth > 1:
+			return Wh{Operator: Eq, Values: map[string]interface{}{col: pick(unlinkValues)}}
+		case n == 8:
+			return OrWh(cond(depth+1), cond(depth+1))
+		default:
+			return AndWh(cond(depth+1), cond(depth+1))
+		}
+	}
+	whs := func() []Wh {
+		whs := make([]Wh, r.Intn(4))
+		for i := range whs {
+			whs[i] = cond(0)
+		}
+		return whs
+	}
+
+	c := unlinkCase{
+		sc:    &SelectClause{From: unlinkTables[r.Intn(len(unlinkTables))], Where: whs()},
+		table: unlinkTables[r.Intn(len(unlinkTables))],
+		where: whs(),
+	}
+	if r.Intn(4) == 0 {
+		c.sc.OrderBy = []Order{Desc("id")}
+		c.sc.Limit = intPtr(r.Intn(100))
+	}
+	if r.Intn(6) == 0 {
+		c.sc.Joins = []Join{Join{Type: LeftJoin, Table: unlinkTables[r.Intn(len(unlinkTables))], As: "t", On: whs()}}
+	}
+	if r.Intn(6) == 0 {
+		c.sc.Where = append(c.sc.Where, Wh{Operator: In, Values: map[string]interface{}{"user_id": &SelectClause{From: unlinkTables[r.Intn(len(unlinkTables))], Where: whs()}}})
+	}
+	if r.Intn(3) == 0 {
+		// Writes and entries of the same table on integer ids, which may be disjoint
+		ids := []interface{}{1, 10, 100}
+		c.table = c.sc.From
+		c.sc.Where = append(c.sc.Where, Wh{Operator: In, Values: map[string]interface{}{"id": []interface{}{pick(ids), pick(ids)}}})
+		c.where = append(c.where, Wh{Operator: Eq, Values: map[string]interface{}{"id": pick(ids)}})
+	}
+	switch r.Intn(8) {
+	case 0:
+		c.insert = true
+	case 1:
+		c.where = append(c.where, NotWh(cond(0)))
+	case 2, 3:
+		c.set = map[string]interface{}{unlinkColumns[r.Intn(len(unlinkColumns))]: pick(unlinkValues)}
+	}
+	return reflect.ValueOf(c)
+}
+
+// write returns the write clause of the case.
+func (c unlinkCase) write() writeClause {
+	switch {
+	case c.insert:
+		return &InsertClause{Into: c.table}
+	case c.set != nil:
+		return &UpdateClause{Update: c.table, Set: c.set, Where: c.where}
+	}
+	return &DeleteClause{From: c.table, Where: c.where}
+}
+
+// eqValues returns the integer values of each column in the Eq and In conditions of the where clauses, outside of
+// Or and Not groups, which are the values every row of the where clauses has in one of the columns. Other values
+// may be equal in the DB, e.g. strings in any case.
+func eqValues(whs []Wh) map[string]map[string]bool {
+	cols := map[string]map[string]bool{}
+	var walk func(whs []Wh)
+	walk = func(whs []Wh) {
+		for _, wh := range whs {
+			if wh.Operator == And {
+				walk(wh.group())
+			}
+			if wh.Operator != Eq && wh.Operator != In {
+				continue
+			}
+			for col, val := range wh.Values {
+				if _, ok := val.(*SelectClause); ok {
+					continue
+				}
+				list := []interface{}{val}
+				if l, ok := val.([]interface{}); ok {
+					list = l
+				}
+				vals := map[string]bool{}
+				for _, v := range list {
+					if _, ok := v.(int); !ok {
+						vals = nil
+						break
+					}
+					vals[fmt.Sprint(v)] = true
+				}
+				if vals == nil {
+					continue
+				}
+				// Every condition holds, the row has a value of all of them
+				if prev, ok := cols[col]; ok {
+					for v := range vals {
+						if !prev[v] {
+							delete(vals, v)
+						}
+					}
+				}
+				cols[col] = vals
+			}
+		}
+	}
+	walk(whs)
+	return cols
+}
+
+// involved reports whether the write is on the table of the cache entry, or on a table read by a joined entry.
+func (c unlinkCase) involved() bool {
+	if len(c.sc.Joins) > 0 || len(c.sc.subTables()) > 0 {
+		for _, table := range append(c.sc.subTables(), c.sc.tables()...) {
+			if table == c.table {
+				return true
+			}
+		}
+		return false
+	}
+	return c.table == c.sc.From
+}
+
+// related reports whether the rows of the write could satisfy the where clauses of the cache entry, before or after
+// an update. It is false only if the write is on another table, or on the same table of an entry which is not
+// joined, and the entry has Eq or In conditions on a column whose values exclude the values of the write in that
+// column, see eqValues. The column of an update has its old and new values, which are unknown if the write has no
+// condition on it or sets it to another kind of value.
+func (c unlinkCase) related() bool {
+	if !c.involved() {
+		return false
+	}
+	if len(c.sc.Joins) > 0 || len(c.sc.subTables()) > 0 || c.insert {
+		return true
+	}
+	entry := eqValues(c.sc.Where)
+	for col, vals := range eqValues(c.where) {
+		if val, ok := c.set[col]; ok {
+			if _, ok := val.(int); !ok {
+				continue
+			}
+			vals[fmt.Sprint(val)] = true
+		}
+		if _, ok := entry[col]; !ok {
+			continue
+		}
+		overlaps := false
+		for v := range vals {
+			overlaps = overlaps || entry[col][v]
+		}
+		if !overlaps {
+			return false
+		}
+	}
+	return true
+}
+
+func TestUnlinkPatternsProperty(t *testing.T) {
+	// Patterns never miss a related entry and never match the entries of other tables. They cannot skip the
+	// unrelated entries of the written table, see tableUnlinkPatterns, whose exact invalidation by tags is checked
+	// by TestUnlinkTagsProperty.
+	f := func(c unlinkCase) bool {
+		matched := matchAny(c.write().ToUnlinks(), c.sc.CacheKey())
+		return (matched || !c.related()) && (c.involved() || !matched)
+	}
+	if err := quick.Check(f, &quick.Config{MaxCount: 5000}); err != nil {
+		c := err.(*quick.CheckError).In[0].(unlinkCase)
+		t.Errorf("%v: key %v, unlinks %v", err, c.sc.CacheKey(), c.write().ToUnlinks())
+	}
+}
@@ -0,0 +1,293 @@
+package qeutil
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// tagPrefix is the prefix of the tag sets, which keeps them apart from the cache keys and their unlink patterns.
+const tagPrefix = "tag:"
+
+// tableTag returns the tag of all cache entries of a table.
+func tableTag(table string) string {
+	return tagPrefix + keyPrefix() + strings.ToLower(table)
+}
+
+// joinTag returns the tag of the joined cache entries involving a table.
+func joinTag(table string) string {
+	return tagPrefix + keyPrefix() + strings.ToLower(table) + ":join"
+}
+
+// condTag returns the tag of the cache entries of a table with a condition, e.g. `tag:table:{id=1}`.
+// Conditions are lowercased unlike the cache keys, like the case-insensitive collations.
+func condTag(table, token string) string {
+	return tagPrefix + keyPrefix() + strings.ToLower(table+":"+token)
+}
+
+// colTag returns the tag of the cache entries of a table with conditions on the values of a column, e.g.
+// `tag:table:{id}`, whose values are tagged by condTag.
+func colTag(table, col string) string {
+	return condTag(table, "{"+keyIdent(col)+"}")
+}
+
+// intValue reports whether the value is an integer. Only integers are compared by the tags of a column, since the
+// other values may be equal in the DB without being equal strings, e.g. strings regardless of their case or trailing
+// spaces in their collation, or a number and its string.
+func intValue(v interface{}) bool {
+	switch reflect.ValueOf(v).Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	}
+	return false
+}
+
+// colValues returns the tags of the integer values of each column in the Eq and In conditions of the where clauses,
+// outside of Or and Not groups. Every row of the where clauses has one of the values of each column.
+func colValues(table string, whs []Wh) map[string][]string {
+	cols := map[string][]string{}
+	for i := range whs {
+		if whs[i].Operator == And {
+			for col, tags := range colValues(table, whs[i].group()) {
+				cols[col] = intersect(cols[col], tags, cols[col] != nil)
+			}
+			continue
+		}
+		if whs[i].Operator != Eq && whs[i].Operator != In {
+			continue
+		}
+		for _, wh := range whs[i].split() {
+			var col string
+			var vals []interface{}
+			for k, v := range wh.Values {
+				col, vals = k, []interface{}{v}
+				if list, ok := keyList(v); ok {
+					vals = list
+				}
+			}
+			tags := make([]string, 0, len(vals))
+			for _, v := range vals {
+				if !intValue(v) {
+					tags = nil
+					break
+				}
+				tags = append(tags, condTag(table, (&Wh{Operator: Eq, Values: map[string]interface{}{col: v}}).condStr()))
+			}
+			if tags != nil {
+				col = strings.ToLower(col)
+				cols[col] = intersect(cols[col], sortedSet(tags), cols[col] != nil)
+			}
+		}
+	}
+	return cols
+}
+
+// intersect returns the tags in both a and b, or b if a is not given.
+func intersect(a, b []string, given bool) []string {
+	if !given {
+		return b
+	}
+	tags := []string{}
+	for _, tag := range b {
+		for _, t := range a {
+			if t == tag {
+				tags = append(tags, tag)
+				break
+			}
+		}
+	}
+	return tags
+}
+
+// CacheTags returns the tags indexing the cache entry of the SelectClause, which are the table, the joined tables,
+// and the integer values of the Eq and In conditions of each column by colTag and condTag. A TagCacher adds the
+// key to their sets.
+func (sc *SelectClause) CacheTags() []string {
+	tags := []string{tableTag(sc.From)}
+	for col, vals := range colValues(sc.From, sc.Where) {
+		tags = append(append(tags, colTag(sc.From, col)), vals...)
+	}
+
+	// Subqueries are tagged by their tables as joined entries
+	subTables := sc.subTables()
+	if len(sc.Joins) > 0 || len(subTables) > 0 {
+		tags = append(tags, joinTag(sc.From))
+		for i := range sc.Joins {
+			tags = append(tags, joinTag(sc.Joins[i].Table))
+		}
+		for _, table := range subTables {
+			tags = append(tags, joinTag(table))
+		}
+	}
+	return sortedSet(tags)
+}
+
+// tagSkips returns the skip groups of the table tag of a write, see TagCacher. An entry is skipped if it has
+// conditions on a column of the where clauses, by its colTag, and none of their values is a value of the write in
+// the column, by its condTag. Both the old and the new values of the rows of an update are the values of the
+// write, a set column whose old or new value is not known cannot skip the entries.
+func tagSkips(table string, whs []Wh, set map[string]interface{}) [][]string {
+	changed := make(map[string]interface{}, len(set))
+	for col, val := range set {
+		changed[strings.ToLower(col)] = val
+	}
+	cols := colValues(table, whs)
+	names := make([]string, 0, len(cols))
+	for col := range cols {
+		names = append(names, col)
+	}
+	sort.Strings(names)
+
+	var skips [][]string
+	for _, col := range names {
+		vals := cols[col]
+		if val, ok := changed[col]; ok {
+			if !intValue(val) {
+				continue
+			}
+			vals = sortedSet(append(vals, condTag(table, (&Wh{Operator: Eq, Values: map[string]interface{}{col: val}}).condStr())))
+		}
+		skips = append(skips, append([]string{colTag(table, col)}, vals...))
+	}
+	return skips
+}
+
+// ToTags return the tags which can be used to unlink keys in redis by a TagCacher, which are all cache entries of
+// the table and the joined entries involving it. The Executor keeps the entries of other values, see tagSkips.
+func (uc *UpdateClause) ToTags() []string {
+	return []string{tableTag(uc.Update), joinTag(uc.Update)}
+}
+
+// tagSkips implements writeClause.
+func (uc *UpdateClause) tagSkips() map[string][][]string {
+	return map[string][][]string{tableTag(uc.Update): tagSkips(uc.Update, uc.Where, uc.Set)}
+}
+
+// ToTags return the tags which can be used to unlink keys in redis by a TagCacher, which are all cache entries of
+// the table and the joined entries involving it. The Executor keeps the entries of other values, see tagSkips.
+func (dc *DeleteClause) ToTags() []string {
+	return []string{tableTag(dc.From), joinTag(dc.From)}
+}
+
+// tagSkips implements writeClause.
+func (dc *DeleteClause) tagSkips() map[string][][]string {
+	return map[string][][]string{tableTag(dc.From): tagSkips(dc.From, dc.Where, nil)}
+}
+
+// ToTags return the tags which can be used to unlink keys in redis by a TagCacher.
+// All cache entries of the table are included since the new row may appear in any list of the table.
+func (ic *InsertClause) ToTags() []string {
+	return []string{tableTag(ic.Into), joinTag(ic.Into)}
+}
+
+// tagSkips implements writeClause.
+func (ic *InsertClause) tagSkips() map[string][][]string {
+	return nil
+}
+
+// ToTags return the tags which can be used to unlink keys in redis by a TagCacher.
+// All cache entries of the table are included since the restored rows may appear in any list of the table, the
+// Executor keeps the entries of other values like DeleteClause, see tagSkips.
+func (rc *RestoreClause) ToTags() []string {
+	return []string{tableTag(rc.From), joinTag(rc.From)}
+}
+
+// tagSkips implements writeClause.
+func (rc *RestoreClause) tagSkips() map[string][][]string {
+	return map[string][][]string{tableTag(rc.From): tagSkips(rc.From, rc.Where, nil)}
+}
+
+// writeClause is a write clause whose cache entries are unlinked after it is executed.
+type writeClause interface {
+	ToUnlinks() []string
+	ToTags() []string
+	tagSkips() map[string][][]string // tagSkips are the skip groups of the tags, see TagCacher.
+}
+
+// cacheUnlinks are the cache entries unlinked after writes.
+type cacheUnlinks struct {
+	patterns []string              // patterns of the writes, which are replaced by tags with a TagCacher.
+	tags     map[string][][]string // tags map the tags of the writes to their skip groups.
+	keys     []string              // keys are the patterns given explicitly, which are always unlinked by patterns.
+}
+
+// add adds the cache entries of a write clause.
+func (u *cacheUnlinks) add(w writeClause) {
+	u.patterns = append(u.patterns, w.ToUnlinks()...)
+	skips := w.tagSkips()
+	for _, tag := range w.ToTags() {
+		u.addTag(tag, skips[tag])
+	}
+}
+
+// addTag adds a tag with its skip groups. The entries of a tag added by several writes are skipped only if all of
+// them skip the entries, which are kept by the groups of the columns of every write, with the values of any of them.
+func (u *cacheUnlinks) addTag(tag string, skips [][]string) {
+	if u.tags == nil {
+		u.tags = map[string][][]string{}
+	}
+	prev, ok := u.tags[tag]
+	if !ok {
+		u.tags[tag] = skips
+		return
+	}
+	var merged [][]string
+	for _, group := range skips {
+		for _, p := range prev {
+			if p[0] == group[0] {
+				merged = append(merged, append([]string{group[0]}, sortedSet(append(append([]string(nil), p[1:]...), group[1:]...))...))
+			}
+		}
+	}
+	u.tags[tag] = merged
+}
+
+// merge adds the cache entries of other.
+func (u *cacheUnlinks) merge(other cacheUnlinks) {
+	u.patterns = append(u.patterns, other.patterns...)
+	for tag, skips := range other.tags {
+		u.addTag(tag, skips)
+	}
+	u.keys = append(u.keys, other.keys...)
+}
+
+// empty reports whether there is nothing to unlink.
+func (u *cacheUnlinks) empty() bool {
+	return len(u.patterns) == 0 && len(u.tags) == 0 && len(u.keys) == 0
+}
+
+// unlink unlinks the cache entries by tags if the cache is a TagCacher, or by patterns otherwise.
+func (u *cacheUnlinks) unlink(c Cacher) error {
+	tc, ok := c.(TagCacher)
+	if !ok {
+		return c.UnlinkKeys(append(append([]string(nil), u.patterns...), u.keys...))
+	}
+	if len(u.tags) > 0 {
+		tags := make([]string, 0, len(u.tags))
+		skips := map[string][][]string{}
+		for tag, groups := range u.tags {
+			tags = append(tags, tag)
+			if len(groups) > 0 {
+				skips[tag] = groups
+			}
+		}
+		sort.Strings(tags)
+		if err := tc.UnlinkTags(tags, skips); err != nil {
+			return err
+		}
+	}
+	if len(u.keys) > 0 {
+		return c.UnlinkKeys(u.keys)
+	}
+	return nil
+}
+
+// setCache caches the object, with the tags of the entry if the cache is a TagCacher.
+func setCache(c Cacher, key string, obj interface{}, exp time.Duration, tags func() []string) error {
+	if tc, ok := c.(TagCacher); ok {
+		return tc.SetTags(key, obj, exp, tags())
+	}
+	return c.Set(key, obj, exp)
+}
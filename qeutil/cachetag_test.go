@@ -0,0 +1,176 @@
+package qeutil
+
+import (
+	"context"
+	"database/sql/driver"
+	"strings"
+	"testing"
+	"testing/quick"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeTagCache is a fakeCache indexing its keys by tags like rediscli.Client.
+type fakeTagCache struct {
+	*fakeCache
+	tags map[string]map[string]bool
+}
+
+func newFakeTagCache() *fakeTagCache {
+	return &fakeTagCache{fakeCache: newFakeCache(), tags: map[string]map[string]bool{}}
+}
+
+func (fc *fakeTagCache) SetTags(key string, obj interface{}, exp time.Duration, tags []string) error {
+	if err := fc.Set(key, obj, exp); err != nil {
+		return err
+	}
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	for _, tag := range tags {
+		if fc.tags[tag] == nil {
+			fc.tags[tag] = map[string]bool{}
+		}
+		fc.tags[tag][key] = true
+	}
+	return nil
+}
+
+func (fc *fakeTagCache) UnlinkTags(tags []string, skips map[string][][]string) error {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	for _, tag := range tags {
+		for key := range fc.tags[tag] {
+			if !fc.skipped(key, skips[tag]) {
+				delete(fc.items, key)
+				delete(fc.tags[tag], key)
+			}
+		}
+	}
+	return nil
+}
+
+// skipped reports whether the key is skipped by any of the skip groups.
+func (fc *fakeTagCache) skipped(key string, skips [][]string) bool {
+	for _, group := range skips {
+		skip := fc.tags[group[0]][key]
+		for _, tag := range group[1:] {
+			skip = skip && !fc.tags[tag][key]
+		}
+		if skip {
+			return true
+		}
+	}
+	return false
+}
+
+func TestSelectClauseCacheTags(t *testing.T) {
+	sc := SelectClause{
+		From: "answers",
+		Where: []Wh{
+			Wh{Operator: In, Values: map[string]interface{}{"user_id": []int{1, 2}}},
+			Wh{Operator: Eq, Values: map[string]interface{}{"name": "Bob"}},
+			OrWh(
+				Wh{Operator: Eq, Values: map[string]interface{}{"status": "Open"}},
+				Wh{Operator: Gt, Values: map[string]interface{}{"score": 50}},
+			),
+		},
+	}
+	assert.Equal(t, []string{"tag:answers", "tag:answers:{user_id=1}", "tag:answers:{user_id=2}", "tag:answers:{user_id}"}, sc.CacheTags())
+
+	sc = SelectClause{From: "answers", Joins: []Join{Join{Type: InnerJoin, Table: "users"}}, Where: []Wh{ExistsWh(&SelectClause{From: "groups"})}}
+	assert.Equal(t, []string{"tag:answers", "tag:answers:join", "tag:groups:join", "tag:users:join"}, sc.CacheTags())
+}
+
+func TestToTags(t *testing.T) {
+	uc := UpdateClause{
+		Update: "answers",
+		Set:    map[string]interface{}{"user_id": 3, "score": 1},
+		Where:  []Wh{Wh{Operator: Eq, Values: map[string]interface{}{"id": 1, "user_id": []int{1, 2}}}},
+	}
+	assert.Equal(t, []string{"tag:answers", "tag:answers:join"}, uc.ToTags())
+	// Entries of other ids or users are skipped, the users of the rows before and after the update are not
+	assert.Equal(t, map[string][][]string{"tag:answers": {
+		{"tag:answers:{id}", "tag:answers:{id=1}"},
+		{"tag:answers:{user_id}", "tag:answers:{user_id=1}", "tag:answers:{user_id=2}", "tag:answers:{user_id=3}"},
+	}}, uc.tagSkips())
+	uc.Set["user_id"] = Col("owner_id")
+	assert.Equal(t, [][]string{{"tag:answers:{id}", "tag:answers:{id=1}"}}, uc.tagSkips()["tag:answers"])
+
+	dc := DeleteClause{From: "answers", Where: []Wh{NotWh(Wh{Operator: Eq, Values: map[string]interface{}{"id": 1}}), Wh{Operator: Eq, Values: map[string]interface{}{"name": "a"}}}}
+	assert.Equal(t, []string{"tag:answers", "tag:answers:join"}, dc.ToTags())
+	assert.Empty(t, dc.tagSkips()["tag:answers"])
+	assert.Equal(t, []string{"tag:answers", "tag:answers:join"}, (&InsertClause{Into: "answers"}).ToTags())
+}
+
+func TestCacheUnlinksMerge(t *testing.T) {
+	id := func(ids ...int) []Wh { return []Wh{Wh{Operator: In, Values: map[string]interface{}{"id": ids}}} }
+	var u cacheUnlinks
+	u.add(&DeleteClause{From: "answers", Where: append(id(1), Wh{Operator: Eq, Values: map[string]interface{}{"user_id": 7}})})
+	u.add(&DeleteClause{From: "answers", Where: id(2, 3)})
+	// Only the entries skipped by both writes are skipped
+	assert.Equal(t, map[string][][]string{
+		"tag:answers":      {{"tag:answers:{id}", "tag:answers:{id=1}", "tag:answers:{id=2}", "tag:answers:{id=3}"}},
+		"tag:answers:join": nil,
+	}, u.tags)
+	u.add(&InsertClause{Into: "answers"})
+	assert.Nil(t, u.tags["tag:answers"])
+}
+
+func TestExecutorTags(t *testing.T) {
+	db, conn := newFakeDB(func(stm string, args []driver.Value) fakeResp {
+		if strings.HasPrefix(stm, "SELECT COUNT(*)") {
+			return fakeResp{cols: []string{"n"}, rows: [][]driver.Value{{int64(1)}}}
+		}
+		return fakeResp{cols: []string{"id", "name"}, rows: [][]driver.Value{{int64(1), "a"}}, affected: 1}
+	})
+	fc := newFakeTagCache()
+	cc := &CacheConfig{Cache: fc, Expiry: time.Minute}
+	ctx := context.Background()
+	for _, id := range []int{1, 2} {
+		var rows []testRow
+		sc := SelectClause{From: "table", Where: []Wh{Wh{Operator: Eq, Values: map[string]interface{}{"id": id}}}}
+		assert.NoError(t, sc.QueryWithCache(ctx, db, cc, &rows))
+	}
+	var n int64
+	assert.NoError(t, (&SelectClause{From: "table"}).Count().GetWithCache(ctx, db, cc, &n))
+	assert.Equal(t, []string{"table:agg:count(*)", "table:where:{id=1}", "table:where:{id=2}"}, fc.keys())
+
+	// The unfiltered count may contain the row, the entry of another id does not
+	e := Executor{DB: db, Cache: fc}
+	_, err := e.Update(ctx, &UpdateClause{Update: "table", Set: map[string]interface{}{"name": "b"}, Where: []Wh{Wh{Operator: Eq, Values: map[string]interface{}{"id": 2}}}})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"table:where:{id=1}"}, fc.keys())
+	assert.Zero(t, fc.unlinks)
+
+	// The row of the new id appears in its entry
+	_, err = e.Update(ctx, &UpdateClause{Update: "table", Set: map[string]interface{}{"id": 1}, Where: []Wh{Wh{Operator: Eq, Values: map[string]interface{}{"id": 3}}}})
+	assert.NoError(t, err)
+	assert.Empty(t, fc.keys())
+	var rows []testRow
+	assert.NoError(t, (&SelectClause{From: "table", Where: []Wh{Wh{Operator: Eq, Values: map[string]interface{}{"id": 1}}}}).QueryWithCache(ctx, db, cc, &rows))
+
+	assert.NoError(t, WithTx(ctx, db, &TxOptions{Cache: fc}, func(tx *Tx) error {
+		_, err := tx.Insert(ctx, &InsertClause{Into: "table", Values: map[string]interface{}{"name": "c"}})
+		return err
+	}))
+	assert.Empty(t, fc.keys())
+	assert.Len(t, conn.statements(), 9)
+}
+
+func TestUnlinkTagsProperty(t *testing.T) {
+	// Tags unlink exactly the related entries
+	f := func(c unlinkCase) bool {
+		fc := newFakeTagCache()
+		key := c.sc.CacheKey()
+		fc.SetTags(key, 1, time.Minute, c.sc.CacheTags())
+		var u cacheUnlinks
+		u.add(c.write())
+		u.unlink(fc)
+		return len(fc.keys()) == 0 == c.related()
+	}
+	if err := quick.Check(f, &quick.Config{MaxCount: 5000}); err != nil {
+		c := err.(*quick.CheckError).In[0].(unlinkCase)
+		t.Errorf("%v: tags %v, write %v %v", err, c.sc.CacheTags(), c.write().ToTags(), c.write().tagSkips())
+	}
+}
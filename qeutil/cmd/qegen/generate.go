@@ -0,0 +1,204 @@
+package main
+
+import (
+	"fmt"
+	"go/format"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/henrycheung19/pkg/qeutil"
+)
+
+// commonInitialisms are written in upper case in the Go names, e.g. `user_id` is UserID.
+var commonInitialisms = map[string]bool{
+	"api": true, "ascii": true, "cpu": true, "css": true, "dns": true, "guid": true, "html": true, "http": true,
+	"https": true, "id": true, "ip": true, "json": true, "sku": true, "sql": true, "ssh": true, "tcp": true,
+	"tls": true, "ttl": true, "uid": true, "ui": true, "uri": true, "url": true, "utf8": true, "uuid": true,
+	"xml": true,
+}
+
+// reservedNames are the methods of the generated descriptors, which are not used by their columns.
+var reservedNames = map[string]bool{"Table": true, "Columns": true}
+
+// goName returns the exported Go name of a table or column, e.g. `Answers` for `answers` and `UserID` for
+// `user_id`.
+func goName(s string) string {
+	parts := strings.FieldsFunc(s, func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) })
+	buf := strings.Builder{}
+	for _, p := range parts {
+		if commonInitialisms[strings.ToLower(p)] {
+			buf.WriteString(strings.ToUpper(p))
+			continue
+		}
+		r := []rune(p)
+		r[0] = unicode.ToUpper(r[0])
+		buf.WriteString(string(r))
+	}
+	name := buf.String()
+	if name == "" || !unicode.IsUpper([]rune(name)[0]) {
+		name = "X" + name
+	}
+	return name
+}
+
+// uniqueName returns the name, or the name with the first free numeric suffix if it is used, and marks it used.
+func uniqueName(name string, used map[string]bool) string {
+	unique := name
+	for i := 2; used[unique]; i++ {
+		unique = name + strconv.Itoa(i)
+	}
+	used[unique] = true
+	return unique
+}
+
+// columnKind returns the kind of the typed field of a column, e.g. Int64 for qeutil.Int64Field. Unsigned bigint
+// values above the range of int64 are not supported, and decimals are strings keeping their precision.
+func columnKind(c qeutil.ColumnInfo) string {
+	switch c.DataType {
+	case "tinyint":
+		if strings.HasPrefix(c.ColumnType, "tinyint(1)") {
+			return "Bool"
+		}
+		return "Int64"
+	case "bool", "boolean":
+		return "Bool"
+	case "smallint", "mediumint", "int", "integer", "bigint", "year":
+		return "Int64"
+	case "float", "double", "real":
+		return "Float64"
+	case "decimal", "numeric", "char", "varchar", "tinytext", "text", "mediumtext", "longtext", "enum", "set",
+		"json", "time":
+		return "String"
+	case "date", "datetime", "timestamp":
+		return "Time"
+	default:
+		return "Bytes"
+	}
+}
+
+// goTypes are the Go types of the row structs of each kind, not nullable and nullable.
+var goTypes = map[string][2]string{
+	"Int64":   {"int64", "sql.NullInt64"},
+	"Float64": {"float64", "sql.NullFloat64"},
+	"String":  {"string", "sql.NullString"},
+	"Bool":    {"bool", "sql.NullBool"},
+	"Time":    {"time.Time", "sql.NullTime"},
+	"Bytes":   {"[]byte", "[]byte"},
+}
+
+// genColumn is a column with its Go names.
+type genColumn struct {
+	qeutil.ColumnInfo
+	Field string // Field is the name of the column in the descriptor and the row struct.
+	Kind  string
+	Type  string // Type is the Go type of the column in the row struct.
+}
+
+// genTable is a table with its Go names.
+type genTable struct {
+	Name    string
+	Go      string
+	Columns []genColumn
+}
+
+// generate returns the formatted source of the package declaring the descriptors, row structs and Schema of the
+// tables. The source describes the origin of the schema in the header.
+func generate(pkg, source string, tables []qeutil.TableInfo) ([]byte, error) {
+	globals := map[string]string{"Schema": "the schema"}
+	gts := make([]genTable, len(tables))
+	imports := map[string]bool{}
+	for i, t := range tables {
+		gt := genTable{Name: t.Name, Go: goName(t.Name)}
+		for _, name := range []string{gt.Go, gt.Go + "Table", "New" + gt.Go + "Table", gt.Go + "Row"} {
+			if other, ok := globals[name]; ok {
+				return nil, fmt.Errorf("table %v: name %v is already declared for %v", t.Name, name, other)
+			}
+			globals[name] = "table " + t.Name
+		}
+		used := map[string]bool{}
+		for name := range reservedNames {
+			used[name] = true
+		}
+		for _, c := range t.Columns {
+			gc := genColumn{ColumnInfo: c, Field: uniqueName(goName(c.Name), used), Kind: columnKind(c)}
+			if c.Nullable {
+				gc.Type = goTypes[gc.Kind][1]
+			} else {
+				gc.Type = goTypes[gc.Kind][0]
+			}
+			if strings.HasPrefix(gc.Type, "sql.") {
+				imports["database/sql"] = true
+			}
+			if gc.Type == "time.Time" {
+				imports["time"] = true
+			}
+			gt.Columns = append(gt.Columns, gc)
+		}
+		gts[i] = gt
+	}
+
+	buf := strings.Builder{}
+	fmt.Fprintf(&buf, "// Code generated by qegen from %v. DO NOT EDIT.\n\npackage %v\n\nimport (\n", source, pkg)
+	for _, imp := range []string{"database/sql", "time"} {
+		if imports[imp] {
+			fmt.Fprintf(&buf, "%q\n", imp)
+		}
+	}
+	buf.WriteString("\n\"github.com/henrycheung19/pkg/qeutil\"\n)\n\n")
+	buf.WriteString("// Schema allows the tables and columns of the descriptors, see qeutil.Schema.\nvar Schema = qeutil.Schema{\n")
+	for _, gt := range gts {
+		cols := make([]string, len(gt.Columns))
+		for i := range gt.Columns {
+			cols[i] = strconv.Quote(gt.Columns[i].Name)
+		}
+		fmt.Fprintf(&buf, "%q: {%v},\n", gt.Name, strings.Join(cols, ", "))
+	}
+	buf.WriteString("}\n")
+	for _, gt := range gts {
+		writeTable(&buf, gt)
+	}
+
+	src, err := format.Source([]byte(buf.String()))
+	if err != nil {
+		return nil, fmt.Errorf("format generated source: %w", err)
+	}
+	return src, nil
+}
+
+// writeTable writes the descriptor and row struct of a table.
+func writeTable(buf *strings.Builder, gt genTable) {
+	fmt.Fprintf(buf, "\n// %vTable is the descriptor of the table %v.\ntype %vTable struct {\ntable qeutil.TableName\n", gt.Go, gt.Name, gt.Go)
+	for _, c := range gt.Columns {
+		fmt.Fprintf(buf, "%v qeutil.%vField\n", c.Field, c.Kind)
+	}
+	buf.WriteString("}\n")
+
+	fmt.Fprintf(buf, "\n// %v is the descriptor of the table %v, whose columns are not qualified.\nvar %v = New%vTable(\"\")\n", gt.Go, gt.Name, gt.Go, gt.Go)
+	fmt.Fprintf(buf, "\n// New%vTable returns the descriptor of the table %v, whose columns are qualified by the alias if given.\n", gt.Go, gt.Name)
+	fmt.Fprintf(buf, "func New%vTable(alias string) %vTable {\nt := qeutil.TableName{Name: %q, Alias: alias}\nreturn %vTable{\ntable: t,\n", gt.Go, gt.Go, gt.Name, gt.Go)
+	for _, c := range gt.Columns {
+		fmt.Fprintf(buf, "%v: qeutil.%vField{Field: t.Field(%q)},\n", c.Field, c.Kind, c.Name)
+	}
+	buf.WriteString("}\n}\n")
+
+	fmt.Fprintf(buf, "\n// Table returns the name and alias of the table.\nfunc (t %vTable) Table() qeutil.TableName {\nreturn t.table\n}\n", gt.Go)
+	fmt.Fprintf(buf, "\n// Columns returns the select list of all columns of the table.\nfunc (t %vTable) Columns() []string {\nreturn qeutil.Columns(", gt.Go)
+	for i, c := range gt.Columns {
+		if i > 0 {
+			buf.WriteString(", ")
+		}
+		fmt.Fprintf(buf, "t.%v.Field", c.Field)
+	}
+	buf.WriteString(")\n}\n")
+
+	fmt.Fprintf(buf, "\n// %vRow is a row of the table %v.\ntype %vRow struct {\n", gt.Go, gt.Name, gt.Go)
+	for _, c := range gt.Columns {
+		tag := c.Name
+		if c.PrimaryKey {
+			tag += ",pk"
+		}
+		fmt.Fprintf(buf, "%v %v `db:%q`\n", c.Field, c.Type, tag)
+	}
+	buf.WriteString("}\n")
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/henrycheung19/pkg/qeutil"
+)
+
+func TestGoName(t *testing.T) {
+	for s, name := range map[string]string{
+		"answers": "Answers", "user_id": "UserID", "userId": "UserId", "api_url": "APIURL", "2fa_secret": "X2faSecret",
+		"order-items": "OrderItems", "_": "X",
+	} {
+		assert.Equal(t, name, goName(s), s)
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	tables := []qeutil.TableInfo{
+		qeutil.TableInfo{Name: "answers", Columns: []qeutil.ColumnInfo{
+			qeutil.ColumnInfo{Name: "id", DataType: "bigint", ColumnType: "bigint(20) unsigned", PrimaryKey: true, AutoIncrement: true},
+			qeutil.ColumnInfo{Name: "is_public", DataType: "tinyint", ColumnType: "tinyint(1)"},
+			qeutil.ColumnInfo{Name: "score", DataType: "decimal", ColumnType: "decimal(10,2)", Nullable: true},
+			qeutil.ColumnInfo{Name: "created_at", DataType: "datetime", ColumnType: "datetime"},
+			qeutil.ColumnInfo{Name: "deleted_at", DataType: "timestamp", ColumnType: "timestamp", Nullable: true},
+			qeutil.ColumnInfo{Name: "table", DataType: "varchar", ColumnType: "varchar(10)"},
+			qeutil.ColumnInfo{Name: "Table", DataType: "blob", ColumnType: "blob"},
+		}},
+	}
+	src, err := generate("models", "schema.sql", tables)
+	assert.NoError(t, err)
+	for _, s := range []string{
+		"// Code generated by qegen from schema.sql. DO NOT EDIT.\n\npackage models\n",
+		"\"database/sql\"\n\t\"time\"\n\n\t\"github.com/henrycheung19/pkg/qeutil\"\n",
+		`"answers": {"id", "is_public", "score", "created_at", "deleted_at", "table", "Table"},`,
+		"ID        qeutil.Int64Field\n",
+		"IsPublic  qeutil.BoolField\n",
+		"Table2    qeutil.StringField\n",
+		"Table3    qeutil.BytesField\n",
+		"var Answers = NewAnswersTable(\"\")\n",
+		"t := qeutil.TableName{Name: \"answers\", Alias: alias}\n",
+		"DeletedAt: qeutil.TimeField{Field: t.Field(\"deleted_at\")},\n",
+		"return qeutil.Columns(t.ID.Field, t.IsPublic.Field, t.Score.Field, t.CreatedAt.Field, t.DeletedAt.Field, t.Table2.Field, t.Table3.Field)\n",
+		"ID        int64          `db:\"id,pk\"`\n",
+		"Score     sql.NullString `db:\"score\"`\n",
+		"CreatedAt time.Time      `db:\"created_at\"`\n",
+		"DeletedAt sql.NullTime   `db:\"deleted_at\"`\n",
+	} {
+		assert.True(t, strings.Contains(string(src), s), s)
+	}
+
+	_, err = generate("models", "schema.sql", append(tables, qeutil.TableInfo{Name: "Answers"}))
+	assert.EqualError(t, err, "table Answers: name Answers is already declared for table answers")
+	_, err = generate("models", "schema.sql", []qeutil.TableInfo{qeutil.TableInfo{Name: "schema"}})
+	assert.EqualError(t, err, "table schema: name Schema is already declared for the schema")
+}
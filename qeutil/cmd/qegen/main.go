@@ -0,0 +1,142 @@
+// Command qegen generates typed table descriptors for qeutil from a MySQL schema, read from a DDL file, e.g. the
+// output of `mysqldump --no-data`, or from information_schema of a database. Each table gets:
+//
+//   - a descriptor whose typed fields build conditions, orders and values, e.g. `Answers.UserID.Eq(1)`,
+//     so that renamed or retyped columns fail at compile time instead of at runtime;
+//   - a row struct with `db` tags for sqlx and the struct helpers of qeutil;
+//   - its columns in the Schema allow-list of the package.
+//
+// Usage:
+//
+//	//go:generate go run github.com/henrycheung19/pkg/qeutil/cmd/qegen -ddl schema.sql -o tables_gen.go
+//
+// or, reading the database given by the DSN, which defaults to $QEGEN_DSN to keep credentials out of the source:
+//
+//	qegen -dsn 'user:pass@tcp(localhost:3306)/app' -tables answers,users -o tables_gen.go
+//
+// Date and time columns are time.Time, which requires `parseTime=true` in the DSN of the application.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jmoiron/sqlx"
+
+	"github.com/henrycheung19/pkg/qeutil"
+)
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("qegen: ")
+	var (
+		ddl    = flag.String("ddl", "", "read the schema from the CREATE TABLE statements of the DDL file")
+		dsn    = flag.String("dsn", os.Getenv("QEGEN_DSN"), "read the schema from the MySQL database of the DSN")
+		dbName = flag.String("db", "", "the database to read, the database of the DSN if not given")
+		tables = flag.String("tables", "", "comma-separated tables to generate, all tables if not given")
+		pkg    = flag.String("pkg", os.Getenv("GOPACKAGE"), "the package of the generated file")
+		out    = flag.String("o", "", "the generated file, standard output if not given")
+	)
+	flag.Parse()
+	if *pkg == "" {
+		*pkg = "models"
+	}
+
+	var (
+		infos  []qeutil.TableInfo
+		source string
+		err    error
+	)
+	switch {
+	case *ddl != "":
+		infos, err = readDDL(*ddl)
+		source = filepath.Base(*ddl)
+	case *dsn != "":
+		infos, source, err = readDB(*dsn, *dbName)
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *tables != "" {
+		if infos, err = filterTables(infos, strings.Split(*tables, ",")); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	src, err := generate(*pkg, source, infos)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *out == "" {
+		_, err = os.Stdout.Write(src)
+	} else {
+		err = ioutil.WriteFile(*out, src, 0644)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// readDDL reads the tables of a DDL file.
+func readDDL(path string) ([]qeutil.TableInfo, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return qeutil.ParseDDL(string(b))
+}
+
+// readDB reads the tables of a database, and returns them with the description of the source without the
+// credentials of the DSN.
+func readDB(dsn, dbName string) ([]qeutil.TableInfo, string, error) {
+	cfg, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		return nil, "", err
+	}
+	if dbName == "" {
+		dbName = cfg.DBName
+	}
+	if dbName == "" {
+		return nil, "", fmt.Errorf("no database given by -db or the DSN")
+	}
+	db, err := sqlx.Open("mysql", dsn)
+	if err != nil {
+		return nil, "", err
+	}
+	defer db.Close()
+	infos, err := qeutil.LoadSchema(context.Background(), db, dbName)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(infos) == 0 {
+		return nil, "", fmt.Errorf("no tables in database %v", dbName)
+	}
+	return infos, "database " + dbName, nil
+}
+
+// filterTables returns the given tables in their order, which must all exist.
+func filterTables(infos []qeutil.TableInfo, names []string) ([]qeutil.TableInfo, error) {
+	byName := make(map[string]qeutil.TableInfo, len(infos))
+	for _, t := range infos {
+		byName[t.Name] = t
+	}
+	filtered := make([]qeutil.TableInfo, 0, len(names))
+	for _, name := range names {
+		t, ok := byName[strings.TrimSpace(name)]
+		if !ok {
+			return nil, fmt.Errorf("table %v not found", name)
+		}
+		filtered = append(filtered, t)
+	}
+	return filtered, nil
+}
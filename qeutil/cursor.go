@@ -0,0 +1,219 @@
+package qeutil
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql/driver"
+	"encoding/base64"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+)
+
+// CursorKey signs the pagination cursors so that they cannot be tampered by clients, it must be set to a secret
+// of the service before any cursor is used.
+var CursorKey []byte
+
+var (
+	// ErrInvalidCursor is returned when a cursor is malformed, tampered or not issued for the SelectClause.
+	ErrInvalidCursor = errors.New("invalid pagination cursor")
+
+	// errNoCursorKey is returned when CursorKey is not set.
+	errNoCursorKey = errors.New("qeutil.CursorKey is not set")
+
+	// errNoOrder is returned when cursor pagination is used without OrderBy.
+	errNoOrder = errors.New("cursor pagination requires OrderBy")
+
+	// errNullOrder is returned when an order column of the row issuing a cursor is null.
+	errNullOrder = errors.New("cursor pagination requires NOT NULL order columns")
+)
+
+func init() {
+	gob.Register(time.Time{})
+}
+
+// cursorData is the payload of a pagination cursor, which is bound to the table and the where clauses of the query
+// issuing it by their cache key forms, the where clauses are hashed to keep the cursor short.
+type cursorData struct {
+	Table   string
+	Where   string
+	Columns []string
+	Values  []interface{}
+}
+
+// cursorSign returns the signature of a cursor payload.
+func cursorSign(payload []byte) ([]byte, error) {
+	if len(CursorKey) == 0 {
+		return nil, errNoCursorKey
+	}
+	mac := hmac.New(sha256.New, CursorKey)
+	mac.Write(payload)
+	return mac.Sum(nil), nil
+}
+
+// NextCursor returns the cursor of the page after the given row, which is the last row of the current page.
+// The row is either a struct with `db` tags or a map of columns, and must contain every column in OrderBy.
+// Columns qualified by a table, e.g. `t.id`, are looked up by their own name if they are not found. Values
+// implementing driver.Valuer, e.g. sql.NullInt64, are stored as their driver values.
+// The order columns must be NOT NULL, since no row compares greater or less than NULL and the pages would end at
+// the first null value silently, a row with a null order column is refused.
+// The cursor is only accepted by the queries on the same table with the same where clauses.
+func (sc *SelectClause) NextCursor(row interface{}) (string, error) {
+	if len(sc.OrderBy) == 0 {
+		return "", errNoOrder
+	}
+
+	values, ok := row.(map[string]interface{})
+	if !ok {
+		fields, err := structFields(row, nil)
+		if err != nil {
+			return "", err
+		}
+		values = make(map[string]interface{}, len(fields))
+		for _, field := range fields {
+			values[field.col] = field.val
+		}
+	}
+
+	data := cursorData{
+		Table:   keyIdent(sc.From),
+		Where:   keyHash(whsKey(sc.Where)),
+		Columns: make([]string, len(sc.OrderBy)),
+		Values:  make([]interface{}, len(sc.OrderBy)),
+	}
+	for i, ord := range sc.OrderBy {
+		val, ok := values[ord.Col]
+		if !ok {
+			val, ok = values[ord.Col[strings.LastIndex(ord.Col, ".")+1:]]
+		}
+		if !ok {
+			return "", fmt.Errorf("row has no value of the order column %v", ord.Col)
+		}
+		if v, ok := val.(driver.Valuer); ok {
+			var err error
+			if val, err = v.Value(); err != nil {
+				return "", err
+			}
+		}
+		if rv := reflect.ValueOf(val); !rv.IsValid() || rv.Kind() == reflect.Ptr && rv.IsNil() {
+			return "", fmt.Errorf("%w: %v is null", errNullOrder, ord.Col)
+		}
+		data.Columns[i] = ord.String()
+		data.Values[i] = val
+	}
+
+	buf := bytes.Buffer{}
+	if err := gob.NewEncoder(&buf).Encode(&data); err != nil {
+		return "", err
+	}
+	sig, err := cursorSign(buf.Bytes())
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf.Bytes()) + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+// parseCursor verifies the cursor and returns its payload and signature.
+func parseCursor(cursor string) (*cursorData, []byte, error) {
+	i := strings.Index(cursor, ".")
+	if i < 0 {
+		return nil, nil, ErrInvalidCursor
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(cursor[:i])
+	if err != nil {
+		return nil, nil, ErrInvalidCursor
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(cursor[i+1:])
+	if err != nil {
+		return nil, nil, ErrInvalidCursor
+	}
+	want, err := cursorSign(payload)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !hmac.Equal(sig, want) {
+		return nil, nil, ErrInvalidCursor
+	}
+
+	var data cursorData
+	if err := gob.NewDecoder(bytes.NewReader(payload)).Decode(&data); err != nil {
+		return nil, nil, ErrInvalidCursor
+	}
+	return &data, sig, nil
+}
+
+// cursorKey returns the cache key segment of the After cursor.
+func (sc *SelectClause) cursorKey() string {
+	i := strings.LastIndex(sc.After, ".")
+	sig, err := base64.RawURLEncoding.DecodeString(sc.After[i+1:])
+	if i < 0 || err != nil {
+		// Invalid cursors are rejected by SQLStm, keep them apart from each other anyway
+		return hex.EncodeToString([]byte(sc.After))
+	}
+	return hex.EncodeToString(sig)
+}
+
+// afterSqlizer returns the where pred selecting the rows after the After cursor, e.g. `(a, b) > (?, ?)` when
+// all columns are in the same direction, or `a > ? OR (a = ? AND b < ?)` otherwise.
+func (sc *SelectClause) afterSqlizer(q *idents) (sq.Sqlizer, error) {
+	cols := sc.OrderBy
+	if len(cols) == 0 {
+		return nil, errNoOrder
+	}
+	if sc.Offset != nil {
+		return nil, errors.New("cursor pagination cannot be used with Offset")
+	}
+	data, _, err := parseCursor(sc.After)
+	if err != nil {
+		return nil, err
+	}
+	if data.Table != keyIdent(sc.From) || data.Where != keyHash(whsKey(sc.Where)) || len(data.Columns) != len(sc.OrderBy) {
+		return nil, ErrInvalidCursor
+	}
+	for i := range data.Columns {
+		if data.Columns[i] != sc.OrderBy[i].String() {
+			return nil, ErrInvalidCursor
+		}
+	}
+
+	sameDir := true
+	names := make([]string, len(cols))
+	for i := range cols {
+		if names[i], err = q.col(cols[i].Col); err != nil {
+			return nil, err
+		}
+		sameDir = sameDir && cols[i].Desc == cols[0].Desc
+	}
+	if sameDir {
+		opr := ">"
+		if cols[0].Desc {
+			opr = "<"
+		}
+		if len(cols) == 1 {
+			return sq.Expr(fmt.Sprintf("%v %v ?", names[0], opr), data.Values...), nil
+		}
+		return sq.Expr(fmt.Sprintf("(%v) %v (%v)", strings.Join(names, ", "), opr, sq.Placeholders(len(cols))), data.Values...), nil
+	}
+
+	or := sq.Or{}
+	for i := range cols {
+		and := sq.And{}
+		for j := 0; j < i; j++ {
+			and = append(and, sq.Expr(names[j]+" = ?", data.Values[j]))
+		}
+		opr := " > ?"
+		if cols[i].Desc {
+			opr = " < ?"
+		}
+		and = append(and, sq.Expr(names[i]+opr, data.Values[i]))
+		or = append(or, and)
+	}
+	return or, nil
+}
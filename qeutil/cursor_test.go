@@ -0,0 +1,117 @@
+package qeutil
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSelectClauseCursor(t *testing.T) {
+	CursorKey = []byte("secret")
+	defer func() { CursorKey = nil }()
+
+	limit := 20
+	sc := SelectClause{
+		From:    "answers",
+		Where:   []Wh{Wh{Operator: Eq, Values: map[string]interface{}{"exam_id": 3}}},
+		OrderBy: []Order{Asc("created_at"), Asc("id")},
+		Limit:   &limit,
+	}
+	createdAt := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	cursor, err := sc.NextCursor(struct {
+		ID        int64     `db:"id"`
+		CreatedAt time.Time `db:"created_at"`
+	}{10, createdAt})
+	assert.NoError(t, err)
+
+	sc.After = cursor
+	stm, val, err := sc.SQLStm()
+	assert.NoError(t, err)
+	assert.Equal(t, "SELECT * FROM `answers` WHERE `exam_id` = ? AND (`created_at`, `id`) > (?,?) ORDER BY `created_at`, `id` LIMIT 20", stm)
+	assert.Equal(t, []interface{}{3, createdAt, int64(10)}, val)
+	assert.Contains(t, sc.CacheKey(), ":aft:")
+	assert.NotEqual(t, (&SelectClause{From: "answers", OrderBy: sc.OrderBy, Limit: &limit}).CacheKey(), sc.CacheKey())
+}
+
+func TestSelectClauseCursorMixed(t *testing.T) {
+	CursorKey = []byte("secret")
+	defer func() { CursorKey = nil }()
+
+	sc := SelectClause{From: "answers", OrderBy: []Order{Desc("a.score"), Asc("a.id")}}
+	cursor, err := sc.NextCursor(map[string]interface{}{"score": 50, "id": 7})
+	assert.NoError(t, err)
+
+	sc.After = cursor
+	stm, val, err := sc.SQLStm()
+	assert.NoError(t, err)
+	assert.Equal(t, "SELECT * FROM `answers` WHERE ((`a`.`score` < ?) OR (`a`.`score` = ? AND `a`.`id` > ?)) ORDER BY `a`.`score` DESC, `a`.`id`", stm)
+	assert.Equal(t, []interface{}{50, 50, 7}, val)
+}
+
+func TestSelectClauseCursorInvalid(t *testing.T) {
+	CursorKey = []byte("secret")
+	defer func() { CursorKey = nil }()
+
+	sc := SelectClause{From: "answers", OrderBy: []Order{Asc("id")}}
+	cursor, err := sc.NextCursor(map[string]interface{}{"id": 7})
+	assert.NoError(t, err)
+
+	// Tampered payload
+	sc.After = "x" + cursor
+	_, _, err = sc.SQLStm()
+	assert.Equal(t, ErrInvalidCursor, err)
+
+	// Cursor of another order
+	sc.After = cursor
+	sc.OrderBy = []Order{Desc("id")}
+	_, _, err = sc.SQLStm()
+	assert.Equal(t, ErrInvalidCursor, err)
+
+	// Cursor signed by another key
+	sc.OrderBy = []Order{Asc("id")}
+	CursorKey = []byte("other")
+	_, _, err = sc.SQLStm()
+	assert.Equal(t, ErrInvalidCursor, err)
+
+	// Cursor of another table or filter with the same order
+	CursorKey = []byte("secret")
+	_, _, err = (&SelectClause{From: "users", OrderBy: sc.OrderBy, After: cursor}).SQLStm()
+	assert.Equal(t, ErrInvalidCursor, err)
+	_, _, err = (&SelectClause{From: "answers", Where: []Wh{Wh{Operator: Eq, Values: map[string]interface{}{"user_id": 1}}}, OrderBy: sc.OrderBy, After: cursor}).SQLStm()
+	assert.Equal(t, ErrInvalidCursor, err)
+
+	_, err = sc.NextCursor(map[string]interface{}{"name": "a"})
+	assert.Error(t, err)
+}
+
+func TestSelectClauseCursorValuer(t *testing.T) {
+	CursorKey = []byte("secret")
+	defer func() { CursorKey = nil }()
+
+	sc := SelectClause{From: "answers", OrderBy: []Order{Asc("name"), Asc("score"), Asc("id")}}
+	cursor, err := sc.NextCursor(struct {
+		ID    int64          `db:"id"`
+		Name  sql.NullString `db:"name"`
+		Score sql.NullInt64  `db:"score"`
+	}{7, sql.NullString{String: "bob", Valid: true}, sql.NullInt64{Int64: 50, Valid: true}})
+	assert.NoError(t, err)
+
+	sc.After = cursor
+	_, val, err := sc.SQLStm()
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{"bob", int64(50), int64(7)}, val)
+
+	// Rows after a null value could not be compared with it
+	_, err = sc.NextCursor(struct {
+		ID    int64          `db:"id"`
+		Name  sql.NullString `db:"name"`
+		Score sql.NullInt64  `db:"score"`
+	}{7, sql.NullString{String: "bob", Valid: true}, sql.NullInt64{}})
+	assert.True(t, errors.Is(err, errNullOrder))
+	var name *string
+	_, err = sc.NextCursor(map[string]interface{}{"id": 7, "name": name, "score": 50})
+	assert.True(t, errors.Is(err, errNullOrder))
+}
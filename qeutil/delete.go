@@ -1,31 +1,78 @@
 package qeutil
 
 import (
-	"fmt"
+	"context"
 
 	sq "github.com/Masterminds/squirrel"
+	"github.com/jmoiron/sqlx"
 )
 
 // DeleteClause .
+// The rows of a soft-deleted table are marked deleted, e.g. `UPDATE t SET deleted_at = NOW()`, unless Force is set.
+// A delete without conditions in Where is refused with ErrFullTable unless AllowFullTable is set.
 type DeleteClause struct {
-	From  string
-	Where []Wh
+	From           string
+	Where          []Wh
+	OrderBy        []Order // OrderBy orders the deleted rows with Limit, it is only supported by MySQL.
+	Limit          *int    // Limit bounds the number of deleted rows, it is only supported by MySQL.
+	Force          bool    // Force deletes the rows of a soft-deleted table from the table.
+	AllowFullTable bool    // AllowFullTable allows the deletion of every row of the table.
+	Dialect        Dialect // Dialect of the statement, MySQL if not given.
+	Schema         Schema  // Schema limits the table and columns of the statement if given.
 }
 
-// SQLStm return a MySQL query statment from the DeleteClause.
+// SQLStm return a query statment of the Dialect from the DeleteClause.
 func (dc *DeleteClause) SQLStm() (string, []interface{}, error) {
-	builder := sq.Delete(dc.From)
+	if err := dc.Dialect.check(); err != nil {
+		return "", nil, err
+	}
+	q := newIdents(dc.Dialect, dc.Schema)
+	table, err := q.table(dc.From, "")
+	if err != nil {
+		return "", nil, err
+	}
+	if err := checkFullTable(dc.From, dc.Where, dc.AllowFullTable); err != nil {
+		return "", nil, err
+	}
+	orders, err := writeOrders(q, dc.From, dc.OrderBy, dc.Limit)
+	if err != nil {
+		return "", nil, err
+	}
+	if col, ok := SoftDeleteColumn(dc.From); ok && !dc.Force {
+		// Rows deleted before keep their deletion time
+		builder := sq.Update(table).PlaceholderFormat(dc.Dialect.placeholder()).
+			Set(dc.Dialect.Quote(col), sq.Expr(dc.Dialect.now()))
+		for i := range dc.Where {
+			builder = builder.Where(dc.Where[i].toWhBuilder(q))
+		}
+		builder = builder.Where(trashedExpr(dc.Dialect, dc.From, "", false)).OrderBy(orders...)
+		if dc.Limit != nil {
+			builder = builder.Limit(uint64(*dc.Limit))
+		}
+		return builder.ToSql()
+	}
+	builder := sq.Delete(table).PlaceholderFormat(dc.Dialect.placeholder())
 	for i := range dc.Where {
-		builder = builder.Where(dc.Where[i].ToWhBuilder())
+		builder = builder.Where(dc.Where[i].toWhBuilder(q))
+	}
+	builder = builder.OrderBy(orders...)
+	if dc.Limit != nil {
+		builder = builder.Limit(uint64(*dc.Limit))
 	}
 	return builder.ToSql()
 }
 
-// ToUnlinks return an array of wh's ToStr() function result which can be used to unlink keys in redis.
+// ToUnlinks return the patterns which can be used to unlink keys in redis, which are all cache entries of the table
+// and the joined SelectClause cache entries involving it, see tableUnlinkPatterns.
 func (dc *DeleteClause) ToUnlinks() []string {
-	whsStr := make([]string, len(dc.Where))
-	for i := range dc.Where {
-		whsStr[i] = fmt.Sprintf("%v:*[:&]%v", dc.From, dc.Where[i].ToStr())
+	return tableUnlinkPatterns(dc.From)
+}
+
+// Exec executes the DeleteClause and returns the number of affected rows, ErrNotChanged is returned when no row is affected.
+func (dc *DeleteClause) Exec(ctx context.Context, db sqlx.ExtContext) (int64, error) {
+	stm, val, err := dc.SQLStm()
+	if err != nil {
+		return 0, err
 	}
-	return whsStr
+	return execAffected(ctx, db, OpDelete, dc.From, stm, val)
 }
@@ -1,6 +1,9 @@
 package qeutil
 
 import (
+	"context"
+	"database/sql/driver"
+	"errors"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -16,6 +19,37 @@ func TestDeleteClauseSQLStm(t *testing.T) {
 		},
 	}
 	stm, val, _ := dc.SQLStm()
-	assert.Equal(t, "DELETE FROM table WHERE in_comp IN (?,?,?) AND gt_comp > ? AND lt_comp < ?", stm)
+	assert.Equal(t, "DELETE FROM `table` WHERE `in_comp` IN (?,?,?) AND `gt_comp` > ? AND `lt_comp` < ?", stm)
 	assert.Equal(t, []interface{}{"hello", "world", "!", 1, 2}, val)
 }
+
+func TestDeleteClauseSQLStmUnknownOperator(t *testing.T) {
+	dc := DeleteClause{
+		From: "table",
+		Where: []Wh{
+			Wh{Operator: "=<", Values: map[string]interface{}{"id": 1}},
+		},
+	}
+	stm, _, err := dc.SQLStm()
+	assert.True(t, errors.Is(err, ErrUnknownOperator))
+	assert.Empty(t, stm)
+
+	dc.Where = []Wh{OrWh(Wh{Operator: "equal", Values: map[string]interface{}{"id": 1}})}
+	_, _, err = dc.SQLStm()
+	assert.True(t, errors.Is(err, ErrUnknownOperator))
+}
+
+func TestDeleteClauseExec(t *testing.T) {
+	db, _ := newFakeDB(func(stm string, args []driver.Value) fakeResp {
+		return fakeResp{}
+	})
+	tx, err := db.BeginTxx(context.Background(), nil)
+	assert.NoError(t, err)
+	dc := DeleteClause{
+		From:  "table",
+		Where: []Wh{Wh{Operator: Eq, Values: map[string]interface{}{"id": 1}}},
+	}
+	_, err = dc.Exec(context.Background(), tx)
+	assert.Equal(t, ErrNotChanged, err)
+	assert.NoError(t, tx.Rollback())
+}
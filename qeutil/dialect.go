@@ -0,0 +1,95 @@
+package qeutil
+
+import (
+	"fmt"
+	"strings"
+
+	sq "github.com/Masterminds/squirrel"
+)
+
+// Dialect defines the SQL flavour of the generated statements, the zero value is MySQL.
+type Dialect string
+
+const (
+	// MySQL renders `?` placeholders, backtick quoted identifiers and ON DUPLICATE KEY UPDATE upserts.
+	MySQL Dialect = "mysql"
+	// PostgreSQL renders `$n` placeholders, double quoted identifiers, ON CONFLICT upserts and case-insensitive LIKE as ILIKE.
+	PostgreSQL Dialect = "postgres"
+	// SQLite renders `?` placeholders, double quoted identifiers and ON CONFLICT upserts.
+	SQLite Dialect = "sqlite3"
+)
+
+// orDefault returns MySQL for the zero value of Dialect.
+func (d Dialect) orDefault() Dialect {
+	if d == "" {
+		return MySQL
+	}
+	return d
+}
+
+// check returns an error if the dialect is not supported.
+func (d Dialect) check() error {
+	switch d.orDefault() {
+	case MySQL, PostgreSQL, SQLite:
+		return nil
+	default:
+		return fmt.Errorf("unknown SQL dialect %q", string(d))
+	}
+}
+
+// placeholder returns the placeholder format of the dialect.
+func (d Dialect) placeholder() sq.PlaceholderFormat {
+	if d.orDefault() == PostgreSQL {
+		return sq.Dollar
+	}
+	return sq.Question
+}
+
+// now returns the current timestamp function of the dialect.
+func (d Dialect) now() string {
+	if d.orDefault() == SQLite {
+		return "CURRENT_TIMESTAMP"
+	}
+	return "NOW()"
+}
+
+// Quote returns the quoted form of an identifier, each part of a qualified name such as `t.id` is quoted
+// separately and `*` is kept as it is.
+func (d Dialect) Quote(ident string) string {
+	q := `"`
+	if d.orDefault() == MySQL {
+		q = "`"
+	}
+	parts := strings.Split(ident, ".")
+	for i := range parts {
+		if parts[i] != "*" {
+			parts[i] = q + strings.Replace(parts[i], q, q+q, -1) + q
+		}
+	}
+	return strings.Join(parts, ".")
+}
+
+// insertKeyword returns the statement keyword and options of an insert mode.
+func (d Dialect) insertKeyword(mode string) (keyword, option string, err error) {
+	switch mode {
+	case "":
+		return "INSERT", "", nil
+	case InsertIgnore:
+		switch d.orDefault() {
+		case MySQL:
+			return "INSERT", "IGNORE", nil
+		case SQLite:
+			return "INSERT", "OR IGNORE", nil
+		default:
+			// Rendered as ON CONFLICT DO NOTHING
+			return "INSERT", "", nil
+		}
+	case Replace:
+		if d.orDefault() == PostgreSQL {
+			return "", "", fmt.Errorf("replace into is not supported by %v", d.orDefault())
+		}
+		return "REPLACE", "", nil
+	default:
+		return "", "", fmt.Errorf("unknown insert mode %q", mode)
+	}
+}
@@ -0,0 +1,215 @@
+package qeutil
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testWhs() []Wh {
+	return []Wh{
+		Wh{Operator: "in", Values: map[string]interface{}{"in_comp": []string{"hello", "world", "!"}}},
+		Wh{Operator: ">", Values: map[string]interface{}{"gt_comp": 1}},
+		Wh{Operator: "<", Values: map[string]interface{}{"lt_comp": 2}},
+	}
+}
+
+func TestDialectSQLStm(t *testing.T) {
+	tests := []struct {
+		dialect Dialect
+		sel     string
+		update  string
+		delete  string
+		insert  string
+	}{
+		{
+			dialect: "",
+			sel:     "SELECT `id` FROM `table` WHERE `in_comp` IN (?,?,?) AND `gt_comp` > ? AND `lt_comp` < ? GROUP BY `grp` HAVING 1<>0 ORDER BY `ord` LIMIT 10 OFFSET 50",
+			update:  "UPDATE `table` SET `field1` = ?, `field2` = ? WHERE `in_comp` IN (?,?,?) AND `gt_comp` > ? AND `lt_comp` < ?",
+			delete:  "DELETE FROM `table` WHERE `in_comp` IN (?,?,?) AND `gt_comp` > ? AND `lt_comp` < ?",
+			insert:  "INSERT INTO `table` (`field1`,`field2`) VALUES (?,?)",
+		},
+		{
+			dialect: PostgreSQL,
+			sel:     `SELECT "id" FROM "table" WHERE "in_comp" IN ($1,$2,$3) AND "gt_comp" > $4 AND "lt_comp" < $5 GROUP BY "grp" HAVING 1<>0 ORDER BY "ord" LIMIT 10 OFFSET 50`,
+			update:  `UPDATE "table" SET "field1" = $1, "field2" = $2 WHERE "in_comp" IN ($3,$4,$5) AND "gt_comp" > $6 AND "lt_comp" < $7`,
+			delete:  `DELETE FROM "table" WHERE "in_comp" IN ($1,$2,$3) AND "gt_comp" > $4 AND "lt_comp" < $5`,
+			insert:  `INSERT INTO "table" ("field1","field2") VALUES ($1,$2)`,
+		},
+		{
+			dialect: SQLite,
+			sel:     `SELECT "id" FROM "table" WHERE "in_comp" IN (?,?,?) AND "gt_comp" > ? AND "lt_comp" < ? GROUP BY "grp" HAVING 1<>0 ORDER BY "ord" LIMIT 10 OFFSET 50`,
+			update:  `UPDATE "table" SET "field1" = ?, "field2" = ? WHERE "in_comp" IN (?,?,?) AND "gt_comp" > ? AND "lt_comp" < ?`,
+			delete:  `DELETE FROM "table" WHERE "in_comp" IN (?,?,?) AND "gt_comp" > ? AND "lt_comp" < ?`,
+			insert:  `INSERT INTO "table" ("field1","field2") VALUES (?,?)`,
+		},
+	}
+	for _, tt := range tests {
+		offset := 50
+		limit := 10
+		sc := SelectClause{
+			Select:  []string{"id"},
+			From:    "table",
+			Where:   testWhs(),
+			GroupBy: []string{"grp"},
+			Having:  "1<>0",
+			OrderBy: []Order{Asc("ord")},
+			Limit:   &limit,
+			Offset:  &offset,
+			Dialect: tt.dialect,
+		}
+		stm, val, err := sc.SQLStm()
+		assert.NoError(t, err)
+		assert.Equal(t, tt.sel, stm)
+		assert.Equal(t, []interface{}{"hello", "world", "!", 1, 2}, val)
+
+		uc := UpdateClause{Update: "table", Set: map[string]interface{}{"field1": 1, "field2": "2"}, Where: testWhs(), Dialect: tt.dialect}
+		stm, val, err = uc.SQLStm()
+		assert.NoError(t, err)
+		assert.Equal(t, tt.update, stm)
+		assert.Equal(t, []interface{}{1, "2", "hello", "world", "!", 1, 2}, val)
+
+		dc := DeleteClause{From: "table", Where: testWhs(), Dialect: tt.dialect}
+		stm, val, err = dc.SQLStm()
+		assert.NoError(t, err)
+		assert.Equal(t, tt.delete, stm)
+		assert.Equal(t, []interface{}{"hello", "world", "!", 1, 2}, val)
+
+		ic := InsertClause{Into: "table", Values: map[string]interface{}{"field1": 1, "field2": "2"}, Dialect: tt.dialect}
+		stm, val, err = ic.SQLStm()
+		assert.NoError(t, err)
+		assert.Equal(t, tt.insert, stm)
+		assert.Equal(t, []interface{}{1, "2"}, val)
+	}
+
+	_, _, err := (&SelectClause{From: "table", Dialect: "oracle"}).SQLStm()
+	assert.Error(t, err)
+}
+
+func TestDialectSQLStmOperators(t *testing.T) {
+	whs := []Wh{
+		Wh{Operator: Like, Values: map[string]interface{}{"name": "a%"}},
+		Wh{Operator: NotLike, Values: map[string]interface{}{"name": "test%"}},
+		Wh{Operator: Regexp, Values: map[string]interface{}{"code": "^[A-Z]+$"}},
+		Wh{Operator: JSONContains, Values: map[string]interface{}{"tags": []string{"math"}}},
+	}
+	sc := SelectClause{From: "table", Where: whs, Dialect: PostgreSQL}
+	stm, val, err := sc.SQLStm()
+	assert.NoError(t, err)
+	assert.Equal(t, `SELECT * FROM "table" WHERE "name" ILIKE $1 AND "name" NOT ILIKE $2 AND "code" ~ $3 AND "tags" @> CAST($4 AS jsonb)`, stm)
+	assert.Equal(t, []interface{}{"a%", "test%", "^[A-Z]+$", `["math"]`}, val)
+
+	sc = SelectClause{From: "table", Where: whs[:3], Dialect: SQLite}
+	stm, _, err = sc.SQLStm()
+	assert.NoError(t, err)
+	assert.Equal(t, `SELECT * FROM "table" WHERE "name" LIKE ? AND "name" NOT LIKE ? AND "code" REGEXP ?`, stm)
+
+	sc.Where = whs
+	_, _, err = sc.SQLStm()
+	assert.Error(t, err)
+}
+
+func TestDialectSQLStmOffset(t *testing.T) {
+	offset := 20
+	tests := map[Dialect]string{
+		MySQL:      "SELECT * FROM `table` LIMIT 18446744073709551615 OFFSET 20",
+		PostgreSQL: `SELECT * FROM "table" OFFSET 20`,
+		SQLite:     `SELECT * FROM "table" LIMIT -1 OFFSET 20`,
+	}
+	for d, want := range tests {
+		sc := SelectClause{From: "table", Offset: &offset, Dialect: d}
+		stm, _, err := sc.SQLStm()
+		assert.NoError(t, err)
+		assert.Equal(t, want, stm)
+	}
+}
+
+func TestDialectInsertUpsert(t *testing.T) {
+	ic := InsertClause{
+		Into:   "table",
+		Values: map[string]interface{}{"id": 1, "score": 50},
+		OnDuplicate: map[string]interface{}{
+			"score":      ValuesOf("score"),
+			"updated_by": "sys",
+		},
+		Conflict: []string{"id"},
+		Dialect:  PostgreSQL,
+	}
+	stm, val, err := ic.SQLStm()
+	assert.NoError(t, err)
+	assert.Equal(t, `INSERT INTO "table" ("id","score") VALUES ($1,$2) ON CONFLICT ("id") DO UPDATE SET "score" = EXCLUDED."score", "updated_by" = $3`, stm)
+	assert.Equal(t, []interface{}{1, 50, "sys"}, val)
+
+	ic.Dialect = SQLite
+	stm, _, err = ic.SQLStm()
+	assert.NoError(t, err)
+	assert.Equal(t, `INSERT INTO "table" ("id","score") VALUES (?,?) ON CONFLICT ("id") DO UPDATE SET "score" = EXCLUDED."score", "updated_by" = ?`, stm)
+
+	ic.Conflict = nil
+	_, _, err = ic.SQLStm()
+	assert.Error(t, err)
+
+	ic = InsertClause{Into: "table", Values: map[string]interface{}{"id": 1}, Mode: InsertIgnore, Dialect: SQLite}
+	stm, _, err = ic.SQLStm()
+	assert.NoError(t, err)
+	assert.Equal(t, `INSERT OR IGNORE INTO "table" ("id") VALUES (?)`, stm)
+
+	ic.Dialect = PostgreSQL
+	stm, _, err = ic.SQLStm()
+	assert.NoError(t, err)
+	assert.Equal(t, `INSERT INTO "table" ("id") VALUES ($1) ON CONFLICT DO NOTHING`, stm)
+
+	ic.Mode = Replace
+	_, _, err = ic.SQLStm()
+	assert.Error(t, err)
+}
+
+func TestDialectQuote(t *testing.T) {
+	assert.Equal(t, "`t`.`id`", MySQL.Quote("t.id"))
+	assert.Equal(t, "`we``ird`", Dialect("").Quote("we`ird"))
+	assert.Equal(t, `"t".*`, PostgreSQL.Quote("t.*"))
+	assert.Equal(t, `"we""ird"`, SQLite.Quote(`we"ird`))
+}
+
+func TestDialectInsertExec(t *testing.T) {
+	errNoID := errors.New("LastInsertId is not supported by this driver")
+	db, conn := newFakeDB(func(stm string, args []driver.Value) fakeResp {
+		if strings.HasSuffix(stm, "RETURNING \"id\"") {
+			return fakeResp{cols: []string{"id"}, rows: [][]driver.Value{{int64(42)}, {int64(43)}}}
+		}
+		return fakeResp{affected: 1, idErr: errNoID}
+	})
+	ctx := context.Background()
+
+	ic := InsertClause{Into: "table", Values: map[string]interface{}{"name": "a"}, Dialect: PostgreSQL}
+	id, err := ic.Exec(ctx, db)
+	assert.NoError(t, err)
+	assert.Zero(t, id)
+
+	ic.Rows = []map[string]interface{}{{"name": "b"}}
+	ic.Returning = "id"
+	id, err = ic.Exec(ctx, db)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(42), id)
+
+	// The insert of a transaction is committed
+	assert.NoError(t, WithTx(ctx, db, nil, func(tx *Tx) error {
+		_, err := tx.Insert(ctx, &InsertClause{Into: "table", Values: map[string]interface{}{"name": "c"}, Dialect: PostgreSQL})
+		return err
+	}))
+	assert.Equal(t, []string{
+		`INSERT INTO "table" ("name") VALUES ($1)`,
+		`INSERT INTO "table" ("name") VALUES ($1),($2) RETURNING "id"`,
+		"BEGIN",
+		`INSERT INTO "table" ("name") VALUES ($1)`,
+		"COMMIT",
+	}, conn.statements())
+
+	// MySQL still reports the errors of LastInsertId
+	_, err = (&InsertClause{Into: "table", Values: map[string]interface{}{"name": "a"}}).Exec(ctx, db)
+	assert.Equal(t, errNoID, err)
+}
@@ -0,0 +1,132 @@
+package qeutil
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/go-redis/cache"
+	"github.com/jmoiron/sqlx"
+)
+
+// existsClause returns the AggregateClause of the existence of the rows of the SelectClause.
+func (sc *SelectClause) existsClause() *AggregateClause {
+	return &AggregateClause{Func: existsFunc, Query: sc}
+}
+
+// ExistsSQLStm return a query statment of the Dialect selecting 1 of the first row of the SelectClause, e.g.
+// `SELECT 1 FROM t WHERE ... LIMIT 1`, ignoring its select list, OrderBy, Limit, Offset and After. A Lock locks
+// the first row, e.g. `SELECT 1 FROM t WHERE ... LIMIT 1 FOR UPDATE`.
+func (sc *SelectClause) ExistsSQLStm() (string, []interface{}, error) {
+	builder, err := sc.existsClause().builder()
+	if err != nil {
+		return "", nil, err
+	}
+	return builder.Limit(1).ToSql()
+}
+
+// Exists checks whether the SelectClause has any row, ErrNotExist is returned when no row is found.
+func (sc *SelectClause) Exists(ctx context.Context, db sqlx.QueryerContext) error {
+	stm, val, err := sc.ExistsSQLStm()
+	if err != nil {
+		return err
+	}
+	found := true
+	err = traceQuery(ctx, OpExists, sc.From, stm, val, func(ctx context.Context) (int64, error) {
+		var one int
+		if err := db.QueryRowxContext(ctx, stm, val...).Scan(&one); err != nil {
+			if err == sql.ErrNoRows {
+				found = false
+				return 0, nil
+			}
+			return -1, err
+		}
+		return 1, nil
+	})
+	if err == nil && !found {
+		return ErrNotExist
+	}
+	return err
+}
+
+// ExistsWithCache checks whether the SelectClause has any row like Exists, reading the result from the cache, or
+// from DB and then caching it on cache miss. A missing row is cached only with the EmptyExpiry of the CacheConfig.
+// Results are still read from DB if the cache is unavailable, whose failures are reported to the hooks like QueryWithCache.
+// ErrLockedRead is returned if the SelectClause has a Lock.
+func (sc *SelectClause) ExistsWithCache(ctx context.Context, db sqlx.QueryerContext, cc *CacheConfig) error {
+	if sc.Lock != "" {
+		return ErrLockedRead
+	}
+	ac := sc.existsClause()
+	key := ac.CacheKey()
+
+	var exist bool
+	cacheErr := cc.Cache.Get(key, &exist)
+	if cacheErr == nil {
+		if !exist {
+			return ErrNotExist
+		}
+		return nil
+	}
+	reportCache(ctx, OpCacheGet, sc.From, key, cacheErr)
+	err := sc.Exists(ctx, db)
+	if err != nil && err != ErrNotExist {
+		return err
+	}
+	if cacheErr == cache.ErrCacheMiss {
+		if err == nil {
+			reportCache(ctx, OpCacheSet, sc.From, key, setCache(cc.Cache, key, true, cc.expiry(sc.tables()...), ac.rows().CacheTags))
+		} else if cc.EmptyExpiry > 0 {
+			reportCache(ctx, OpCacheSet, sc.From, key, setCache(cc.Cache, key, false, cc.EmptyExpiry, ac.rows().CacheTags))
+		}
+	}
+	return err
+}
+
+// exists checks the SelectClause with the cache if given.
+func (sc *SelectClause) exists(ctx context.Context, db sqlx.QueryerContext, cc *CacheConfig) error {
+	if cc == nil {
+		return sc.Exists(ctx, db)
+	}
+	return sc.ExistsWithCache(ctx, db, cc)
+}
+
+// ExistsInDB checks whether any row of the target table matches the where clauses, ErrNotExist is returned when
+// no row is found. The result is read with the cache if cc is not nil.
+func ExistsInDB(ctx context.Context, db sqlx.QueryerContext, cc *CacheConfig, target string, wheres []Wh) error {
+	return (&SelectClause{From: target, Where: wheres}).exists(ctx, db, cc)
+}
+
+// CountInDB returns the number of rows of the target table matching the where clauses, which is read with the
+// cache if cc is not nil.
+func CountInDB(ctx context.Context, db sqlx.QueryerContext, cc *CacheConfig, target string, wheres []Wh) (int64, error) {
+	var n int64
+	ac := (&SelectClause{From: target, Where: wheres}).Count()
+	if cc == nil {
+		return n, ac.Get(ctx, db, &n)
+	}
+	return n, ac.GetWithCache(ctx, db, cc, &n)
+}
+
+// ExistsAll checks whether each of the where clauses matches a row of the target table, ErrNotExist is returned
+// when any of them matches no row. Each where clauses are checked one by one, with the cache if cc is not nil.
+func ExistsAll(ctx context.Context, db sqlx.QueryerContext, cc *CacheConfig, target string, wheres ...[]Wh) error {
+	for _, whs := range wheres {
+		if err := ExistsInDB(ctx, db, cc, target, whs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ExistsAny checks whether any of the where clauses matches a row of the target table in a single statement,
+// ErrNotExist is returned when none of them matches any row. The result is read with the cache if cc is not nil.
+func ExistsAny(ctx context.Context, db sqlx.QueryerContext, cc *CacheConfig, target string, wheres ...[]Wh) error {
+	if len(wheres) == 0 {
+		return ErrNotExist
+	}
+	or := make([]Wh, len(wheres))
+	for i := range wheres {
+		or[i] = AndWh(wheres[i]...)
+	}
+	return ExistsInDB(ctx, db, cc, target, []Wh{OrWh(or...)})
+}
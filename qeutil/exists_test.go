@@ -0,0 +1,96 @@
+package qeutil
+
+import (
+	"context"
+	"database/sql/driver"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSelectClauseExistsSQLStm(t *testing.T) {
+	sc := &SelectClause{
+		Select:  []string{"id", "name"},
+		From:    "exam_sessions",
+		Where:   []Wh{Wh{Operator: Eq, Values: map[string]interface{}{"user_id": 7}}},
+		OrderBy: []Order{Desc("id")},
+		Limit:   intPtr(20),
+		Offset:  intPtr(40),
+	}
+	stm, val, err := sc.ExistsSQLStm()
+	assert.NoError(t, err)
+	assert.Equal(t, "SELECT 1 FROM `exam_sessions` WHERE `user_id` = ? AND `deleted_at` IS NULL LIMIT 1", stm)
+	assert.Equal(t, []interface{}{7}, val)
+
+	sc = &SelectClause{From: "answers", Select: []string{"user_id"}, GroupBy: []string{"user_id"}, Having: "COUNT(*) > ?", HavingArgs: []interface{}{3}, Dialect: PostgreSQL}
+	stm, _, err = sc.ExistsSQLStm()
+	assert.NoError(t, err)
+	assert.Equal(t, `SELECT 1 FROM (SELECT "user_id" FROM "answers" GROUP BY "user_id" HAVING COUNT(*) > $1) AS "t" LIMIT 1`, stm)
+}
+
+func TestExistsInDB(t *testing.T) {
+	db, conn := newFakeDB(func(stm string, args []driver.Value) fakeResp {
+		if len(args) > 0 && args[0] == int64(1) {
+			return fakeResp{cols: []string{"1"}, rows: [][]driver.Value{{int64(1)}}}
+		}
+		return fakeResp{cols: []string{"1"}}
+	})
+	ctx := context.Background()
+	id := func(id int) []Wh { return []Wh{Wh{Operator: Eq, Values: map[string]interface{}{"id": id}}} }
+
+	assert.NoError(t, ExistsInDB(ctx, db, nil, "users", id(1)))
+	assert.Equal(t, ErrNotExist, ExistsInDB(ctx, db, nil, "users", id(2)))
+	exist, err := ExistInDB(db, "users", id(2))
+	assert.NoError(t, err)
+	assert.False(t, exist)
+
+	// Transactions are accepted
+	tx, err := db.BeginTxx(ctx, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, ExistsInDB(ctx, tx, nil, "users", id(1)))
+	assert.NoError(t, tx.Rollback())
+
+	assert.NoError(t, ExistsAll(ctx, db, nil, "users", id(1), id(1)))
+	assert.Equal(t, ErrNotExist, ExistsAll(ctx, db, nil, "users", id(1), id(2)))
+	assert.Equal(t, ErrNotExist, ExistsAny(ctx, db, nil, "users"))
+	assert.NoError(t, ExistsAny(ctx, db, nil, "users", id(1), id(2)))
+	assert.Equal(t, "SELECT 1 FROM `users` WHERE ((`id` = ?) OR (`id` = ?)) LIMIT 1", conn.statements()[len(conn.statements())-1])
+}
+
+func TestExistsWithCache(t *testing.T) {
+	db, conn := newFakeDB(func(stm string, args []driver.Value) fakeResp {
+		if strings.HasPrefix(stm, "SELECT COUNT(*)") {
+			return fakeResp{cols: []string{"n"}, rows: [][]driver.Value{{int64(3)}}}
+		}
+		if args[0] == int64(1) {
+			return fakeResp{cols: []string{"1"}, rows: [][]driver.Value{{int64(1)}}}
+		}
+		return fakeResp{cols: []string{"1"}}
+	})
+	fc := newFakeCache()
+	cc := &CacheConfig{Cache: fc, Expiry: time.Minute}
+	ctx := context.Background()
+	id := func(id int) []Wh { return []Wh{Wh{Operator: Eq, Values: map[string]interface{}{"id": id}}} }
+
+	for i := 0; i < 2; i++ {
+		assert.NoError(t, ExistsInDB(ctx, db, cc, "users", id(1)))
+		assert.Equal(t, ErrNotExist, ExistsInDB(ctx, db, cc, "users", id(2)))
+		n, err := CountInDB(ctx, db, cc, "users", id(1))
+		assert.NoError(t, err)
+		assert.Equal(t, int64(3), n)
+	}
+	// Missing rows are not cached without EmptyExpiry
+	assert.Equal(t, []string{"users:agg:count(*):where:{id=1}", "users:agg:exists(*):where:{id=1}"}, fc.keys())
+	assert.Len(t, conn.statements(), 4)
+
+	cc.EmptyExpiry = time.Second
+	assert.Equal(t, ErrNotExist, ExistsInDB(ctx, db, cc, "users", id(2)))
+	assert.Equal(t, ErrNotExist, ExistsInDB(ctx, db, cc, "users", id(2)))
+	assert.Len(t, conn.statements(), 5)
+
+	// Cached results are unlinked with the rows
+	fc.UnlinkKeys((&UpdateClause{Update: "users", Set: map[string]interface{}{"name": "a"}, Where: id(1)}).ToUnlinks())
+	assert.Empty(t, fc.keys())
+}
@@ -0,0 +1,271 @@
+package qeutil
+
+import (
+	"strings"
+	"time"
+)
+
+// TableName is the table of a generated descriptor, see cmd/qegen, with the alias qualifying its fields in a
+// statement, e.g. `SelectClause{From: t.Name, As: t.Alias}`.
+type TableName struct {
+	Name  string
+	Alias string
+}
+
+// String returns the name of the table.
+func (t TableName) String() string {
+	return t.Name
+}
+
+// Field returns the field of a column of the table, qualified by the alias if given.
+func (t TableName) Field(col string) Field {
+	if t.Alias != "" {
+		return Field(t.Alias + "." + col)
+	}
+	return Field(col)
+}
+
+// Field is a column of a table descriptor, optionally qualified by its table, e.g. `a.user_id`. The typed
+// fields, e.g. Int64Field, only accept values of the Go type of the column.
+type Field string
+
+// String returns the column, qualified by its table if given.
+func (f Field) String() string {
+	return string(f)
+}
+
+// Name returns the column without its table, e.g. for the values of insert and update.
+func (f Field) Name() string {
+	return string(f[strings.LastIndex(string(f), ".")+1:])
+}
+
+// Col returns the reference of the column, e.g. a value of a join condition.
+func (f Field) Col() Col {
+	return Col(f)
+}
+
+// As returns the select list entry of the column with an alias, e.g. `u.name AS user_name`.
+func (f Field) As(alias string) string {
+	return string(f) + " AS " + alias
+}
+
+// Asc returns the ascending order of the column.
+func (f Field) Asc() Order {
+	return Asc(string(f))
+}
+
+// Desc returns the descending order of the column.
+func (f Field) Desc() Order {
+	return Desc(string(f))
+}
+
+// cond returns the where clause of the column with the operator and value.
+func (f Field) cond(op string, v interface{}) Wh {
+	return Wh{Operator: op, Values: map[string]interface{}{string(f): v}}
+}
+
+// IsNull returns the condition of the column being NULL.
+func (f Field) IsNull() Wh {
+	return f.cond(IsNull, nil)
+}
+
+// IsNotNull returns the condition of the column not being NULL.
+func (f Field) IsNotNull() Wh {
+	return f.cond(IsNotNull, nil)
+}
+
+// EqCol returns the condition of the column equal to another column, e.g. a join condition.
+func (f Field) EqCol(o Field) Wh {
+	return f.cond(Eq, o.Col())
+}
+
+// Null returns the NULL value of the column.
+func (f Field) Null() FieldValue {
+	return FieldValue{f, nil}
+}
+
+// FieldValue is a value of a column, which is set by insert or update.
+type FieldValue struct {
+	Field Field
+	Value interface{}
+}
+
+// FieldValues returns the values of InsertClause and the Set of UpdateClause from the field values.
+func FieldValues(vals ...FieldValue) map[string]interface{} {
+	m := make(map[string]interface{}, len(vals))
+	for _, v := range vals {
+		m[v.Field.Name()] = v.Value
+	}
+	return m
+}
+
+// Columns returns the select list of the fields.
+func Columns(fields ...Field) []string {
+	cols := make([]string, len(fields))
+	for i := range fields {
+		cols[i] = string(fields[i])
+	}
+	return cols
+}
+
+// Int64Field is a field of an integer column.
+type Int64Field struct{ Field }
+
+// Eq returns the condition of the column equal to v.
+func (f Int64Field) Eq(v int64) Wh { return f.cond(Eq, v) }
+
+// NotEq returns the condition of the column not equal to v.
+func (f Int64Field) NotEq(v int64) Wh { return f.cond(NotEq, v) }
+
+// Gt returns the condition of the column greater than v.
+func (f Int64Field) Gt(v int64) Wh { return f.cond(Gt, v) }
+
+// GtEq returns the condition of the column greater than or equal to v.
+func (f Int64Field) GtEq(v int64) Wh { return f.cond(GtEq, v) }
+
+// Lt returns the condition of the column less than v.
+func (f Int64Field) Lt(v int64) Wh { return f.cond(Lt, v) }
+
+// LtEq returns the condition of the column less than or equal to v.
+func (f Int64Field) LtEq(v int64) Wh { return f.cond(LtEq, v) }
+
+// In returns the condition of the column in vs.
+func (f Int64Field) In(vs ...int64) Wh { return f.cond(In, vs) }
+
+// NotIn returns the condition of the column not in vs.
+func (f Int64Field) NotIn(vs ...int64) Wh { return f.cond(NotIn, vs) }
+
+// Between returns the condition of the column between a and b.
+func (f Int64Field) Between(a, b int64) Wh { return f.cond(Between, []int64{a, b}) }
+
+// Value returns the value v of the column.
+func (f Int64Field) Value(v int64) FieldValue { return FieldValue{f.Field, v} }
+
+// Float64Field is a field of a floating-point column.
+type Float64Field struct{ Field }
+
+// Eq returns the condition of the column equal to v.
+func (f Float64Field) Eq(v float64) Wh { return f.cond(Eq, v) }
+
+// NotEq returns the condition of the column not equal to v.
+func (f Float64Field) NotEq(v float64) Wh { return f.cond(NotEq, v) }
+
+// Gt returns the condition of the column greater than v.
+func (f Float64Field) Gt(v float64) Wh { return f.cond(Gt, v) }
+
+// GtEq returns the condition of the column greater than or equal to v.
+func (f Float64Field) GtEq(v float64) Wh { return f.cond(GtEq, v) }
+
+// Lt returns the condition of the column less than v.
+func (f Float64Field) Lt(v float64) Wh { return f.cond(Lt, v) }
+
+// LtEq returns the condition of the column less than or equal to v.
+func (f Float64Field) LtEq(v float64) Wh { return f.cond(LtEq, v) }
+
+// In returns the condition of the column in vs.
+func (f Float64Field) In(vs ...float64) Wh { return f.cond(In, vs) }
+
+// NotIn returns the condition of the column not in vs.
+func (f Float64Field) NotIn(vs ...float64) Wh { return f.cond(NotIn, vs) }
+
+// Between returns the condition of the column between a and b.
+func (f Float64Field) Between(a, b float64) Wh { return f.cond(Between, []float64{a, b}) }
+
+// Value returns the value v of the column.
+func (f Float64Field) Value(v float64) FieldValue { return FieldValue{f.Field, v} }
+
+// StringField is a field of a character column, or a decimal column whose values keep their precision as strings.
+type StringField struct{ Field }
+
+// Eq returns the condition of the column equal to v.
+func (f StringField) Eq(v string) Wh { return f.cond(Eq, v) }
+
+// NotEq returns the condition of the column not equal to v.
+func (f StringField) NotEq(v string) Wh { return f.cond(NotEq, v) }
+
+// Gt returns the condition of the column greater than v.
+func (f StringField) Gt(v string) Wh { return f.cond(Gt, v) }
+
+// GtEq returns the condition of the column greater than or equal to v.
+func (f StringField) GtEq(v string) Wh { return f.cond(GtEq, v) }
+
+// Lt returns the condition of the column less than v.
+func (f StringField) Lt(v string) Wh { return f.cond(Lt, v) }
+
+// LtEq returns the condition of the column less than or equal to v.
+func (f StringField) LtEq(v string) Wh { return f.cond(LtEq, v) }
+
+// In returns the condition of the column in vs.
+func (f StringField) In(vs ...string) Wh { return f.cond(In, vs) }
+
+// NotIn returns the condition of the column not in vs.
+func (f StringField) NotIn(vs ...string) Wh { return f.cond(NotIn, vs) }
+
+// Between returns the condition of the column between a and b.
+func (f StringField) Between(a, b string) Wh { return f.cond(Between, []string{a, b}) }
+
+// Like returns the condition of the column matching the pattern.
+func (f StringField) Like(pattern string) Wh { return f.cond(Like, pattern) }
+
+// NotLike returns the condition of the column not matching the pattern.
+func (f StringField) NotLike(pattern string) Wh { return f.cond(NotLike, pattern) }
+
+// Value returns the value v of the column.
+func (f StringField) Value(v string) FieldValue { return FieldValue{f.Field, v} }
+
+// TimeField is a field of a date or time column.
+type TimeField struct{ Field }
+
+// Eq returns the condition of the column equal to v.
+func (f TimeField) Eq(v time.Time) Wh { return f.cond(Eq, v) }
+
+// NotEq returns the condition of the column not equal to v.
+func (f TimeField) NotEq(v time.Time) Wh { return f.cond(NotEq, v) }
+
+// Gt returns the condition of the column after v.
+func (f TimeField) Gt(v time.Time) Wh { return f.cond(Gt, v) }
+
+// GtEq returns the condition of the column at or after v.
+func (f TimeField) GtEq(v time.Time) Wh { return f.cond(GtEq, v) }
+
+// Lt returns the condition of the column before v.
+func (f TimeField) Lt(v time.Time) Wh { return f.cond(Lt, v) }
+
+// LtEq returns the condition of the column at or before v.
+func (f TimeField) LtEq(v time.Time) Wh { return f.cond(LtEq, v) }
+
+// In returns the condition of the column in vs.
+func (f TimeField) In(vs ...time.Time) Wh { return f.cond(In, vs) }
+
+// NotIn returns the condition of the column not in vs.
+func (f TimeField) NotIn(vs ...time.Time) Wh { return f.cond(NotIn, vs) }
+
+// Between returns the condition of the column between a and b.
+func (f TimeField) Between(a, b time.Time) Wh { return f.cond(Between, []time.Time{a, b}) }
+
+// Value returns the value v of the column.
+func (f TimeField) Value(v time.Time) FieldValue { return FieldValue{f.Field, v} }
+
+// BoolField is a field of a boolean column, e.g. `tinyint(1)`.
+type BoolField struct{ Field }
+
+// Eq returns the condition of the column equal to v.
+func (f BoolField) Eq(v bool) Wh { return f.cond(Eq, v) }
+
+// NotEq returns the condition of the column not equal to v.
+func (f BoolField) NotEq(v bool) Wh { return f.cond(NotEq, v) }
+
+// Value returns the value v of the column.
+func (f BoolField) Value(v bool) FieldValue { return FieldValue{f.Field, v} }
+
+// BytesField is a field of a binary column.
+type BytesField struct{ Field }
+
+// Eq returns the condition of the column equal to v.
+func (f BytesField) Eq(v []byte) Wh { return f.cond(Eq, v) }
+
+// NotEq returns the condition of the column not equal to v.
+func (f BytesField) NotEq(v []byte) Wh { return f.cond(NotEq, v) }
+
+// Value returns the value v of the column.
+func (f BytesField) Value(v []byte) FieldValue { return FieldValue{f.Field, v} }
@@ -0,0 +1,51 @@
+package qeutil
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFieldSQLStm(t *testing.T) {
+	a := TableName{Name: "answers", Alias: "a"}
+	id, userID, status := Int64Field{a.Field("id")}, Int64Field{a.Field("user_id")}, StringField{a.Field("status")}
+	u := TableName{Name: "users", Alias: "u"}
+	uid, name := Int64Field{u.Field("id")}, StringField{u.Field("name")}
+
+	sc := SelectClause{
+		Select: append(Columns(id.Field, status.Field), name.As("user_name")),
+		From:   a.Name,
+		As:     a.Alias,
+		Joins:  []Join{Join{Type: InnerJoin, Table: u.Name, As: u.Alias, On: []Wh{uid.EqCol(userID.Field)}}},
+		Where: []Wh{
+			userID.In(1, 2),
+			status.NotEq("closed"),
+			name.Like("b%"),
+			OrWh(id.Between(10, 20), StringField{a.Field("deleted_at")}.IsNotNull()),
+		},
+		OrderBy: []Order{id.Desc()},
+	}
+	stm, val, err := sc.SQLStm()
+	assert.NoError(t, err)
+	assert.Equal(t, "SELECT `a`.`id`, `a`.`status`, `u`.`name` AS `user_name` FROM `answers` AS `a` INNER JOIN `users` AS `u` ON `u`.`id` = `a`.`user_id` "+
+		"WHERE `a`.`user_id` IN (?,?) AND `a`.`status` <> ? AND `u`.`name` LIKE ? AND (`a`.`id` BETWEEN ? AND ? OR `a`.`deleted_at` IS NOT NULL) ORDER BY `a`.`id` DESC", stm)
+	assert.Equal(t, []interface{}{int64(1), int64(2), "closed", "b%", int64(10), int64(20)}, val)
+}
+
+func TestFieldValues(t *testing.T) {
+	a := TableName{Name: "answers", Alias: "a"}
+	now := time.Now()
+	vals := FieldValues(
+		StringField{a.Field("body")}.Value("hi"),
+		TimeField{a.Field("created_at")}.Value(now),
+		BoolField{Field("is_public")}.Value(true),
+		Field("score").Null(),
+	)
+	assert.Equal(t, map[string]interface{}{"body": "hi", "created_at": now, "is_public": true, "score": nil}, vals)
+
+	stm, val, err := (&InsertClause{Into: a.Name, Values: vals}).SQLStm()
+	assert.NoError(t, err)
+	assert.Equal(t, "INSERT INTO `answers` (`body`,`created_at`,`is_public`,`score`) VALUES (?,?,?,?)", stm)
+	assert.Equal(t, []interface{}{"hi", now, true, nil}, val)
+}
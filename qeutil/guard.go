@@ -0,0 +1,82 @@
+package qeutil
+
+import (
+	"fmt"
+)
+
+// constant reports whether the where clause is always v regardless of the rows, e.g. an empty And group or a
+// NotIn of an empty list is always true, and an In of an empty list is always false.
+func (wh *Wh) constant(v bool) bool {
+	switch wh.Operator {
+	case And, Or:
+		// And is true if all of its conditions are true and false if any is false, Or is the opposite
+		all := (wh.Operator == And) == v
+		for _, w := range wh.group() {
+			if w.constant(v) != all {
+				return !all
+			}
+		}
+		return all
+	case Not:
+		return (&Wh{Operator: And, Values: wh.Values}).constant(!v)
+	case Exists, NotExists:
+		return false
+	}
+
+	// The columns of a where clause are joined by AND, an empty list makes its column constant
+	for _, val := range wh.Values {
+		list, ok := keyList(val)
+		empty := ok && len(list) == 0
+		var c bool
+		switch wh.Operator {
+		case Eq, In:
+			c = false
+		case NotEq, NotIn:
+			c = true
+		default:
+			empty = false
+		}
+		if v && !(empty && c) {
+			return false
+		}
+		if !v && empty && !c {
+			return true
+		}
+	}
+	return v
+}
+
+// checkFullTable returns ErrFullTable if the where clauses of an update or delete on the table do not restrict
+// its rows, unless the full table is allowed.
+func checkFullTable(table string, whs []Wh, allow bool) error {
+	if wh := AndWh(whs...); !allow && wh.constant(true) {
+		return fmt.Errorf("%w: %v has no conditions", ErrFullTable, table)
+	}
+	return nil
+}
+
+// writeOrders returns the ORDER BY columns of an update or delete on the table, and checks its LIMIT, which are
+// only supported by MySQL.
+func writeOrders(q *idents, table string, orders []Order, limit *int) ([]string, error) {
+	if len(orders) == 0 && limit == nil {
+		return nil, nil
+	}
+	if d := q.d.orDefault(); d != MySQL {
+		return nil, fmt.Errorf("%v does not support ORDER BY or LIMIT in update or delete of %v", d, table)
+	}
+	if limit != nil && *limit < 0 {
+		return nil, fmt.Errorf("negative limit in update or delete of %v", table)
+	}
+	cols := make([]string, len(orders))
+	for i, ord := range orders {
+		col, err := q.col(ord.Col)
+		if err != nil {
+			return nil, err
+		}
+		if ord.Desc {
+			col += " DESC"
+		}
+		cols[i] = col
+	}
+	return cols, nil
+}
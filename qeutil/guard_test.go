@@ -0,0 +1,78 @@
+package qeutil
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckFullTable(t *testing.T) {
+	id := Wh{Operator: Eq, Values: map[string]interface{}{"id": 1}}
+	for _, whs := range [][]Wh{
+		nil,
+		[]Wh{Wh{Operator: Eq}},
+		[]Wh{AndWh()},
+		[]Wh{OrWh(id, AndWh())},
+		[]Wh{Wh{Operator: NotIn, Values: map[string]interface{}{"id": []int{}}}},
+		[]Wh{NotWh(Wh{Operator: In, Values: map[string]interface{}{"id": []int{}}})},
+	} {
+		_, _, err := (&DeleteClause{From: "answers", Where: whs}).SQLStm()
+		assert.True(t, errors.Is(err, ErrFullTable), "%v", whs)
+		_, _, err = (&UpdateClause{Update: "answers", Set: map[string]interface{}{"score": 1}, Where: whs}).SQLStm()
+		assert.True(t, errors.Is(err, ErrFullTable), "%v", whs)
+	}
+
+	for _, whs := range [][]Wh{
+		[]Wh{id},
+		[]Wh{AndWh(), id},
+		[]Wh{OrWh(id, AndWh(id))},
+		[]Wh{NotWh(id)},
+		[]Wh{Wh{Operator: In, Values: map[string]interface{}{"id": []int{}}}},
+		[]Wh{Wh{Operator: NotIn, Values: map[string]interface{}{"id": []int{}, "user_id": 1}}},
+		[]Wh{ExistsWh(&SelectClause{From: "users"})},
+	} {
+		assert.NoError(t, checkFullTable("answers", whs, false), "%v", whs)
+	}
+
+	stm, _, err := (&DeleteClause{From: "answers", AllowFullTable: true}).SQLStm()
+	assert.NoError(t, err)
+	assert.Equal(t, "DELETE FROM `answers`", stm)
+	_, _, err = (&DeleteClause{From: "exam_sessions"}).SQLStm()
+	assert.True(t, errors.Is(err, ErrFullTable))
+	_, _, err = (&RestoreClause{From: "exam_sessions"}).SQLStm()
+	assert.True(t, errors.Is(err, ErrFullTable))
+}
+
+func TestWriteOrderLimit(t *testing.T) {
+	limit := 100
+	dc := DeleteClause{
+		From:    "answers",
+		Where:   []Wh{Wh{Operator: Lt, Values: map[string]interface{}{"created_at": "2020-01-01"}}},
+		OrderBy: []Order{Asc("id")},
+		Limit:   &limit,
+	}
+	stm, val, err := dc.SQLStm()
+	assert.NoError(t, err)
+	assert.Equal(t, "DELETE FROM `answers` WHERE `created_at` < ? ORDER BY `id` LIMIT 100", stm)
+	assert.Equal(t, []interface{}{"2020-01-01"}, val)
+
+	dc.From = "exam_sessions"
+	stm, _, err = dc.SQLStm()
+	assert.NoError(t, err)
+	assert.Equal(t, "UPDATE `exam_sessions` SET `deleted_at` = NOW() WHERE `created_at` < ? AND `deleted_at` IS NULL ORDER BY `id` LIMIT 100", stm)
+
+	uc := UpdateClause{Update: "answers", Set: map[string]interface{}{"score": 0}, Where: dc.Where, OrderBy: []Order{Desc("score")}, Limit: &limit}
+	stm, _, err = uc.SQLStm()
+	assert.NoError(t, err)
+	assert.Equal(t, "UPDATE `answers` SET `score` = ? WHERE `created_at` < ? ORDER BY `score` DESC LIMIT 100", stm)
+
+	uc.OrderBy = []Order{Asc("score; --")}
+	_, _, err = uc.SQLStm()
+	assert.True(t, errors.Is(err, ErrUnsafeSQL))
+
+	uc.OrderBy = nil
+	uc.Dialect = PostgreSQL
+	_, _, err = uc.SQLStm()
+	assert.Error(t, err)
+}
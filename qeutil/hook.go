@@ -0,0 +1,163 @@
+package qeutil
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/go-redis/cache"
+)
+
+// Operations of a QueryEvent, which are the execution helpers running the statement.
+const (
+	// OpQuery reads the rows of a SelectClause by Query
+	OpQuery string = "query"
+	// OpGet reads a row of a SelectClause by Get
+	OpGet string = "get"
+	// OpExists checks the existence of the rows of a SelectClause
+	OpExists string = "exists"
+	// OpAggregate reads the result of an AggregateClause
+	OpAggregate string = "aggregate"
+	// OpInsert executes an InsertClause, or a batch of it
+	OpInsert string = "insert"
+	// OpUpdate executes an UpdateClause
+	OpUpdate string = "update"
+	// OpDelete executes a DeleteClause
+	OpDelete string = "delete"
+	// OpRestore executes a RestoreClause
+	OpRestore string = "restore"
+	// OpCacheGet reads a cache entry, which is reported only when the cache fails
+	OpCacheGet string = "cache_get"
+	// OpCacheSet writes a cache entry, which is reported only when the cache fails
+	OpCacheSet string = "cache_set"
+)
+
+// QueryEvent is a statement executed by the execution helpers, which is passed to the hooks.
+type QueryEvent struct {
+	Op       string
+	Table    string
+	Stm      string
+	Args     []interface{}
+	Start    time.Time
+	Duration time.Duration // Duration is the execution time, which is set after the statement.
+	Rows     int64         // Rows is the number of rows affected by a write or read by a query, -1 if unknown.
+	Err      error         // Err is the error of the DB, ErrNotExist and ErrNotChanged are not errors of the DB.
+}
+
+// Hook is invoked around every statement executed by the execution helpers. BeforeQuery may return a context
+// carrying its state, e.g. a span, which is passed to AfterQuery. Hooks must be safe for concurrent use.
+type Hook interface {
+	BeforeQuery(ctx context.Context, e *QueryEvent) context.Context
+	AfterQuery(ctx context.Context, e *QueryEvent)
+}
+
+var (
+	hooksMu sync.RWMutex
+	hooks   []Hook
+)
+
+// SetHooks replaces the hooks invoked around the statements, they are invoked in order before the statement and
+// in reverse order after it. SetHooks() removes all hooks.
+func SetHooks(hs ...Hook) {
+	hooksMu.Lock()
+	hooks = append([]Hook(nil), hs...)
+	hooksMu.Unlock()
+}
+
+// traceQuery runs the statement between the hooks, run returns the number of rows of the statement.
+func traceQuery(ctx context.Context, op, table, stm string, args []interface{}, run func(ctx context.Context) (int64, error)) error {
+	hooksMu.RLock()
+	hs := hooks
+	hooksMu.RUnlock()
+	if len(hs) == 0 {
+		_, err := run(ctx)
+		return err
+	}
+
+	e := &QueryEvent{Op: op, Table: table, Stm: stm, Args: args, Start: time.Now(), Rows: -1}
+	for _, h := range hs {
+		ctx = h.BeforeQuery(ctx, e)
+	}
+	e.Rows, e.Err = run(ctx)
+	e.Duration = time.Since(e.Start)
+	for i := len(hs) - 1; i >= 0; i-- {
+		hs[i].AfterQuery(ctx, e)
+	}
+	return e.Err
+}
+
+// reportCache passes a failure of the cache to the hooks as an event of the operation whose Stm is the cache key,
+// so that a broken cache is logged and counted while the reads fall back to DB. A cache miss is not a failure.
+func reportCache(ctx context.Context, op, table, key string, err error) {
+	if err == nil || err == cache.ErrCacheMiss {
+		return
+	}
+	traceQuery(ctx, op, table, key, nil, func(context.Context) (int64, error) {
+		return -1, err
+	})
+}
+
+// redactedArg replaces the arguments of the events passed to a Redacted hook.
+const redactedArg = "[redacted]"
+
+// redacted wraps a Hook which receives the events without their arguments.
+type redacted struct {
+	h Hook
+}
+
+// Redacted returns a Hook passing the events to h with their arguments replaced, e.g. to log statements holding
+// personal data.
+func Redacted(h Hook) Hook {
+	return redacted{h}
+}
+
+// event returns a copy of the event with redacted arguments.
+func (r redacted) event(e *QueryEvent) *QueryEvent {
+	c := *e
+	c.Args = make([]interface{}, len(e.Args))
+	for i := range c.Args {
+		c.Args[i] = redactedArg
+	}
+	return &c
+}
+
+// BeforeQuery implements Hook.
+func (r redacted) BeforeQuery(ctx context.Context, e *QueryEvent) context.Context {
+	return r.h.BeforeQuery(ctx, r.event(e))
+}
+
+// AfterQuery implements Hook.
+func (r redacted) AfterQuery(ctx context.Context, e *QueryEvent) {
+	r.h.AfterQuery(ctx, r.event(e))
+}
+
+// SlowLog is a Hook logging the statements taking at least Threshold, and the failed statements if LogErrors is set.
+type SlowLog struct {
+	Logger    *log.Logger // Logger is the standard logger if not given.
+	Threshold time.Duration
+	LogErrors bool
+}
+
+// BeforeQuery implements Hook.
+func (sl *SlowLog) BeforeQuery(ctx context.Context, e *QueryEvent) context.Context {
+	return ctx
+}
+
+// AfterQuery implements Hook.
+func (sl *SlowLog) AfterQuery(ctx context.Context, e *QueryEvent) {
+	var msg string
+	switch {
+	case e.Err != nil && sl.LogErrors:
+		msg = "failed query"
+	case e.Duration >= sl.Threshold:
+		msg = "slow query"
+	default:
+		return
+	}
+	logf := log.Printf
+	if sl.Logger != nil {
+		logf = sl.Logger.Printf
+	}
+	logf("%v %v of %v in %v, rows %d, error %v: %v %v", msg, e.Op, e.Table, e.Duration, e.Rows, e.Err, e.Stm, e.Args)
+}
@@ -0,0 +1,251 @@
+package qeutil
+
+import (
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"errors"
+	"log"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// recordHook records the events passed to the hook.
+type recordHook struct {
+	mu     sync.Mutex
+	name   string
+	calls  *[]string
+	events []QueryEvent
+}
+
+func (h *recordHook) BeforeQuery(ctx context.Context, e *QueryEvent) context.Context {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	*h.calls = append(*h.calls, "before "+h.name)
+	return ctx
+}
+
+func (h *recordHook) AfterQuery(ctx context.Context, e *QueryEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	*h.calls = append(*h.calls, "after "+h.name)
+	h.events = append(h.events, *e)
+}
+
+func TestHooks(t *testing.T) {
+	dbErr := errors.New("deadlock")
+	db, _ := newFakeDB(func(stm string, args []driver.Value) fakeResp {
+		switch {
+		case strings.HasPrefix(stm, "UPDATE") && args[1] == int64(3):
+			return fakeResp{err: dbErr}
+		case strings.HasPrefix(stm, "UPDATE"):
+			return fakeResp{affected: 2}
+		case strings.HasPrefix(stm, "INSERT"):
+			return fakeResp{lastID: 9, affected: 1}
+		case len(args) > 0 && args[0] == int64(1):
+			return fakeResp{cols: []string{"id", "name"}, rows: [][]driver.Value{{int64(1), "a"}, {int64(2), "b"}}}
+		}
+		return fakeResp{cols: []string{"id", "name"}}
+	})
+	var calls []string
+	a, b := &recordHook{name: "a", calls: &calls}, &recordHook{name: "b", calls: &calls}
+	SetHooks(a, Redacted(b))
+	defer SetHooks()
+	ctx := context.Background()
+	where := func(id int) []Wh { return []Wh{Wh{Operator: Eq, Values: map[string]interface{}{"id": id}}} }
+
+	n, err := (&UpdateClause{Update: "answers", Set: map[string]interface{}{"score": 1}, Where: where(1)}).Exec(ctx, db)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), n)
+	assert.Equal(t, []string{"before a", "before b", "after b", "after a"}, calls)
+	e := a.events[0]
+	assert.Equal(t, OpUpdate, e.Op)
+	assert.Equal(t, "answers", e.Table)
+	assert.Equal(t, "UPDATE `answers` SET `score` = ? WHERE `id` = ?", e.Stm)
+	assert.Equal(t, []interface{}{1, 1}, e.Args)
+	assert.Equal(t, int64(2), e.Rows)
+	assert.NoError(t, e.Err)
+	assert.Equal(t, []interface{}{redactedArg, redactedArg}, b.events[0].Args)
+
+	_, err = (&UpdateClause{Update: "answers", Set: map[string]interface{}{"score": 1}, Where: where(3)}).Exec(ctx, db)
+	assert.Equal(t, dbErr, err)
+	assert.Equal(t, dbErr, a.events[1].Err)
+
+	id, err := (&InsertClause{Into: "answers", Values: map[string]interface{}{"score": 1}}).Exec(ctx, db)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(9), id)
+	assert.Equal(t, OpInsert, a.events[2].Op)
+	assert.Equal(t, int64(1), a.events[2].Rows)
+
+	var rows []testRow
+	assert.NoError(t, (&SelectClause{From: "answers", Where: where(1)}).Query(ctx, db, &rows))
+	assert.Equal(t, OpQuery, a.events[3].Op)
+	assert.Equal(t, int64(2), a.events[3].Rows)
+
+	// Missing rows are not errors of the DB
+	var row testRow
+	assert.Equal(t, ErrNotExist, (&SelectClause{From: "answers", Where: where(2)}).Get(ctx, db, &row))
+	assert.Equal(t, ErrNotExist, (&SelectClause{From: "answers", Where: where(2)}).Exists(ctx, db))
+	for _, e := range a.events[4:] {
+		assert.NoError(t, e.Err)
+		assert.Equal(t, int64(0), e.Rows)
+	}
+	assert.Equal(t, OpGet, a.events[4].Op)
+	assert.Equal(t, OpExists, a.events[5].Op)
+	assert.True(t, a.events[5].Duration > 0)
+}
+
+func TestSlowLog(t *testing.T) {
+	buf := bytes.Buffer{}
+	sl := &SlowLog{Logger: log.New(&buf, "", 0), Threshold: time.Second, LogErrors: true}
+	e := &QueryEvent{Op: OpDelete, Table: "answers", Stm: "DELETE FROM `answers` WHERE `id` = ?", Args: []interface{}{1}, Duration: time.Millisecond, Rows: 1}
+	sl.AfterQuery(sl.BeforeQuery(context.Background(), e), e)
+	assert.Empty(t, buf.String())
+
+	e.Duration = 2 * time.Second
+	sl.AfterQuery(context.Background(), e)
+	assert.Equal(t, "slow query delete of answers in 2s, rows 1, error <nil>: DELETE FROM `answers` WHERE `id` = ? [1]\n", buf.String())
+
+	buf.Reset()
+	e.Duration = time.Millisecond
+	e.Err = errors.New("deadlock")
+	Redacted(sl).AfterQuery(context.Background(), e)
+	assert.Equal(t, "failed query delete of answers in 1ms, rows 1, error deadlock: DELETE FROM `answers` WHERE `id` = ? [[redacted]]\n", buf.String())
+}
+
+func TestMetrics(t *testing.T) {
+	m := &Metrics{Buckets: []float64{0.01, 0.1}}
+	ctx := context.Background()
+	for _, e := range []QueryEvent{
+		QueryEvent{Op: OpQuery, Table: "answers", Duration: 5 * time.Millisecond, Rows: 3},
+		QueryEvent{Op: OpQuery, Table: "answers", Duration: 50 * time.Millisecond, Rows: 2},
+		QueryEvent{Op: OpUpdate, Table: "answers", Duration: time.Second, Rows: -1, Err: errors.New("deadlock")},
+	} {
+		m.AfterQuery(m.BeforeQuery(ctx, &e), &e)
+	}
+	series := m.Snapshot()
+	assert.Len(t, series, 2)
+	assert.Equal(t, OpQuery, series[0].Op)
+	assert.Equal(t, int64(2), series[0].Count)
+	assert.Equal(t, int64(5), series[0].Rows)
+	assert.Equal(t, []int64{1, 2}, series[0].Buckets)
+	assert.Equal(t, int64(1), series[1].Errors)
+	assert.Equal(t, []int64{0, 0}, series[1].Buckets)
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+	for _, line := range []string{
+		"# TYPE qeutil_queries_total counter",
+		`qeutil_queries_total{op="query",table="answers"} 2`,
+		`qeutil_query_errors_total{op="update",table="answers"} 1`,
+		`qeutil_query_rows_total{op="query",table="answers"} 5`,
+		"# TYPE qeutil_query_duration_seconds histogram",
+		`qeutil_query_duration_seconds_bucket{op="query",table="answers",le="0.01"} 1`,
+		`qeutil_query_duration_seconds_bucket{op="query",table="answers",le="+Inf"} 2`,
+		`qeutil_query_duration_seconds_sum{op="query",table="answers"} 0.055`,
+		`qeutil_query_duration_seconds_count{op="update",table="answers"} 1`,
+	} {
+		assert.Contains(t, body, line+"\n")
+	}
+}
+
+// fakeSpan records its attributes.
+type fakeSpan struct {
+	name  string
+	attrs map[string]interface{}
+	err   error
+	ended bool
+}
+
+func (s *fakeSpan) SetAttribute(key string, value interface{}) { s.attrs[key] = value }
+
+func (s *fakeSpan) RecordError(err error) { s.err = err }
+
+func (s *fakeSpan) End() { s.ended = true }
+
+type fakeTracer struct {
+	spans []*fakeSpan
+}
+
+func (t *fakeTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	span := &fakeSpan{name: name, attrs: map[string]interface{}{}}
+	t.spans = append(t.spans, span)
+	return ctx, span
+}
+
+func TestTraceHook(t *testing.T) {
+	db, _ := newFakeDB(func(stm string, args []driver.Value) fakeResp {
+		return fakeResp{err: errors.New("deadlock")}
+	})
+	tracer := &fakeTracer{}
+	SetHooks(&TraceHook{Tracer: tracer})
+	defer SetHooks()
+
+	_, err := (&DeleteClause{From: "answers", Where: []Wh{Wh{Operator: Eq, Values: map[string]interface{}{"id": 1}}}}).Exec(context.Background(), db)
+	assert.Error(t, err)
+	assert.Len(t, tracer.spans, 1)
+	span := tracer.spans[0]
+	assert.Equal(t, "qeutil.delete answers", span.name)
+	assert.Equal(t, map[string]interface{}{
+		"db.operation": OpDelete,
+		"db.sql.table": "answers",
+		"db.statement": "DELETE FROM `answers` WHERE `id` = ?",
+	}, span.attrs)
+	assert.Equal(t, err, span.err)
+	assert.True(t, span.ended)
+}
+
+// brokenCache is a fakeCache failing to read or write its entries.
+type brokenCache struct {
+	*fakeCache
+	getErr, setErr error
+}
+
+func (bc *brokenCache) Get(key string, obj interface{}) error {
+	if bc.getErr != nil {
+		return bc.getErr
+	}
+	return bc.fakeCache.Get(key, obj)
+}
+
+func (bc *brokenCache) Set(key string, obj interface{}, exp time.Duration) error {
+	return bc.setErr
+}
+
+func TestCacheFailuresReported(t *testing.T) {
+	db, _ := newFakeDB(func(stm string, args []driver.Value) fakeResp {
+		switch {
+		case strings.HasPrefix(stm, "SELECT COUNT(*)"):
+			return fakeResp{cols: []string{"n"}, rows: [][]driver.Value{{int64(1)}}}
+		case strings.HasPrefix(stm, "SELECT 1"):
+			return fakeResp{cols: []string{"1"}, rows: [][]driver.Value{{int64(1)}}}
+		}
+		return fakeResp{cols: []string{"id", "name"}, rows: [][]driver.Value{{int64(1), "a"}}}
+	})
+	m := &Metrics{}
+	SetHooks(m)
+	defer SetHooks()
+	ctx := context.Background()
+	bc := &brokenCache{fakeCache: newFakeCache(), setErr: errors.New("OOM command not allowed")}
+	cc := &CacheConfig{Cache: bc, Expiry: time.Minute}
+
+	var rows []testRow
+	assert.NoError(t, (&SelectClause{From: "answers"}).QueryWithCache(ctx, db, cc, &rows))
+	assert.Len(t, rows, 1)
+	var n int64
+	assert.NoError(t, (&SelectClause{From: "answers"}).Count().GetWithCache(ctx, db, cc, &n))
+	bc.getErr = errors.New("connection refused")
+	assert.NoError(t, (&SelectClause{From: "answers"}).ExistsWithCache(ctx, db, cc))
+
+	errs := map[string]int64{}
+	for _, s := range m.Snapshot() {
+		errs[s.Op] += s.Errors
+	}
+	assert.Equal(t, map[string]int64{OpQuery: 0, OpAggregate: 0, OpExists: 0, OpCacheGet: 1, OpCacheSet: 2}, errs)
+}
@@ -0,0 +1,526 @@
+package qeutil
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// ErrUnsafeSQL is returned when an identifier or expression given to a clause may inject SQL, or is not allowed
+// by the Schema of the clause.
+var ErrUnsafeSQL = errors.New("unsafe SQL")
+
+var (
+	// identRegexp matches a plain or qualified identifier, e.g. `id`, `t.id` or `t.*`.
+	identRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_$]*(\.[A-Za-z_][A-Za-z0-9_$]*){0,2}(\.\*)?$`)
+	// unsafeExprs are the tokens which are not accepted in raw expressions such as Having.
+	unsafeExprs = []string{"'", `"`, "`", ";", "--", "/*", "#", "\\"}
+)
+
+// Schema is an allow-list of the columns of each table. Clauses with a Schema only accept the listed tables and
+// columns, which should be used whenever identifiers may come from user input.
+type Schema map[string][]string
+
+// Order is a column of ORDER BY.
+type Order struct {
+	Col  string
+	Desc bool
+}
+
+// Asc returns the ascending order of the column.
+func Asc(col string) Order {
+	return Order{Col: col}
+}
+
+// Desc returns the descending order of the column.
+func Desc(col string) Order {
+	return Order{Col: col, Desc: true}
+}
+
+// ParseOrder parses a sort field given by clients, e.g. `name`, `-name` or `name desc`, which is
+// validated when the clause is built.
+func ParseOrder(s string) (Order, error) {
+	if strings.HasPrefix(s, "-") {
+		return Desc(s[1:]), nil
+	}
+	fields := strings.Fields(s)
+	switch {
+	case len(fields) == 1:
+		return Asc(fields[0]), nil
+	case len(fields) == 2 && strings.EqualFold(fields[1], "asc"):
+		return Asc(fields[0]), nil
+	case len(fields) == 2 && strings.EqualFold(fields[1], "desc"):
+		return Desc(fields[0]), nil
+	default:
+		return Order{}, fmt.Errorf("%w: cannot order by %q", ErrUnsafeSQL, s)
+	}
+}
+
+// String returns the order in SQL form without quoting, e.g. `name DESC`.
+func (o Order) String() string {
+	if o.Desc {
+		return o.Col + " DESC"
+	}
+	return o.Col
+}
+
+// idents validates and quotes the identifiers of a statement.
+type idents struct {
+	d      Dialect
+	schema Schema
+	tables map[string]string // tables maps the names and aliases to the tables of the statement.
+	alias  map[string]bool   // alias contains the column aliases of the select list.
+}
+
+// newIdents returns the idents of a statement in the dialect.
+func newIdents(d Dialect, schema Schema) *idents {
+	return &idents{d: d, schema: schema, tables: map[string]string{}, alias: map[string]bool{}}
+}
+
+// sub returns the idents of a subquery, which can refer to the tables of the outer statement.
+func (q *idents) sub(schema Schema) *idents {
+	if schema == nil {
+		schema = q.schema
+	}
+	s := newIdents(q.d, schema)
+	for k, v := range q.tables {
+		s.tables[k] = v
+	}
+	return s
+}
+
+// check returns an error if the name is not a valid identifier.
+func (q *idents) check(name string) error {
+	if !identRegexp.MatchString(name) {
+		return fmt.Errorf("%w: invalid identifier %q", ErrUnsafeSQL, name)
+	}
+	return nil
+}
+
+// table registers a table of the statement and returns its quoted name.
+func (q *idents) table(name, as string) (string, error) {
+	if err := q.check(name); err != nil {
+		return "", err
+	}
+	if strings.HasSuffix(name, "*") {
+		return "", fmt.Errorf("%w: invalid table %q", ErrUnsafeSQL, name)
+	}
+	if q.schema != nil {
+		if _, ok := q.schema[name]; !ok {
+			return "", fmt.Errorf("%w: table %q is not in schema", ErrUnsafeSQL, name)
+		}
+	}
+	q.tables[name] = name
+	if as == "" {
+		return q.d.Quote(name), nil
+	}
+	if err := q.check(as); err != nil || strings.Contains(as, ".") {
+		return "", fmt.Errorf("%w: invalid alias %q", ErrUnsafeSQL, as)
+	}
+	q.tables[as] = name
+	return q.d.Quote(name) + " AS " + q.d.Quote(as), nil
+}
+
+// col returns the quoted name of a column of the statement tables.
+func (q *idents) col(name string) (string, error) {
+	if err := q.check(name); err != nil {
+		return "", err
+	}
+	if q.schema == nil || name == "*" {
+		return q.d.Quote(name), nil
+	}
+
+	i := strings.LastIndex(name, ".")
+	table, col := name[:i+1], name[i+1:]
+	if table == "" {
+		if q.alias[col] {
+			return q.d.Quote(name), nil
+		}
+		for _, t := range q.tables {
+			if q.inSchema(t, col) {
+				return q.d.Quote(name), nil
+			}
+		}
+	} else if t, ok := q.tables[strings.TrimSuffix(table, ".")]; ok && q.inSchema(t, col) {
+		return q.d.Quote(name), nil
+	}
+	return "", fmt.Errorf("%w: column %q is not in schema", ErrUnsafeSQL, name)
+}
+
+// inSchema reports whether the column, or `*`, belongs to the table in schema.
+func (q *idents) inSchema(table, col string) bool {
+	if col == "*" {
+		return true
+	}
+	for _, c := range q.schema[table] {
+		if c == col {
+			return true
+		}
+	}
+	return false
+}
+
+// selectCol returns the quoted form of a select list entry, which is a column optionally followed by an alias,
+// e.g. `u.name AS user_name`.
+func (q *idents) selectCol(s string) (string, error) {
+	if s == "*" {
+		return s, nil
+	}
+	fields := strings.Fields(s)
+	switch {
+	case len(fields) == 1:
+		return q.col(fields[0])
+	case len(fields) == 3 && strings.EqualFold(fields[1], "as"):
+		col, err := q.col(fields[0])
+		if err != nil {
+			return "", err
+		}
+		if err := q.check(fields[2]); err != nil || strings.Contains(fields[2], ".") {
+			return "", fmt.Errorf("%w: invalid alias %q", ErrUnsafeSQL, fields[2])
+		}
+		q.alias[fields[2]] = true
+		return col + " AS " + q.d.Quote(fields[2]), nil
+	default:
+		return "", fmt.Errorf("%w: invalid select column %q", ErrUnsafeSQL, s)
+	}
+}
+
+// values returns a copy of the where clause values with quoted keys and column references.
+func (q *idents) values(values map[string]interface{}) (map[string]interface{}, error) {
+	quoted := make(map[string]interface{}, len(values))
+	for k, v := range values {
+		key, err := q.col(k)
+		if err != nil {
+			return nil, err
+		}
+		if col, ok := v.(Col); ok {
+			name, err := q.col(string(col))
+			if err != nil {
+				return nil, err
+			}
+			v = Col(name)
+		}
+		quoted[key] = v
+	}
+	return quoted, nil
+}
+
+// checkExpr returns an error if a raw expression contains literals or comments, or its placeholders do not
+// match the args. Values must be passed as args instead.
+func checkExpr(expr string, args []interface{}) error {
+	for _, token := range unsafeExprs {
+		if strings.Contains(expr, token) {
+			return fmt.Errorf("%w: expression %q contains %q, pass values as args", ErrUnsafeSQL, expr, token)
+		}
+	}
+	if n := strings.Count(expr, "?"); n != len(args) {
+		return fmt.Errorf("expression %q has %d placeholders, but %d args are given", expr, n, len(args))
+	}
+	return nil
+}
+
+var (
+	// exprKeywords are the keywords accepted in raw expressions.
+	exprKeywords = map[string]bool{
+		"AND": true, "OR": true, "NOT": true, "IS": true, "NULL": true, "TRUE": true, "FALSE": true,
+		"BETWEEN": true, "IN": true, "LIKE": true, "DISTINCT": true,
+	}
+	// exprFuncs are the functions accepted in raw expressions, which are the aggregate functions.
+	exprFuncs = map[string]bool{"COUNT": true, "SUM": true, "AVG": true, "MIN": true, "MAX": true}
+	// exprOperators are the operators and punctuation of raw expressions, longer ones first.
+	exprOperators = []string{"<=", ">=", "<>", "!=", "=", "<", ">", "+", "-", "*", "/", "%", "(", ")", ","}
+	// exprCompares are the comparison operators of raw expressions.
+	exprCompares = map[string]bool{"=": true, "<>": true, "!=": true, "<": true, ">": true, "<=": true, ">=": true}
+	// exprNumber matches a number of raw expressions.
+	exprNumber = regexp.MustCompile(`^[0-9]+(\.[0-9]+)?$`)
+)
+
+// exprToken is a token of a raw expression, which is an identifier, a number, a placeholder or an operator.
+type exprToken struct {
+	ident      bool
+	text       string
+	start, end int
+}
+
+// exprParser parses a raw expression of the restricted grammar of Having: comparisons, arithmetic, AND, OR, NOT,
+// IS NULL, BETWEEN, IN and LIKE of columns, numbers, placeholders and the aggregate functions.
+type exprParser struct {
+	q    *idents
+	expr string
+	toks []exprToken
+	i    int
+	cols map[int]string // cols are the quoted columns by token index.
+}
+
+// expr validates a raw expression, whose values must be passed as args, and returns it with quoted columns.
+// Columns are checked like the other identifiers of the statement, e.g. against its Schema.
+func (q *idents) expr(expr string, args []interface{}) (string, error) {
+	if err := checkExpr(expr, args); err != nil {
+		return "", err
+	}
+	p := exprParser{q: q, expr: expr, cols: map[int]string{}}
+	if err := p.tokenize(); err != nil {
+		return "", err
+	}
+	if err := p.or(); err != nil {
+		return "", err
+	}
+	if p.i < len(p.toks) {
+		return "", p.unexpected()
+	}
+
+	buf := strings.Builder{}
+	last := 0
+	for i, tok := range p.toks {
+		if col, ok := p.cols[i]; ok {
+			buf.WriteString(expr[last:tok.start])
+			buf.WriteString(col)
+			last = tok.end
+		}
+	}
+	buf.WriteString(expr[last:])
+	return buf.String(), nil
+}
+
+// tokenize splits the expression into tokens.
+func (p *exprParser) tokenize() error {
+	isWord := func(c byte) bool {
+		return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '_' || c == '$' || c == '.'
+	}
+	for i := 0; i < len(p.expr); {
+		c := p.expr[i]
+		if c == ' ' || c == '\t' || c == '\n' || c == '\r' {
+			i++
+			continue
+		}
+		if c == '?' {
+			p.toks = append(p.toks, exprToken{text: "?", start: i, end: i + 1})
+			i++
+			continue
+		}
+		if isWord(c) {
+			j := i
+			for j < len(p.expr) && isWord(p.expr[j]) {
+				j++
+			}
+			word := p.expr[i:j]
+			if !exprNumber.MatchString(word) && !identRegexp.MatchString(word) {
+				return fmt.Errorf("%w: invalid token %q in expression %q", ErrUnsafeSQL, word, p.expr)
+			}
+			p.toks = append(p.toks, exprToken{ident: !exprNumber.MatchString(word), text: word, start: i, end: j})
+			i = j
+			continue
+		}
+		op := ""
+		for _, o := range exprOperators {
+			if strings.HasPrefix(p.expr[i:], o) {
+				op = o
+				break
+			}
+		}
+		if op == "" {
+			return fmt.Errorf("%w: invalid character %q in expression %q", ErrUnsafeSQL, c, p.expr)
+		}
+		p.toks = append(p.toks, exprToken{text: op, start: i, end: i + len(op)})
+		i += len(op)
+	}
+	return nil
+}
+
+// unexpected returns the error of the current token.
+func (p *exprParser) unexpected() error {
+	if p.i >= len(p.toks) {
+		return fmt.Errorf("%w: unexpected end of expression %q", ErrUnsafeSQL, p.expr)
+	}
+	return fmt.Errorf("%w: unexpected %q in expression %q", ErrUnsafeSQL, p.toks[p.i].text, p.expr)
+}
+
+// keyword reports whether the current token is the keyword and consumes it.
+func (p *exprParser) keyword(k string) bool {
+	if p.i < len(p.toks) && p.toks[p.i].ident && strings.EqualFold(p.toks[p.i].text, k) {
+		p.i++
+		return true
+	}
+	return false
+}
+
+// op reports whether the current token is one of the operators and consumes it.
+func (p *exprParser) op(ops ...string) bool {
+	if p.i < len(p.toks) && !p.toks[p.i].ident {
+		for _, o := range ops {
+			if p.toks[p.i].text == o {
+				p.i++
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// expect consumes the operator or returns an error.
+func (p *exprParser) expect(op string) error {
+	if !p.op(op) {
+		return p.unexpected()
+	}
+	return nil
+}
+
+// or parses `and {OR and}`.
+func (p *exprParser) or() error {
+	for {
+		if err := p.and(); err != nil {
+			return err
+		}
+		if !p.keyword("OR") {
+			return nil
+		}
+	}
+}
+
+// and parses `not {AND not}`.
+func (p *exprParser) and() error {
+	for {
+		if err := p.not(); err != nil {
+			return err
+		}
+		if !p.keyword("AND") {
+			return nil
+		}
+	}
+}
+
+// not parses `NOT not` or a comparison.
+func (p *exprParser) not() error {
+	if p.keyword("NOT") {
+		return p.not()
+	}
+	return p.compare()
+}
+
+// compare parses a sum optionally compared to another operand.
+func (p *exprParser) compare() error {
+	if err := p.sum(); err != nil {
+		return err
+	}
+	if p.i < len(p.toks) && !p.toks[p.i].ident && exprCompares[p.toks[p.i].text] {
+		p.i++
+		return p.sum()
+	}
+	if p.keyword("IS") {
+		p.keyword("NOT")
+		if p.keyword("NULL") || p.keyword("TRUE") || p.keyword("FALSE") {
+			return nil
+		}
+		return p.unexpected()
+	}
+	negated := p.keyword("NOT")
+	switch {
+	case p.keyword("BETWEEN"):
+		if err := p.sum(); err != nil {
+			return err
+		}
+		if !p.keyword("AND") {
+			return p.unexpected()
+		}
+		return p.sum()
+	case p.keyword("IN"):
+		if err := p.expect("("); err != nil {
+			return err
+		}
+		for {
+			if err := p.sum(); err != nil {
+				return err
+			}
+			if !p.op(",") {
+				return p.expect(")")
+			}
+		}
+	case p.keyword("LIKE"):
+		return p.sum()
+	case negated:
+		return p.unexpected()
+	}
+	return nil
+}
+
+// sum parses `term {(+|-) term}`.
+func (p *exprParser) sum() error {
+	for {
+		if err := p.term(); err != nil {
+			return err
+		}
+		if !p.op("+", "-") {
+			return nil
+		}
+	}
+}
+
+// term parses `unary {(*|/|%) unary}`.
+func (p *exprParser) term() error {
+	for {
+		if err := p.unary(); err != nil {
+			return err
+		}
+		if !p.op("*", "/", "%") {
+			return nil
+		}
+	}
+}
+
+// unary parses a signed operand.
+func (p *exprParser) unary() error {
+	if p.op("-", "+") {
+		return p.unary()
+	}
+	return p.operand()
+}
+
+// operand parses a number, a placeholder, a parenthesized expression, an aggregate function or a column.
+func (p *exprParser) operand() error {
+	if p.i >= len(p.toks) {
+		return p.unexpected()
+	}
+	tok := p.toks[p.i]
+	if !tok.ident {
+		switch {
+		case tok.text == "?" || exprNumber.MatchString(tok.text):
+			p.i++
+			return nil
+		case p.op("("):
+			if err := p.or(); err != nil {
+				return err
+			}
+			return p.expect(")")
+		}
+		return p.unexpected()
+	}
+
+	name := strings.ToUpper(tok.text)
+	called := p.i+1 < len(p.toks) && p.toks[p.i+1].text == "(" && !p.toks[p.i+1].ident
+	switch {
+	case called && exprFuncs[name]:
+		p.i += 2
+		p.keyword("DISTINCT")
+		if !(name == "COUNT" && p.op("*")) {
+			if err := p.sum(); err != nil {
+				return err
+			}
+		}
+		return p.expect(")")
+	case called:
+		return fmt.Errorf("%w: function %q is not allowed in expression %q", ErrUnsafeSQL, tok.text, p.expr)
+	case name == "NULL" || name == "TRUE" || name == "FALSE":
+		p.i++
+		return nil
+	case exprKeywords[name]:
+		return p.unexpected()
+	}
+	col, err := p.q.col(tok.text)
+	if err != nil {
+		return err
+	}
+	p.cols[p.i] = col
+	p.i++
+	return nil
+}
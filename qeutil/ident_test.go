@@ -0,0 +1,112 @@
+package qeutil
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSelectClauseSQLStmUnsafe(t *testing.T) {
+	for _, sc := range []SelectClause{
+		{From: "table; DROP TABLE users"},
+		{From: "table", As: "t t"},
+		{From: "table", Select: []string{"id, (SELECT password FROM users)"}},
+		{From: "table", Where: []Wh{Wh{Operator: Eq, Values: map[string]interface{}{"id = 1 OR 1": 1}}}},
+		{From: "table", Where: []Wh{Wh{Operator: Eq, Values: map[string]interface{}{"id": Col("1 OR 1")}}}},
+		{From: "table", GroupBy: []string{"id`"}},
+		{From: "table", OrderBy: []Order{Asc("(CASE WHEN 1 THEN id END)")}},
+		{From: "table", Having: "COUNT(*) > 'a'"},
+		{From: "table", Having: "COUNT(*) > 1; --"},
+		{From: "table", Having: "1=1 UNION SELECT password FROM users"},
+		{From: "table", Having: "SLEEP(5) > 0"},
+		{From: "table", Having: "COUNT(*) > (SELECT COUNT(*) FROM users)"},
+		{From: "table", Having: "COUNT(*) >"},
+		{From: "table", Joins: []Join{Join{Type: InnerJoin, Table: "users u"}}},
+	} {
+		_, _, err := sc.SQLStm()
+		assert.True(t, errors.Is(err, ErrUnsafeSQL), sc)
+	}
+
+	_, _, err := (&SelectClause{From: "table", Having: "COUNT(*) > ?"}).SQLStm()
+	assert.Error(t, err)
+}
+
+func TestSelectClauseSQLStmHaving(t *testing.T) {
+	sc := SelectClause{
+		Select:     []string{"exam_id", "u.name AS user_name"},
+		From:       "answers",
+		Joins:      []Join{Join{Type: InnerJoin, Table: "users", As: "u", On: []Wh{Wh{Operator: Eq, Values: map[string]interface{}{"u.id": Col("answers.user_id")}}}}},
+		GroupBy:    []string{"exam_id", "user_name"},
+		Having:     "COUNT(*) > ?",
+		HavingArgs: []interface{}{3},
+		OrderBy:    []Order{Desc("user_name")},
+		Dialect:    PostgreSQL,
+	}
+	stm, val, err := sc.SQLStm()
+	assert.NoError(t, err)
+	assert.Equal(t, `SELECT "exam_id", "u"."name" AS "user_name" FROM "answers" INNER JOIN "users" AS "u" ON "u"."id" = "answers"."user_id" GROUP BY "exam_id", "user_name" HAVING COUNT(*) > $1 ORDER BY "user_name" DESC`, stm)
+	assert.Equal(t, []interface{}{3}, val)
+	assert.Equal(t, "answers:join:inner=users@u({u.id=@answers.user_id}):sel:exam_id&u.name%20as%20user_name:grp:exam_id&user_name:hav:count%28%2a%29%20%3e%20%3f&3:ord:user_name desc", sc.CacheKey())
+}
+
+func TestSelectClauseSQLStmSchema(t *testing.T) {
+	schema := Schema{
+		"answers": {"id", "exam_id", "user_id", "score"},
+		"users":   {"id", "name"},
+	}
+	sc := SelectClause{
+		Select:  []string{"a.*", "u.name"},
+		From:    "answers",
+		As:      "a",
+		Joins:   []Join{Join{Type: LeftJoin, Table: "users", As: "u", On: []Wh{Wh{Operator: Eq, Values: map[string]interface{}{"u.id": Col("a.user_id")}}}}},
+		Where:   []Wh{Wh{Operator: Gt, Values: map[string]interface{}{"score": 50}}},
+		OrderBy: []Order{Asc("a.id")},
+		Schema:  schema,
+	}
+	stm, _, err := sc.SQLStm()
+	assert.NoError(t, err)
+	assert.Equal(t, "SELECT `a`.*, `u`.`name` FROM `answers` AS `a` LEFT JOIN `users` AS `u` ON `u`.`id` = `a`.`user_id` WHERE `score` > ? ORDER BY `a`.`id`", stm)
+
+	for _, order := range []Order{Asc("password"), Asc("u.score"), Asc("x.id")} {
+		sc.OrderBy = []Order{order}
+		_, _, err = sc.SQLStm()
+		assert.True(t, errors.Is(err, ErrUnsafeSQL), order)
+	}
+
+	sc.OrderBy = nil
+	sc.GroupBy = []string{"a.user_id"}
+	sc.Having = "COUNT(DISTINCT a.exam_id) >= ? AND SUM(score) NOT BETWEEN ? AND ? OR MAX(u.name) IS NULL"
+	sc.HavingArgs = []interface{}{2, 0, 10}
+	stm, _, err = sc.SQLStm()
+	assert.NoError(t, err)
+	assert.Contains(t, stm, "HAVING COUNT(DISTINCT `a`.`exam_id`) >= ? AND SUM(`score`) NOT BETWEEN ? AND ? OR MAX(`u`.`name`) IS NULL")
+	for _, having := range []string{"SUM(password) > ?", "1=1 UNION SELECT password FROM users WHERE id > ?"} {
+		sc.Having = having
+		sc.HavingArgs = []interface{}{1}
+		_, _, err = sc.SQLStm()
+		assert.True(t, errors.Is(err, ErrUnsafeSQL), having)
+	}
+
+	_, _, err = (&DeleteClause{From: "exams", Schema: schema}).SQLStm()
+	assert.True(t, errors.Is(err, ErrUnsafeSQL))
+	_, _, err = (&UpdateClause{Update: "users", Set: map[string]interface{}{"admin": true}, Schema: schema}).SQLStm()
+	assert.True(t, errors.Is(err, ErrUnsafeSQL))
+	_, _, err = (&InsertClause{Into: "users", Values: map[string]interface{}{"name": "a"}, Schema: schema}).SQLStm()
+	assert.NoError(t, err)
+}
+
+func TestParseOrder(t *testing.T) {
+	for s, want := range map[string]Order{
+		"name":      Asc("name"),
+		"-name":     Desc("name"),
+		"name DESC": Desc("name"),
+		"name asc":  Asc("name"),
+	} {
+		order, err := ParseOrder(s)
+		assert.NoError(t, err)
+		assert.Equal(t, want, order)
+	}
+	_, err := ParseOrder("name; DROP TABLE users")
+	assert.True(t, errors.Is(err, ErrUnsafeSQL))
+}
@@ -1,24 +1,412 @@
 package qeutil
 
 import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
 	sq "github.com/Masterminds/squirrel"
+	"github.com/jmoiron/sqlx"
 )
 
-// InsertClause .
+const (
+	// InsertIgnore representing the INSERT IGNORE statement in MySQL
+	InsertIgnore string = "ignore"
+	// Replace representing the REPLACE INTO statement in MySQL
+	Replace string = "replace"
+
+	// MaxPlaceholders is the maximum number of placeholders in a MySQL prepared statement.
+	MaxPlaceholders int = 65535
+	// maxSQLitePlaceholders is the default SQLITE_MAX_VARIABLE_NUMBER before SQLite 3.32.
+	maxSQLitePlaceholders int = 999
+)
+
+// InsertClause contains the table and rows of an insert statement.
+// Values and each of Rows are inserted as a row, all rows must have the same columns. The columns are
+// ordered as Columns, or sorted by name if Columns is not given. OnDuplicate sets the ON DUPLICATE KEY UPDATE
+// assignments, use ValuesOf to refer to the value which would have been inserted. PostgreSQL and SQLite
+// render them as ON CONFLICT DO UPDATE, which requires the unique columns in Conflict.
+// PostgreSQL drivers do not support the last insert id, Exec returns the Returning column of the first row instead,
+// or no id if Returning is not given.
 type InsertClause struct {
-	Into   string
-	Values map[string]interface{}
+	Into        string
+	Values      map[string]interface{}
+	Rows        []map[string]interface{}
+	Columns     []string
+	Mode        string
+	OnDuplicate map[string]interface{}
+	Conflict    []string
+	Dialect     Dialect // Dialect of the statement, MySQL if not given.
+	Schema      Schema  // Schema limits the table and columns of the statement if given.
+	Returning   string  // Returning is the generated id column, e.g. `id`, returned by Exec in PostgreSQL.
+}
+
+// BatchLimit limits the size of each statement when a multi-row InsertClause is split into batches.
+type BatchLimit struct {
+	MaxPlaceholders int // MaxPlaceholders defaults to the limit of the dialect, e.g. MaxPlaceholders of MySQL.
+	MaxBytes        int // MaxBytes is the estimated size of a statement and its args, e.g. max_allowed_packet.
+}
+
+// Inserted is the value of a column which would have been inserted, see ValuesOf.
+type Inserted string
+
+// ValuesOf refers to the value of the column which would have been inserted in OnDuplicate.
+// It is rendered as VALUES(column) in MySQL and EXCLUDED.column in PostgreSQL and SQLite.
+func ValuesOf(column string) Inserted {
+	return Inserted(column)
+}
+
+// maxPlaceholders returns the maximum number of placeholders in a statement of the dialect.
+func (d Dialect) maxPlaceholders() int {
+	if d.orDefault() == SQLite {
+		return maxSQLitePlaceholders
+	}
+	return MaxPlaceholders
+}
+
+// rows returns all rows of the InsertClause.
+func (ic *InsertClause) rows() []map[string]interface{} {
+	if ic.Values == nil {
+		return ic.Rows
+	}
+	return append([]map[string]interface{}{ic.Values}, ic.Rows...)
+}
+
+// columns returns the column order of the InsertClause and checks that every row has exactly these columns.
+func (ic *InsertClause) columns() ([]string, error) {
+	rows := ic.rows()
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("no values to insert into %v", ic.Into)
+	}
+
+	cols := ic.Columns
+	if len(cols) == 0 {
+		cols = make([]string, 0, len(rows[0]))
+		for k := range rows[0] {
+			cols = append(cols, k)
+		}
+		sort.Strings(cols)
+	}
+	for i := range rows {
+		if len(rows[i]) != len(cols) {
+			return nil, fmt.Errorf("row %d of %v has %d columns, want %d", i, ic.Into, len(rows[i]), len(cols))
+		}
+		for _, col := range cols {
+			if _, ok := rows[i][col]; !ok {
+				return nil, fmt.Errorf("row %d of %v misses column %v", i, ic.Into, col)
+			}
+		}
+	}
+	return cols, nil
 }
 
-// SQLStm return a MySQL query statment from the InsertClause.
+// SQLStm return a query statment of the Dialect from the InsertClause.
 func (ic *InsertClause) SQLStm() (string, []interface{}, error) {
-	var values []interface{}
-	builder := sq.Insert(ic.Into)
+	if err := ic.Dialect.check(); err != nil {
+		return "", nil, err
+	}
+	cols, err := ic.columns()
+	if err != nil {
+		return "", nil, err
+	}
+
+	keyword, option, err := ic.Dialect.insertKeyword(ic.Mode)
+	if err != nil {
+		return "", nil, err
+	}
+	q := newIdents(ic.Dialect, ic.Schema)
+	table, err := q.table(ic.Into, "")
+	if err != nil {
+		return "", nil, err
+	}
+	names := make([]string, len(cols))
+	for i := range cols {
+		if names[i], err = q.col(cols[i]); err != nil {
+			return "", nil, err
+		}
+	}
+	builder := sq.Insert(table)
+	if keyword == "REPLACE" {
+		builder = sq.Replace(table)
+	}
+	if option != "" {
+		builder = builder.Options(option)
+	}
+	builder = builder.PlaceholderFormat(ic.Dialect.placeholder())
 
-	for k, v := range ic.Values {
-		builder = builder.Columns(k)
-		values = append(values, v)
+	builder = builder.Columns(names...)
+	for _, row := range ic.rows() {
+		values := make([]interface{}, len(cols))
+		for i, col := range cols {
+			values[i] = row[col]
+		}
+		builder = builder.Values(values...)
+	}
+
+	if len(ic.OnDuplicate) > 0 {
+		if ic.Mode == Replace {
+			return "", nil, fmt.Errorf("replace into %v does not accept on duplicate key update", ic.Into)
+		}
+		if ic.Mode == InsertIgnore && ic.Dialect.orDefault() == PostgreSQL {
+			return "", nil, fmt.Errorf("insert ignore into %v does not accept on duplicate key update in %v", ic.Into, PostgreSQL)
+		}
+		stm, val, err := onDuplicateSQLStm(q, ic.Conflict, ic.OnDuplicate)
+		if err != nil {
+			return "", nil, err
+		}
+		builder = builder.Suffix(stm, val...)
+	} else if ic.Mode == InsertIgnore && ic.Dialect.orDefault() == PostgreSQL {
+		target, err := conflictTarget(q, ic.Conflict)
+		if err != nil {
+			return "", nil, err
+		}
+		builder = builder.Suffix(target + "DO NOTHING")
+	}
+	if ic.Returning != "" && ic.Dialect.orDefault() == PostgreSQL {
+		col, err := q.col(ic.Returning)
+		if err != nil {
+			return "", nil, err
+		}
+		builder = builder.Suffix("RETURNING " + col)
 	}
-	builder = builder.Values(values...)
 	return builder.ToSql()
 }
+
+// conflictTarget returns the ON CONFLICT clause of the unique columns followed by a space.
+func conflictTarget(q *idents, conflict []string) (string, error) {
+	if len(conflict) == 0 {
+		return "ON CONFLICT ", nil
+	}
+	cols := make([]string, len(conflict))
+	for i := range conflict {
+		col, err := q.col(conflict[i])
+		if err != nil {
+			return "", err
+		}
+		cols[i] = col
+	}
+	return "ON CONFLICT (" + strings.Join(cols, ", ") + ") ", nil
+}
+
+// onDuplicateSQLStm returns the ON DUPLICATE KEY UPDATE statement of the given assignments, or the ON CONFLICT
+// DO UPDATE statement in PostgreSQL and SQLite.
+func onDuplicateSQLStm(q *idents, conflict []string, set map[string]interface{}) (string, []interface{}, error) {
+	keys := make([]string, 0, len(set))
+	for k := range set {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var args []interface{}
+	buf := bytes.Buffer{}
+	d := q.d.orDefault()
+	if d == MySQL {
+		buf.WriteString("ON DUPLICATE KEY UPDATE ")
+	} else {
+		if len(conflict) == 0 {
+			return "", nil, fmt.Errorf("on conflict do update requires the conflict columns in %v", d)
+		}
+		target, err := conflictTarget(q, conflict)
+		if err != nil {
+			return "", nil, err
+		}
+		buf.WriteString(target)
+		buf.WriteString("DO UPDATE SET ")
+	}
+	for i, key := range keys {
+		if i > 0 {
+			buf.WriteString(", ")
+		}
+		name, err := q.col(key)
+		if err != nil {
+			return "", nil, err
+		}
+		buf.WriteString(name)
+		buf.WriteString(" = ")
+		switch v := set[key].(type) {
+		case Col:
+			col, err := q.col(string(v))
+			if err != nil {
+				return "", nil, err
+			}
+			buf.WriteString(col)
+		case Inserted:
+			col, err := q.col(string(v))
+			if err != nil {
+				return "", nil, err
+			}
+			if d == MySQL {
+				buf.WriteString("VALUES(" + col + ")")
+			} else {
+				buf.WriteString("EXCLUDED." + col)
+			}
+		default:
+			buf.WriteString("?")
+			args = append(args, v)
+		}
+	}
+	return buf.String(), args, nil
+}
+
+// Batches splits the rows of the InsertClause into InsertClauses whose statements fit the given limit.
+// A single row exceeding MaxBytes is still put in its own batch.
+func (ic *InsertClause) Batches(limit BatchLimit) ([]InsertClause, error) {
+	cols, err := ic.columns()
+	if err != nil {
+		return nil, err
+	}
+	maxPlaceholders := limit.MaxPlaceholders
+	if maxPlaceholders <= 0 || maxPlaceholders > ic.Dialect.maxPlaceholders() {
+		maxPlaceholders = ic.Dialect.maxPlaceholders()
+	}
+	extra := len(ic.OnDuplicate)
+	if maxPlaceholders < len(cols)+extra {
+		return nil, fmt.Errorf("a row of %v needs %d placeholders, limit is %d", ic.Into, len(cols)+extra, maxPlaceholders)
+	}
+
+	// Base size is the statement without rows, each row costs its placeholders and args
+	base := len(ic.Into) + 64
+	for _, col := range cols {
+		base += len(col) + 1
+	}
+	for k, v := range ic.OnDuplicate {
+		base += len(k) + argSize(v) + 4
+	}
+
+	var batches []InsertClause
+	var batch []map[string]interface{}
+	size := base
+	for _, row := range ic.rows() {
+		rowSize := len(cols)*2 + 3
+		for _, col := range cols {
+			rowSize += argSize(row[col])
+		}
+		full := (len(batch)+1)*len(cols)+extra > maxPlaceholders || (limit.MaxBytes > 0 && size+rowSize > limit.MaxBytes)
+		if full && len(batch) > 0 {
+			batches = append(batches, ic.withRows(cols, batch))
+			batch, size = nil, base
+		}
+		batch = append(batch, row)
+		size += rowSize
+	}
+	return append(batches, ic.withRows(cols, batch)), nil
+}
+
+// withRows returns a copy of the InsertClause inserting the given rows.
+func (ic *InsertClause) withRows(cols []string, rows []map[string]interface{}) InsertClause {
+	return InsertClause{
+		Into:        ic.Into,
+		Rows:        rows,
+		Columns:     cols,
+		Mode:        ic.Mode,
+		OnDuplicate: ic.OnDuplicate,
+		Conflict:    ic.Conflict,
+		Dialect:     ic.Dialect,
+		Schema:      ic.Schema,
+	}
+}
+
+// argSize estimates the size of an arg in a statement.
+func argSize(v interface{}) int {
+	switch v := v.(type) {
+	case string:
+		return len(v)
+	case []byte:
+		return len(v)
+	case Col:
+		return len(v)
+	case Inserted:
+		return len(v) + 10
+	default:
+		return len(fmt.Sprint(v))
+	}
+}
+
+// ToUnlinks return the patterns which can be used to unlink keys in redis.
+// All cache entries of the table are included since the new row may appear in any list of the table.
+func (ic *InsertClause) ToUnlinks() []string {
+	return tableUnlinkPatterns(ic.Into)
+}
+
+// Exec executes the InsertClause and returns the last insert id, which is the Returning column of the first row in
+// PostgreSQL.
+func (ic *InsertClause) Exec(ctx context.Context, db sqlx.ExtContext) (int64, error) {
+	stm, val, err := ic.SQLStm()
+	if err != nil {
+		return 0, err
+	}
+	var id int64
+	err = traceQuery(ctx, OpInsert, ic.Into, stm, val, func(ctx context.Context) (int64, error) {
+		if ic.Dialect.orDefault() == PostgreSQL {
+			return ic.execReturning(ctx, db, stm, val, &id)
+		}
+		res, err := db.ExecContext(ctx, stm, val...)
+		if err != nil {
+			return -1, err
+		}
+		if id, err = res.LastInsertId(); err != nil {
+			return -1, err
+		}
+		return res.RowsAffected()
+	})
+	return id, err
+}
+
+// execReturning executes the statement of PostgreSQL, which scans the Returning column of the first row into id,
+// and returns the number of inserted rows.
+func (ic *InsertClause) execReturning(ctx context.Context, db sqlx.ExtContext, stm string, val []interface{}, id *int64) (int64, error) {
+	if ic.Returning == "" {
+		res, err := db.ExecContext(ctx, stm, val...)
+		if err != nil {
+			return -1, err
+		}
+		return res.RowsAffected()
+	}
+	rows, err := db.QueryContext(ctx, stm, val...)
+	if err != nil {
+		return -1, err
+	}
+	defer rows.Close()
+	var n int64
+	for rows.Next() {
+		if n == 0 {
+			if err := rows.Scan(id); err != nil {
+				return -1, err
+			}
+		}
+		n++
+	}
+	return n, rows.Err()
+}
+
+// ExecBatches executes the InsertClause in batches fitting the given limit and returns the number of affected rows.
+// Pass a transaction as db to insert all batches atomically.
+func (ic *InsertClause) ExecBatches(ctx context.Context, db sqlx.ExtContext, limit BatchLimit) (int64, error) {
+	batches, err := ic.Batches(limit)
+	if err != nil {
+		return 0, err
+	}
+	var affected int64
+	for i := range batches {
+		stm, val, err := batches[i].SQLStm()
+		if err != nil {
+			return affected, err
+		}
+		var n int64
+		err = traceQuery(ctx, OpInsert, ic.Into, stm, val, func(ctx context.Context) (int64, error) {
+			res, err := db.ExecContext(ctx, stm, val...)
+			if err != nil {
+				return -1, err
+			}
+			n, err = res.RowsAffected()
+			return n, err
+		})
+		if err != nil {
+			return affected, err
+		}
+		affected += n
+	}
+	return affected, nil
+}
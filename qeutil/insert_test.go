@@ -1,6 +1,9 @@
 package qeutil
 
 import (
+	"context"
+	"database/sql/driver"
+	"errors"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -15,6 +18,103 @@ func TestInsertClauseSQLStm(t *testing.T) {
 		},
 	}
 	stm, val, _ := ic.SQLStm()
-	assert.Equal(t, "INSERT INTO table (field1,field2) VALUES (?,?)", stm)
+	assert.Equal(t, "INSERT INTO `table` (`field1`,`field2`) VALUES (?,?)", stm)
 	assert.Equal(t, []interface{}{1, "2"}, val)
 }
+
+func TestInsertClauseExec(t *testing.T) {
+	db, _ := newFakeDB(func(stm string, args []driver.Value) fakeResp {
+		return fakeResp{lastID: 42, affected: 1}
+	})
+	ic := InsertClause{
+		Into:   "table",
+		Values: map[string]interface{}{"field1": 1},
+	}
+	id, err := ic.Exec(context.Background(), db)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(42), id)
+}
+
+func TestInsertClauseToUnlinks(t *testing.T) {
+	ic := InsertClause{Into: "table"}
+	assert.Equal(t, []string{"table", "table:*", "table:join:*", "*:join:*=table[@(]*"}, ic.ToUnlinks())
+}
+
+func TestInsertClauseSQLStmRows(t *testing.T) {
+	ic := InsertClause{
+		Into: "table",
+		Rows: []map[string]interface{}{
+			{"field2": "a", "field1": 1},
+			{"field1": 2, "field2": "b"},
+		},
+		Mode: InsertIgnore,
+	}
+	stm, val, err := ic.SQLStm()
+	assert.NoError(t, err)
+	assert.Equal(t, "INSERT IGNORE INTO `table` (`field1`,`field2`) VALUES (?,?),(?,?)", stm)
+	assert.Equal(t, []interface{}{1, "a", 2, "b"}, val)
+
+	ic.Mode = Replace
+	ic.Columns = []string{"field2", "field1"}
+	stm, val, err = ic.SQLStm()
+	assert.NoError(t, err)
+	assert.Equal(t, "REPLACE INTO `table` (`field2`,`field1`) VALUES (?,?),(?,?)", stm)
+	assert.Equal(t, []interface{}{"a", 1, "b", 2}, val)
+
+	ic.Rows = append(ic.Rows, map[string]interface{}{"field1": 3, "field3": "c"})
+	_, _, err = ic.SQLStm()
+	assert.Error(t, err)
+}
+
+func TestInsertClauseSQLStmOnDuplicate(t *testing.T) {
+	ic := InsertClause{
+		Into:   "table",
+		Values: map[string]interface{}{"id": 1, "score": 50},
+		OnDuplicate: map[string]interface{}{
+			"score":      ValuesOf("score"),
+			"updated_by": "sys",
+		},
+	}
+	stm, val, err := ic.SQLStm()
+	assert.NoError(t, err)
+	assert.Equal(t, "INSERT INTO `table` (`id`,`score`) VALUES (?,?) ON DUPLICATE KEY UPDATE `score` = VALUES(`score`), `updated_by` = ?", stm)
+	assert.Equal(t, []interface{}{1, 50, "sys"}, val)
+
+	ic.Mode = Replace
+	_, _, err = ic.SQLStm()
+	assert.Error(t, err)
+}
+
+func TestInsertClauseBatches(t *testing.T) {
+	ic := InsertClause{Into: "table"}
+	for i := 0; i < 5; i++ {
+		ic.Rows = append(ic.Rows, map[string]interface{}{"id": i, "name": "0123456789"})
+	}
+
+	batches, err := ic.Batches(BatchLimit{MaxPlaceholders: 4})
+	assert.NoError(t, err)
+	assert.Len(t, batches, 3)
+	stm, val, _ := batches[2].SQLStm()
+	assert.Equal(t, "INSERT INTO `table` (`id`,`name`) VALUES (?,?)", stm)
+	assert.Equal(t, []interface{}{4, "0123456789"}, val)
+
+	batches, err = ic.Batches(BatchLimit{MaxBytes: 100})
+	assert.NoError(t, err)
+	assert.Len(t, batches, 5)
+
+	_, err = ic.Batches(BatchLimit{MaxPlaceholders: 1})
+	assert.Error(t, err)
+
+	db, conn := newFakeDB(func(stm string, args []driver.Value) fakeResp {
+		return fakeResp{affected: int64(len(args) / 2)}
+	})
+	n, err := ic.ExecBatches(context.Background(), db, BatchLimit{MaxPlaceholders: 6})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), n)
+	assert.Len(t, conn.statements(), 2)
+
+	ic.Schema = Schema{"table": {"id"}}
+	_, err = ic.ExecBatches(context.Background(), db, BatchLimit{MaxPlaceholders: 6})
+	assert.True(t, errors.Is(err, ErrUnsafeSQL))
+	assert.Len(t, conn.statements(), 2)
+}
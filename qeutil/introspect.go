@@ -0,0 +1,338 @@
+package qeutil
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// TableInfo is a table of a MySQL schema, read by LoadSchema or ParseDDL.
+type TableInfo struct {
+	Name    string
+	Columns []ColumnInfo
+}
+
+// ColumnInfo is a column of a TableInfo. DataType is the lowercased type name, e.g. `int`, and ColumnType is the
+// full type, e.g. `int(10) unsigned` or `tinyint(1)`.
+type ColumnInfo struct {
+	Name          string
+	DataType      string
+	ColumnType    string
+	Nullable      bool
+	PrimaryKey    bool
+	AutoIncrement bool
+}
+
+// columnRow is a row of information_schema.COLUMNS.
+type columnRow struct {
+	Table      string `db:"table_name"`
+	Column     string `db:"column_name"`
+	DataType   string `db:"data_type"`
+	ColumnType string `db:"column_type"`
+	Nullable   string `db:"is_nullable"`
+	Key        string `db:"column_key"`
+	Extra      string `db:"extra"`
+}
+
+// LoadSchema reads the tables of the MySQL database from information_schema, in the order of their names and
+// the columns in their defined order.
+func LoadSchema(ctx context.Context, db sqlx.ExtContext, database string) ([]TableInfo, error) {
+	sc := SelectClause{
+		Select: []string{
+			"TABLE_NAME AS table_name", "COLUMN_NAME AS column_name", "DATA_TYPE AS data_type",
+			"COLUMN_TYPE AS column_type", "IS_NULLABLE AS is_nullable", "COLUMN_KEY AS column_key", "EXTRA AS extra",
+		},
+		From:    "information_schema.COLUMNS",
+		Where:   []Wh{Wh{Operator: Eq, Values: map[string]interface{}{"TABLE_SCHEMA": database}}},
+		OrderBy: []Order{Asc("TABLE_NAME"), Asc("ORDINAL_POSITION")},
+	}
+	var rows []columnRow
+	if err := sc.Query(ctx, db, &rows); err != nil {
+		return nil, err
+	}
+	var tables []TableInfo
+	for _, r := range rows {
+		if len(tables) == 0 || tables[len(tables)-1].Name != r.Table {
+			tables = append(tables, TableInfo{Name: r.Table})
+		}
+		t := &tables[len(tables)-1]
+		t.Columns = append(t.Columns, ColumnInfo{
+			Name:          r.Column,
+			DataType:      strings.ToLower(r.DataType),
+			ColumnType:    strings.ToLower(r.ColumnType),
+			Nullable:      strings.EqualFold(r.Nullable, "YES"),
+			PrimaryKey:    strings.EqualFold(r.Key, "PRI"),
+			AutoIncrement: strings.Contains(strings.ToLower(r.Extra), "auto_increment"),
+		})
+	}
+	return tables, nil
+}
+
+// SchemaOf returns the Schema allowing the tables and their columns.
+func SchemaOf(tables []TableInfo) Schema {
+	schema := make(Schema, len(tables))
+	for _, t := range tables {
+		cols := make([]string, len(t.Columns))
+		for i := range t.Columns {
+			cols[i] = t.Columns[i].Name
+		}
+		schema[t.Name] = cols
+	}
+	return schema
+}
+
+// ddlToken is a token of a DDL, an identifier quoted by backticks is never a keyword.
+type ddlToken struct {
+	text   string
+	quoted bool
+}
+
+// is reports whether the token is the keyword.
+func (t ddlToken) is(keyword string) bool {
+	return !t.quoted && strings.EqualFold(t.text, keyword)
+}
+
+// ddlTokens splits a DDL into words, quoted identifiers, string literals and punctuation, skipping comments.
+// String literals keep their quotes.
+func ddlTokens(ddl string) ([]ddlToken, error) {
+	var toks []ddlToken
+	for i := 0; i < len(ddl); {
+		c := ddl[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '#' || strings.HasPrefix(ddl[i:], "-- ") || strings.HasPrefix(ddl[i:], "--\n"):
+			for i < len(ddl) && ddl[i] != '\n' {
+				i++
+			}
+		case strings.HasPrefix(ddl[i:], "/*"):
+			end := strings.Index(ddl[i+2:], "*/")
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated comment at offset %d", i)
+			}
+			i += end + 4
+		case c == '`' || c == '\'' || c == '"':
+			j := i + 1
+			for ; j < len(ddl); j++ {
+				if ddl[j] == '\\' && c != '`' {
+					j++
+				} else if ddl[j] == c {
+					// A doubled quote is an escaped quote
+					if j+1 < len(ddl) && ddl[j+1] == c {
+						j++
+						continue
+					}
+					break
+				}
+			}
+			if j >= len(ddl) {
+				return nil, fmt.Errorf("unterminated quote at offset %d", i)
+			}
+			if c == '`' {
+				toks = append(toks, ddlToken{text: strings.Replace(ddl[i+1:j], "``", "`", -1), quoted: true})
+			} else {
+				toks = append(toks, ddlToken{text: ddl[i : j+1]})
+			}
+			i = j + 1
+		case isWordByte(c):
+			j := i
+			for j < len(ddl) && isWordByte(ddl[j]) {
+				j++
+			}
+			toks = append(toks, ddlToken{text: ddl[i:j]})
+			i = j
+		default:
+			toks = append(toks, ddlToken{text: ddl[i : i+1]})
+			i++
+		}
+	}
+	return toks, nil
+}
+
+// isWordByte reports whether the byte belongs to a keyword, an unquoted identifier or a number.
+func isWordByte(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '_' || c == '$' || c >= 0x80
+}
+
+// ddlIndexKeywords start the definitions of a CREATE TABLE other than columns.
+var ddlIndexKeywords = []string{"PRIMARY", "KEY", "INDEX", "UNIQUE", "CONSTRAINT", "FOREIGN", "FULLTEXT", "SPATIAL", "CHECK"}
+
+// ParseDDL reads the tables of the CREATE TABLE statements of a MySQL DDL, e.g. the output of
+// `mysqldump --no-data`. Other statements, e.g. `CREATE TABLE ... LIKE`, are ignored.
+func ParseDDL(ddl string) ([]TableInfo, error) {
+	toks, err := ddlTokens(ddl)
+	if err != nil {
+		return nil, err
+	}
+	var tables []TableInfo
+	for i := 0; i < len(toks); i++ {
+		if !toks[i].is("CREATE") {
+			continue
+		}
+		j := i + 1
+		if j < len(toks) && toks[j].is("TEMPORARY") {
+			j++
+		}
+		if j >= len(toks) || !toks[j].is("TABLE") {
+			continue
+		}
+		j++
+		if j+2 < len(toks) && toks[j].is("IF") && toks[j+1].is("NOT") && toks[j+2].is("EXISTS") {
+			j += 3
+		}
+		if j >= len(toks) {
+			break
+		}
+		name := toks[j].text
+		for j+2 < len(toks) && toks[j+1].text == "." && !toks[j+1].quoted {
+			name = toks[j+2].text
+			j += 2
+		}
+		j++
+		if j >= len(toks) || toks[j].text != "(" || toks[j].quoted {
+			continue
+		}
+		defs, end, err := ddlDefinitions(toks, j)
+		if err != nil {
+			return nil, fmt.Errorf("table %v: %w", name, err)
+		}
+		t, err := ddlTable(name, defs)
+		if err != nil {
+			return nil, fmt.Errorf("table %v: %w", name, err)
+		}
+		tables = append(tables, t)
+		i = end
+	}
+	return tables, nil
+}
+
+// ddlDefinitions splits the parenthesized definitions of a CREATE TABLE starting at toks[start] by the commas
+// outside of nested parentheses, and returns them with the index of the closing parenthesis.
+func ddlDefinitions(toks []ddlToken, start int) ([][]ddlToken, int, error) {
+	var defs [][]ddlToken
+	depth, from := 0, start+1
+	for i := start; i < len(toks); i++ {
+		if toks[i].quoted {
+			continue
+		}
+		switch toks[i].text {
+		case "(":
+			depth++
+		case ")":
+			depth--
+			if depth == 0 {
+				if i > from {
+					defs = append(defs, toks[from:i])
+				}
+				return defs, i, nil
+			}
+		case ",":
+			if depth == 1 {
+				defs = append(defs, toks[from:i])
+				from = i + 1
+			}
+		}
+	}
+	return nil, 0, fmt.Errorf("unbalanced parentheses")
+}
+
+// ddlTable returns the table of the definitions of a CREATE TABLE.
+func ddlTable(name string, defs [][]ddlToken) (TableInfo, error) {
+	t := TableInfo{Name: name}
+	var pks []string
+	for _, def := range defs {
+		keyword := ""
+		for _, k := range ddlIndexKeywords {
+			if def[0].is(k) {
+				keyword = k
+			}
+		}
+		switch keyword {
+		case "":
+			c, err := ddlColumn(def)
+			if err != nil {
+				return t, err
+			}
+			t.Columns = append(t.Columns, c)
+		case "PRIMARY", "CONSTRAINT":
+			pks = append(pks, ddlPrimaryKey(def)...)
+		}
+	}
+	for _, pk := range pks {
+		for i := range t.Columns {
+			if strings.EqualFold(t.Columns[i].Name, pk) {
+				t.Columns[i].PrimaryKey = true
+				t.Columns[i].Nullable = false
+			}
+		}
+	}
+	return t, nil
+}
+
+// ddlPrimaryKey returns the columns of a PRIMARY KEY definition, or nothing for other constraints.
+func ddlPrimaryKey(def []ddlToken) []string {
+	i := 0
+	for i < len(def) && !def[i].is("PRIMARY") {
+		i++
+	}
+	for i < len(def) && (def[i].quoted || def[i].text != "(") {
+		i++
+	}
+	var cols []string
+	for i++; i < len(def) && (def[i].quoted || def[i].text != ")"); i++ {
+		// Skip the prefix lengths and orders of key parts, e.g. `name`(10) DESC
+		if def[i].text == "(" && !def[i].quoted {
+			for i < len(def) && (def[i].quoted || def[i].text != ")") {
+				i++
+			}
+			continue
+		}
+		if def[i-1].text == "(" || def[i-1].text == "," {
+			cols = append(cols, def[i].text)
+		}
+	}
+	return cols
+}
+
+// ddlColumn returns the column of a column definition, e.g. `id int(10) unsigned NOT NULL AUTO_INCREMENT`.
+func ddlColumn(def []ddlToken) (ColumnInfo, error) {
+	if len(def) < 2 {
+		return ColumnInfo{}, fmt.Errorf("invalid column definition %q", def[0].text)
+	}
+	c := ColumnInfo{Name: def[0].text, DataType: strings.ToLower(def[1].text), Nullable: true}
+	typ := strings.Builder{}
+	typ.WriteString(c.DataType)
+	i := 2
+	if i < len(def) && def[i].text == "(" {
+		for ; i < len(def); i++ {
+			typ.WriteString(def[i].text)
+			if def[i].text == ")" {
+				i++
+				break
+			}
+		}
+	}
+	for ; i < len(def) && (def[i].is("UNSIGNED") || def[i].is("ZEROFILL")); i++ {
+		typ.WriteString(" " + strings.ToLower(def[i].text))
+	}
+	c.ColumnType = typ.String()
+	for ; i < len(def); i++ {
+		switch {
+		case def[i].is("NOT") && i+1 < len(def) && def[i+1].is("NULL"):
+			c.Nullable = false
+			i++
+		case def[i].is("AUTO_INCREMENT"):
+			c.AutoIncrement = true
+		case def[i].is("PRIMARY") && i+1 < len(def) && def[i+1].is("KEY"):
+			c.PrimaryKey = true
+			c.Nullable = false
+			i++
+		case def[i].is("DEFAULT") || def[i].is("COMMENT"):
+			// Skip the value, which may be NULL
+			i++
+		}
+	}
+	return c, nil
+}
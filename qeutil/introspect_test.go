@@ -0,0 +1,82 @@
+package qeutil
+
+import (
+	"context"
+	"database/sql/driver"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testDDL = "-- MySQL dump\n/*!40101 SET NAMES utf8 */;\nDROP TABLE IF EXISTS `answers`;\n" + `CREATE TABLE ` + "`answers`" + ` (
+  ` + "`id`" + ` bigint(20) unsigned NOT NULL AUTO_INCREMENT,
+  ` + "`user_id`" + ` int(11) NOT NULL COMMENT 'owner, see users(id)',
+  ` + "`score`" + ` decimal(10,2) DEFAULT NULL,
+  ` + "`status`" + ` enum('open','closed') NOT NULL DEFAULT 'open',
+  ` + "`is_public`" + ` tinyint(1) NOT NULL DEFAULT '1',
+  ` + "`created_at`" + ` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (` + "`id`" + `),
+  KEY ` + "`idx_user` (`user_id`,`status`(3))" + `,
+  CONSTRAINT ` + "`fk_user` FOREIGN KEY (`user_id`) REFERENCES `users` (`id`)" + `
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+CREATE TABLE IF NOT EXISTS app.user_roles (
+  user_id INT NOT NULL, # the user
+  role VARCHAR(20) NOT NULL,
+  note text,
+  CONSTRAINT pk_user_roles PRIMARY KEY (user_id, role DESC)
+);
+CREATE TABLE answers_copy LIKE answers;
+`
+
+func TestParseDDL(t *testing.T) {
+	tables, err := ParseDDL(testDDL)
+	assert.NoError(t, err)
+	assert.Equal(t, []TableInfo{
+		TableInfo{Name: "answers", Columns: []ColumnInfo{
+			ColumnInfo{Name: "id", DataType: "bigint", ColumnType: "bigint(20) unsigned", PrimaryKey: true, AutoIncrement: true},
+			ColumnInfo{Name: "user_id", DataType: "int", ColumnType: "int(11)"},
+			ColumnInfo{Name: "score", DataType: "decimal", ColumnType: "decimal(10,2)", Nullable: true},
+			ColumnInfo{Name: "status", DataType: "enum", ColumnType: "enum('open','closed')"},
+			ColumnInfo{Name: "is_public", DataType: "tinyint", ColumnType: "tinyint(1)"},
+			ColumnInfo{Name: "created_at", DataType: "datetime", ColumnType: "datetime"},
+		}},
+		TableInfo{Name: "user_roles", Columns: []ColumnInfo{
+			ColumnInfo{Name: "user_id", DataType: "int", ColumnType: "int", PrimaryKey: true},
+			ColumnInfo{Name: "role", DataType: "varchar", ColumnType: "varchar(20)", PrimaryKey: true},
+			ColumnInfo{Name: "note", DataType: "text", ColumnType: "text", Nullable: true},
+		}},
+	}, tables)
+	assert.Equal(t, Schema{"answers": {"id", "user_id", "score", "status", "is_public", "created_at"}, "user_roles": {"user_id", "role", "note"}}, SchemaOf(tables))
+
+	_, err = ParseDDL("CREATE TABLE t (id int")
+	assert.Error(t, err)
+	_, err = ParseDDL("CREATE TABLE t (name varchar(10) DEFAULT 'a)")
+	assert.Error(t, err)
+}
+
+func TestLoadSchema(t *testing.T) {
+	db, conn := newFakeDB(func(stm string, args []driver.Value) fakeResp {
+		return fakeResp{
+			cols: []string{"table_name", "column_name", "data_type", "column_type", "is_nullable", "column_key", "extra"},
+			rows: [][]driver.Value{
+				{"answers", "id", "bigint", "bigint(20) unsigned", "NO", "PRI", "auto_increment"},
+				{"answers", "body", "text", "text", "YES", "", ""},
+				{"users", "id", "int", "int(11)", "NO", "PRI", ""},
+			},
+		}
+	})
+	tables, err := LoadSchema(context.Background(), db, "app")
+	assert.NoError(t, err)
+	assert.Equal(t, []TableInfo{
+		TableInfo{Name: "answers", Columns: []ColumnInfo{
+			ColumnInfo{Name: "id", DataType: "bigint", ColumnType: "bigint(20) unsigned", PrimaryKey: true, AutoIncrement: true},
+			ColumnInfo{Name: "body", DataType: "text", ColumnType: "text", Nullable: true},
+		}},
+		TableInfo{Name: "users", Columns: []ColumnInfo{
+			ColumnInfo{Name: "id", DataType: "int", ColumnType: "int(11)", PrimaryKey: true},
+		}},
+	}, tables)
+	assert.Equal(t, []string{"SELECT `TABLE_NAME` AS `table_name`, `COLUMN_NAME` AS `column_name`, `DATA_TYPE` AS `data_type`, " +
+		"`COLUMN_TYPE` AS `column_type`, `IS_NULLABLE` AS `is_nullable`, `COLUMN_KEY` AS `column_key`, `EXTRA` AS `extra` " +
+		"FROM `information_schema`.`COLUMNS` WHERE `TABLE_SCHEMA` = ? ORDER BY `TABLE_NAME`, `ORDINAL_POSITION`"}, conn.statements())
+}
@@ -0,0 +1,104 @@
+package qeutil
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Lock modes of a SelectClause, which lock the read rows until the end of the transaction.
+const (
+	// ForUpdate locks the read rows for update, e.g. `SELECT ... FOR UPDATE`
+	ForUpdate string = "update"
+	// ForShare locks the read rows in share mode, e.g. `SELECT ... FOR SHARE`
+	ForShare string = "share"
+)
+
+// Lock waits of a SelectClause, which control the rows locked by other transactions.
+const (
+	// NoWait fails the read instead of waiting when a row is locked by another transaction
+	NoWait string = "nowait"
+	// SkipLocked skips the rows locked by other transactions, e.g. to claim queued jobs
+	SkipLocked string = "skip locked"
+)
+
+// Index hints of the main table of a SelectClause, which are only supported by MySQL.
+const (
+	// UseIndex hints the indexes considered by the optimizer
+	UseIndex string = "use"
+	// ForceIndex forces the optimizer to use one of the indexes
+	ForceIndex string = "force"
+	// IgnoreIndex forbids the optimizer to use the indexes
+	IgnoreIndex string = "ignore"
+)
+
+// ErrLockedRead is returned when a locking SelectClause is read with the cache, since its rows must be read from
+// the transaction holding the locks.
+var ErrLockedRead = errors.New("locking read cannot be cached")
+
+// IndexHint is a MySQL index hint of a table, e.g. `FORCE INDEX (idx_user_id)`.
+type IndexHint struct {
+	Type    string
+	Indexes []string
+}
+
+// indexHints returns the index hints of the main table, e.g. ` USE INDEX (`a`, `b`)`.
+func indexHints(q *idents, hints []IndexHint) (string, error) {
+	if len(hints) == 0 {
+		return "", nil
+	}
+	if d := q.d.orDefault(); d != MySQL {
+		return "", fmt.Errorf("index hints are not supported by %v", d)
+	}
+	buf := strings.Builder{}
+	for _, hint := range hints {
+		switch hint.Type {
+		case UseIndex, ForceIndex, IgnoreIndex:
+		default:
+			return "", fmt.Errorf("unknown index hint %q", hint.Type)
+		}
+		if len(hint.Indexes) == 0 && hint.Type != UseIndex {
+			return "", fmt.Errorf("%v index hint requires indexes", hint.Type)
+		}
+		indexes := make([]string, len(hint.Indexes))
+		for i, index := range hint.Indexes {
+			if err := q.check(index); err != nil || strings.Contains(index, ".") {
+				return "", fmt.Errorf("%w: invalid index %q", ErrUnsafeSQL, index)
+			}
+			indexes[i] = q.d.Quote(index)
+		}
+		buf.WriteString(" " + strings.ToUpper(hint.Type) + " INDEX (" + strings.Join(indexes, ", ") + ")")
+	}
+	return buf.String(), nil
+}
+
+// lockClause returns the locking clause of the lock mode and wait, e.g. `FOR UPDATE SKIP LOCKED`, which is
+// not supported by SQLite.
+func lockClause(d Dialect, lock, wait string) (string, error) {
+	if lock == "" {
+		if wait != "" {
+			return "", fmt.Errorf("lock wait %q requires a lock mode", wait)
+		}
+		return "", nil
+	}
+	if d.orDefault() == SQLite {
+		return "", fmt.Errorf("locking reads are not supported by %v", d.orDefault())
+	}
+	var clause string
+	switch lock {
+	case ForUpdate:
+		clause = "FOR UPDATE"
+	case ForShare:
+		clause = "FOR SHARE"
+	default:
+		return "", fmt.Errorf("unknown lock mode %q", lock)
+	}
+	switch wait {
+	case "":
+		return clause, nil
+	case NoWait, SkipLocked:
+		return clause + " " + strings.ToUpper(wait), nil
+	default:
+		return "", fmt.Errorf("unknown lock wait %q", wait)
+	}
+}
@@ -0,0 +1,114 @@
+package qeutil
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSelectClauseSQLStmLock(t *testing.T) {
+	sc := SelectClause{
+		From:     "grading_jobs",
+		Where:    []Wh{Wh{Operator: Eq, Values: map[string]interface{}{"status": "queued"}}},
+		OrderBy:  []Order{Asc("id")},
+		Limit:    intPtr(10),
+		Lock:     ForUpdate,
+		LockWait: SkipLocked,
+	}
+	stm, val, err := sc.SQLStm()
+	assert.NoError(t, err)
+	assert.Equal(t, "SELECT * FROM `grading_jobs` WHERE `status` = ? ORDER BY `id` LIMIT 10 FOR UPDATE SKIP LOCKED", stm)
+	assert.Equal(t, []interface{}{"queued"}, val)
+
+	sc.Dialect = PostgreSQL
+	sc.Lock = ForShare
+	sc.LockWait = NoWait
+	stm, _, err = sc.SQLStm()
+	assert.NoError(t, err)
+	assert.Equal(t, `SELECT * FROM "grading_jobs" WHERE "status" = $1 ORDER BY "id" LIMIT 10 FOR SHARE NOWAIT`, stm)
+
+	sc.Dialect = SQLite
+	_, _, err = sc.SQLStm()
+	assert.Error(t, err)
+
+	for _, c := range []SelectClause{
+		SelectClause{From: "grading_jobs", Lock: "exclusive"},
+		SelectClause{From: "grading_jobs", Lock: ForUpdate, LockWait: "wait 5"},
+		SelectClause{From: "grading_jobs", LockWait: NoWait},
+	} {
+		_, _, err = c.SQLStm()
+		assert.Error(t, err, "%v %v", c.Lock, c.LockWait)
+	}
+
+	// Existence checks lock the first row, aggregates cannot lock
+	sc.Dialect = MySQL
+	stm, _, err = sc.ExistsSQLStm()
+	assert.NoError(t, err)
+	assert.Equal(t, "SELECT 1 FROM `grading_jobs` WHERE `status` = ? LIMIT 1 FOR SHARE NOWAIT", stm)
+	sc.Dialect = PostgreSQL
+	sc.Lock, sc.LockWait = ForUpdate, ""
+	stm, _, err = sc.ExistsSQLStm()
+	assert.NoError(t, err)
+	assert.Equal(t, `SELECT 1 FROM "grading_jobs" WHERE "status" = $1 LIMIT 1 FOR UPDATE`, stm)
+	_, _, err = sc.Count().SQLStm()
+	assert.Error(t, err)
+	sc.GroupBy = []string{"status"}
+	_, _, err = sc.Count().SQLStm()
+	assert.Error(t, err)
+}
+
+func TestQueryWithCacheLock(t *testing.T) {
+	db, conn := newFakeDB(func(stm string, args []driver.Value) fakeResp {
+		return fakeResp{cols: []string{"id", "name"}, rows: [][]driver.Value{{int64(1), "a"}}}
+	})
+	fc := newFakeCache()
+	var rows []testRow
+	sc := SelectClause{From: "grading_jobs", Lock: ForUpdate}
+	err := sc.QueryWithCache(context.Background(), db, &CacheConfig{Cache: fc, Expiry: time.Minute}, &rows)
+	assert.True(t, errors.Is(err, ErrLockedRead))
+	assert.Empty(t, conn.statements())
+	assert.Empty(t, fc.keys())
+
+	// Existence checks and aggregates are not cached either
+	err = sc.ExistsWithCache(context.Background(), db, &CacheConfig{Cache: fc, Expiry: time.Minute})
+	assert.True(t, errors.Is(err, ErrLockedRead))
+	var n int64
+	err = sc.Count().GetWithCache(context.Background(), db, &CacheConfig{Cache: fc, Expiry: time.Minute}, &n)
+	assert.True(t, errors.Is(err, ErrLockedRead))
+	assert.Empty(t, conn.statements())
+	assert.Empty(t, fc.keys())
+}
+
+func TestSelectClauseSQLStmIndexHints(t *testing.T) {
+	sc := SelectClause{
+		From:       "answers",
+		As:         "a",
+		Where:      []Wh{Wh{Operator: Eq, Values: map[string]interface{}{"a.user_id": 7}}},
+		IndexHints: []IndexHint{IndexHint{Type: ForceIndex, Indexes: []string{"idx_user_id", "PRIMARY"}}, IndexHint{Type: IgnoreIndex, Indexes: []string{"idx_exam"}}},
+	}
+	stm, _, err := sc.SQLStm()
+	assert.NoError(t, err)
+	assert.Equal(t, "SELECT * FROM `answers` AS `a` FORCE INDEX (`idx_user_id`, `PRIMARY`) IGNORE INDEX (`idx_exam`) WHERE `a`.`user_id` = ?", stm)
+
+	sc.IndexHints = []IndexHint{IndexHint{Type: UseIndex}}
+	stm, _, err = sc.SQLStm()
+	assert.NoError(t, err)
+	assert.Equal(t, "SELECT * FROM `answers` AS `a` USE INDEX () WHERE `a`.`user_id` = ?", stm)
+
+	sc.IndexHints = []IndexHint{IndexHint{Type: ForceIndex, Indexes: []string{"idx`) UNION SELECT"}}}
+	_, _, err = sc.SQLStm()
+	assert.True(t, errors.Is(err, ErrUnsafeSQL))
+
+	sc.IndexHints = []IndexHint{IndexHint{Type: ForceIndex}}
+	_, _, err = sc.SQLStm()
+	assert.Error(t, err)
+
+	sc.IndexHints = []IndexHint{IndexHint{Type: UseIndex, Indexes: []string{"idx_user_id"}}}
+	sc.Dialect = PostgreSQL
+	_, _, err = sc.SQLStm()
+	assert.Error(t, err)
+}
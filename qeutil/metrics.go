@@ -0,0 +1,149 @@
+package qeutil
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are the upper bounds in seconds of the duration histogram of Metrics, like the default buckets
+// of Prometheus.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Metrics is a Hook counting the statements by operation and table in process, which are exposed in the
+// Prometheus text format by WriteTo and ServeHTTP. Failures of the cache are counted as the errors of OpCacheGet
+// and OpCacheSet. The zero value is ready to use.
+type Metrics struct {
+	Buckets []float64 // Buckets are the upper bounds of the duration histogram, DefaultBuckets if not given.
+
+	mu     sync.Mutex
+	series map[metricLabels]*MetricSeries
+}
+
+// metricLabels are the labels of a MetricSeries.
+type metricLabels struct {
+	op    string
+	table string
+}
+
+// MetricSeries are the metrics of the statements of an operation on a table.
+type MetricSeries struct {
+	Op       string
+	Table    string
+	Count    int64
+	Errors   int64
+	Rows     int64   // Rows is the total of the rows affected or read by the statements.
+	Seconds  float64 // Seconds is the total duration of the statements.
+	Buckets  []int64 // Buckets are the cumulative numbers of statements within each upper bound of Metrics.Buckets.
+	bucketLe []float64
+}
+
+// buckets returns the upper bounds of the duration histogram.
+func (m *Metrics) buckets() []float64 {
+	if len(m.Buckets) == 0 {
+		return DefaultBuckets
+	}
+	return m.Buckets
+}
+
+// BeforeQuery implements Hook.
+func (m *Metrics) BeforeQuery(ctx context.Context, e *QueryEvent) context.Context {
+	return ctx
+}
+
+// AfterQuery implements Hook.
+func (m *Metrics) AfterQuery(ctx context.Context, e *QueryEvent) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.series == nil {
+		m.series = map[metricLabels]*MetricSeries{}
+	}
+	labels := metricLabels{e.Op, e.Table}
+	s, ok := m.series[labels]
+	if !ok {
+		le := m.buckets()
+		s = &MetricSeries{Op: e.Op, Table: e.Table, Buckets: make([]int64, len(le)), bucketLe: le}
+		m.series[labels] = s
+	}
+	s.Count++
+	if e.Err != nil {
+		s.Errors++
+	}
+	if e.Rows > 0 {
+		s.Rows += e.Rows
+	}
+	seconds := e.Duration.Seconds()
+	s.Seconds += seconds
+	for i, le := range s.bucketLe {
+		if seconds <= le {
+			s.Buckets[i]++
+		}
+	}
+}
+
+// Snapshot returns a copy of the metrics sorted by operation and table.
+func (m *Metrics) Snapshot() []MetricSeries {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	series := make([]MetricSeries, 0, len(m.series))
+	for _, s := range m.series {
+		c := *s
+		c.Buckets = append([]int64(nil), s.Buckets...)
+		series = append(series, c)
+	}
+	sort.Slice(series, func(i, j int) bool {
+		if series[i].Op != series[j].Op {
+			return series[i].Op < series[j].Op
+		}
+		return series[i].Table < series[j].Table
+	})
+	return series
+}
+
+// labelEscaper escapes the label values of the Prometheus text format.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// WriteTo writes the metrics in the Prometheus text format, which are `qeutil_queries_total`,
+// `qeutil_query_errors_total`, `qeutil_query_rows_total` and the histogram `qeutil_query_duration_seconds`,
+// labelled by `op` and `table`.
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	series := m.Snapshot()
+	buf := strings.Builder{}
+	labels := func(s *MetricSeries) string {
+		return `op="` + labelEscaper.Replace(s.Op) + `",table="` + labelEscaper.Replace(s.Table) + `"`
+	}
+	counter := func(name, help string, value func(s *MetricSeries) int64) {
+		fmt.Fprintf(&buf, "# HELP %v %v\n# TYPE %v counter\n", name, help, name)
+		for i := range series {
+			fmt.Fprintf(&buf, "%v{%v} %d\n", name, labels(&series[i]), value(&series[i]))
+		}
+	}
+	counter("qeutil_queries_total", "Statements executed by qeutil.", func(s *MetricSeries) int64 { return s.Count })
+	counter("qeutil_query_errors_total", "Statements of qeutil failed by the DB, or failed reads and writes of the cache.", func(s *MetricSeries) int64 { return s.Errors })
+	counter("qeutil_query_rows_total", "Rows affected or read by the statements of qeutil.", func(s *MetricSeries) int64 { return s.Rows })
+
+	const name = "qeutil_query_duration_seconds"
+	fmt.Fprintf(&buf, "# HELP %v Duration of the statements of qeutil.\n# TYPE %v histogram\n", name, name)
+	for i := range series {
+		s := &series[i]
+		for j, le := range s.bucketLe {
+			fmt.Fprintf(&buf, "%v_bucket{%v,le=\"%v\"} %d\n", name, labels(s), strconv.FormatFloat(le, 'g', -1, 64), s.Buckets[j])
+		}
+		fmt.Fprintf(&buf, "%v_bucket{%v,le=\"+Inf\"} %d\n", name, labels(s), s.Count)
+		fmt.Fprintf(&buf, "%v_sum{%v} %v\n", name, labels(s), strconv.FormatFloat(s.Seconds, 'g', -1, 64))
+		fmt.Fprintf(&buf, "%v_count{%v} %d\n", name, labels(s), s.Count)
+	}
+	n, err := io.WriteString(w, buf.String())
+	return int64(n), err
+}
+
+// ServeHTTP implements http.Handler, which writes the metrics in the Prometheus text format.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	m.WriteTo(w)
+}
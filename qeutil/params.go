@@ -0,0 +1,382 @@
+package qeutil
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Reserved parameters of a list query.
+const (
+	// SortParam is the comma separated sort fields, e.g. `sort=-created_at,id`.
+	SortParam string = "sort"
+	// LimitParam is the page size.
+	LimitParam string = "limit"
+	// OffsetParam is the number of rows to skip.
+	OffsetParam string = "offset"
+	// AfterParam is the cursor of the page to read, see SelectClause.NextCursor.
+	AfterParam string = "after"
+)
+
+// ParamOperators maps the operator names in parameters, e.g. `score[gt]=50`, to the where clause operators.
+// A parameter without operator name is Eq.
+var ParamOperators = map[string]string{
+	"eq":      Eq,
+	"ne":      NotEq,
+	"gt":      Gt,
+	"gte":     GtEq,
+	"lt":      Lt,
+	"lte":     LtEq,
+	"like":    Like,
+	"nlike":   NotLike,
+	"in":      In,
+	"nin":     NotIn,
+	"between": Between,
+	"null":    IsNull,
+	"notnull": IsNotNull,
+}
+
+var (
+	// paramRegexp matches a filter parameter, e.g. `score` or `score[gt]`.
+	paramRegexp = regexp.MustCompile(`^([^\[\]]+)(?:\[([a-z]+)\])?$`)
+
+	// errIgnored is returned by parseFilter for the ignored parameters.
+	errIgnored = errors.New("ignored parameter")
+)
+
+// ParamErrors contains the message of each invalid parameter.
+type ParamErrors map[string]string
+
+// Error implements error.
+func (pe ParamErrors) Error() string {
+	keys := make([]string, 0, len(pe))
+	for k := range pe {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	msgs := make([]string, len(keys))
+	for i, k := range keys {
+		msgs[i] = k + ": " + pe[k]
+	}
+	return "invalid parameters: " + strings.Join(msgs, "; ")
+}
+
+// Fields returns the messages as the fields of a response, e.g. `httputil.InvaildParams.ToGinJSON(err.Error(), pe.Fields())`.
+func (pe ParamErrors) Fields() map[string]interface{} {
+	fields := make(map[string]interface{}, len(pe))
+	for k, v := range pe {
+		fields[k] = v
+	}
+	return fields
+}
+
+// ParamSpec defines the parameters accepted by a list endpoint and the SelectClause they are parsed into.
+type ParamSpec struct {
+	Select       []string
+	From         string
+	Where        []Wh                // Where are the fixed conditions, e.g. the owner of the rows.
+	Fields       map[string][]string // Fields maps the filter fields to their allowed operators.
+	Sort         []string            // Sort are the fields allowed to sort by.
+	Columns      map[string]string   // Columns maps the fields to their columns if the names are different.
+	DefaultSort  []Order
+	DefaultLimit int      // DefaultLimit is the limit if none is given, MaxLimit if not set.
+	MaxLimit     int      // MaxLimit is the largest limit a client may give.
+	Ignore       []string // Ignore are the other parameters of the endpoint, which are not reported as unknown.
+}
+
+// column returns the column of a field.
+func (ps *ParamSpec) column(field string) string {
+	if col, ok := ps.Columns[field]; ok {
+		return col
+	}
+	return field
+}
+
+// allowed reports whether the operator is allowed for the field.
+func (ps *ParamSpec) allowed(field, op string) bool {
+	for _, o := range ps.Fields[field] {
+		if o == op {
+			return true
+		}
+	}
+	return false
+}
+
+// ignored reports whether the parameter is ignored.
+func (ps *ParamSpec) ignored(key string) bool {
+	for _, k := range ps.Ignore {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+// Parse parses the query string of a request, e.g. `status=open&score[gt]=50&sort=-created_at&limit=20`,
+// into a SelectClause. Values of In, NotIn and Between are comma separated, and repeated Eq parameters are
+// parsed as In, which cannot be given as well, other parameters must not be repeated. ParamErrors is returned if
+// any parameter is invalid.
+func (ps *ParamSpec) Parse(values url.Values) (*SelectClause, error) {
+	params := make(map[string]interface{}, len(values))
+	errs := ParamErrors{}
+	for key, vals := range values {
+		if _, ok := ps.Fields[key]; ok && len(vals) > 1 {
+			if _, ok := values[key+"[in]"]; ok {
+				errs[key] = "cannot be repeated with " + key + "[in]"
+				continue
+			}
+			params[key+"[in]"] = vals
+			continue
+		}
+		if len(vals) > 1 && !ps.ignored(key) {
+			errs[key] = "must be given once"
+			continue
+		}
+		params[key] = vals[0]
+	}
+	return ps.parse(params, errs, true)
+}
+
+// ParseJSON parses a JSON filter body, e.g. `{"status": "open", "score": {"gt": 50}, "sort": ["-created_at"],
+// "limit": 20}`, into a SelectClause. An array value of a field is parsed as In, a parameter must not be given
+// in several forms, e.g. both `"score": [1, 2]` and `"score": {"in": ...}`.
+// ParamErrors is returned if any parameter is invalid.
+func (ps *ParamSpec) ParseJSON(body []byte) (*SelectClause, error) {
+	var obj map[string]interface{}
+	if err := json.Unmarshal(body, &obj); err != nil {
+		return nil, ParamErrors{"body": err.Error()}
+	}
+
+	params := make(map[string]interface{}, len(obj))
+	errs := ParamErrors{}
+	set := func(key string, val interface{}) {
+		if _, ok := params[key]; ok {
+			errs[key] = "must be given once"
+			delete(params, key)
+			return
+		}
+		if _, ok := errs[key]; !ok {
+			params[key] = val
+		}
+	}
+	for key, val := range obj {
+		switch v := val.(type) {
+		case map[string]interface{}:
+			if key == SortParam || key == LimitParam || key == OffsetParam || key == AfterParam {
+				set(key, v)
+				continue
+			}
+			for op, opVal := range v {
+				set(key+"["+op+"]", opVal)
+			}
+		case []interface{}:
+			if key == SortParam {
+				set(key, v)
+			} else {
+				set(key+"[in]", v)
+			}
+		default:
+			set(key, v)
+		}
+	}
+	return ps.parse(params, errs, false)
+}
+
+// parse builds the SelectClause of the parameters, whose values are strings if they come from a query string,
+// adding the errors to errs.
+func (ps *ParamSpec) parse(params map[string]interface{}, errs ParamErrors, query bool) (*SelectClause, error) {
+	sc := SelectClause{Select: ps.Select, From: ps.From, Where: append([]Wh(nil), ps.Where...), OrderBy: ps.DefaultSort}
+
+	// Sorted keys keep the where clauses, and thus the statement and cache key, stable
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		val := params[key]
+		switch key {
+		case SortParam:
+			orders, err := ps.parseSort(val)
+			if err != nil {
+				errs[key] = err.Error()
+				continue
+			}
+			sc.OrderBy = orders
+		case LimitParam, OffsetParam:
+			n, err := paramInt(val)
+			if err != nil {
+				errs[key] = err.Error()
+				continue
+			}
+			if key == OffsetParam {
+				sc.Offset = &n
+			} else {
+				sc.Limit = &n
+			}
+		case AfterParam:
+			s, ok := val.(string)
+			if !ok {
+				errs[key] = "must be a string"
+				continue
+			}
+			sc.After = s
+		default:
+			wh, err := ps.parseFilter(key, val, query)
+			if err != nil {
+				if err != errIgnored {
+					errs[key] = err.Error()
+				}
+				continue
+			}
+			sc.Where = append(sc.Where, wh)
+		}
+	}
+
+	if sc.Limit != nil && ps.MaxLimit > 0 && *sc.Limit > ps.MaxLimit {
+		errs[LimitParam] = fmt.Sprintf("must be between 0 and %d", ps.MaxLimit)
+	}
+	if _, ok := errs[LimitParam]; !ok && sc.Limit == nil {
+		if limit := ps.DefaultLimit; limit > 0 {
+			sc.Limit = &limit
+		} else if limit := ps.MaxLimit; limit > 0 {
+			sc.Limit = &limit
+		}
+	}
+	if sc.After != "" && sc.Offset != nil {
+		errs[AfterParam] = "cannot be used with offset"
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return &sc, nil
+}
+
+// parseFilter returns the where clause of a filter parameter.
+func (ps *ParamSpec) parseFilter(key string, val interface{}, query bool) (Wh, error) {
+	if ps.ignored(key) {
+		return Wh{}, errIgnored
+	}
+	m := paramRegexp.FindStringSubmatch(key)
+	if m == nil {
+		return Wh{}, errors.New("unknown parameter")
+	}
+	field, opName := m[1], m[2]
+	if _, ok := ps.Fields[field]; !ok {
+		return Wh{}, errors.New("unknown parameter")
+	}
+	if opName == "" {
+		opName = "eq"
+	}
+	op, ok := ParamOperators[opName]
+	if !ok || !ps.allowed(field, op) {
+		return Wh{}, fmt.Errorf("operator %q is not allowed", opName)
+	}
+
+	col := ps.column(field)
+	switch op {
+	case IsNull, IsNotNull:
+		return Wh{Operator: op, Values: map[string]interface{}{col: nil}}, nil
+	case In, NotIn, Between:
+		var list []interface{}
+		switch v := val.(type) {
+		case []interface{}:
+			list = v
+		case []string:
+			for _, s := range v {
+				list = append(list, s)
+			}
+		case string:
+			if query {
+				for _, s := range strings.Split(v, ",") {
+					list = append(list, s)
+				}
+			}
+		}
+		if len(list) == 0 {
+			return Wh{}, errors.New("must be a list")
+		}
+		if op == Between && len(list) != 2 {
+			return Wh{}, errors.New("must be a list of 2 values")
+		}
+		if op == In {
+			op = Eq
+		}
+		return Wh{Operator: op, Values: map[string]interface{}{col: list}}, nil
+	default:
+		switch val.(type) {
+		case map[string]interface{}, []interface{}:
+			return Wh{}, errors.New("must be a single value")
+		}
+		return Wh{Operator: op, Values: map[string]interface{}{col: val}}, nil
+	}
+}
+
+// parseSort returns the orders of the sort parameter.
+func (ps *ParamSpec) parseSort(val interface{}) ([]Order, error) {
+	var fields []string
+	switch v := val.(type) {
+	case string:
+		fields = strings.Split(v, ",")
+	case []string:
+		fields = v
+	case []interface{}:
+		for _, f := range v {
+			s, ok := f.(string)
+			if !ok {
+				return nil, errors.New("must be a list of fields")
+			}
+			fields = append(fields, s)
+		}
+	default:
+		return nil, errors.New("must be a list of fields")
+	}
+
+	orders := make([]Order, 0, len(fields))
+	for _, f := range fields {
+		order, err := ParseOrder(strings.TrimSpace(f))
+		if err != nil {
+			return nil, fmt.Errorf("cannot sort by %q", f)
+		}
+		allowed := false
+		for _, s := range ps.Sort {
+			allowed = allowed || s == order.Col
+		}
+		if !allowed {
+			return nil, fmt.Errorf("cannot sort by %q", order.Col)
+		}
+		order.Col = ps.column(order.Col)
+		orders = append(orders, order)
+	}
+	return orders, nil
+}
+
+// paramInt returns the non-negative integer of a parameter.
+func paramInt(val interface{}) (int, error) {
+	var n int
+	switch v := val.(type) {
+	case string:
+		i, err := strconv.Atoi(v)
+		if err != nil {
+			return 0, errors.New("must be an integer")
+		}
+		n = i
+	case float64:
+		if v != float64(int(v)) {
+			return 0, errors.New("must be an integer")
+		}
+		n = int(v)
+	default:
+		return 0, errors.New("must be an integer")
+	}
+	if n < 0 {
+		return 0, errors.New("must not be negative")
+	}
+	return n, nil
+}
@@ -0,0 +1,111 @@
+package qeutil
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testParamSpec() *ParamSpec {
+	return &ParamSpec{
+		From:  "answers",
+		Where: []Wh{Wh{Operator: Eq, Values: map[string]interface{}{"user_id": 7}}},
+		Fields: map[string][]string{
+			"status":  {Eq, In, NotIn},
+			"score":   {Gt, Lt, Between},
+			"created": {IsNull, GtEq},
+		},
+		Sort:         []string{"created", "id"},
+		Columns:      map[string]string{"created": "created_at"},
+		DefaultSort:  []Order{Asc("id")},
+		DefaultLimit: 20,
+		MaxLimit:     100,
+		Ignore:       []string{"lang"},
+	}
+}
+
+func TestParamSpecParse(t *testing.T) {
+	values, _ := url.ParseQuery("status=open&status=closed&score[gt]=50&created[null]=&sort=-created,id&limit=30&lang=en")
+	sc, err := testParamSpec().Parse(values)
+	assert.NoError(t, err)
+	stm, val, err := sc.SQLStm()
+	assert.NoError(t, err)
+	assert.Equal(t, "SELECT * FROM `answers` WHERE `user_id` = ? AND `created_at` IS NULL AND `score` > ? AND `status` IN (?,?) ORDER BY `created_at` DESC, `id` LIMIT 30", stm)
+	assert.Equal(t, []interface{}{7, "50", "open", "closed"}, val)
+
+	values, _ = url.ParseQuery("score[between]=10,20&offset=40")
+	sc, err = testParamSpec().Parse(values)
+	assert.NoError(t, err)
+	stm, val, err = sc.SQLStm()
+	assert.NoError(t, err)
+	assert.Equal(t, "SELECT * FROM `answers` WHERE `user_id` = ? AND `score` BETWEEN ? AND ? ORDER BY `id` LIMIT 20 OFFSET 40", stm)
+	assert.Equal(t, []interface{}{7, "10", "20"}, val)
+}
+
+func TestParamSpecParseInvalid(t *testing.T) {
+	values, _ := url.ParseQuery("status[gt]=1&password=x&score[between]=1&sort=password&limit=1000&offset=-1")
+	_, err := testParamSpec().Parse(values)
+	assert.Equal(t, ParamErrors{
+		"status[gt]":     `operator "gt" is not allowed`,
+		"password":       "unknown parameter",
+		"score[between]": "must be a list of 2 values",
+		"sort":           `cannot sort by "password"`,
+		"limit":          "must be between 0 and 100",
+		"offset":         "must not be negative",
+	}, err)
+	assert.Equal(t, "must be between 0 and 100", err.(ParamErrors).Fields()["limit"])
+
+	values, _ = url.ParseQuery("sort=id&sort=-created&limit=10&limit=1000&score[gt]=1&score[gt]=2&lang=en&lang=fr")
+	_, err = testParamSpec().Parse(values)
+	assert.Equal(t, ParamErrors{
+		"sort":      "must be given once",
+		"limit":     "must be given once",
+		"score[gt]": "must be given once",
+	}, err)
+
+	// Repeated Eq parameters are an In, which cannot be merged with another one
+	values, _ = url.ParseQuery("status=open&status=closed&status[in]=void")
+	_, err = testParamSpec().Parse(values)
+	assert.Equal(t, ParamErrors{"status": "cannot be repeated with status[in]"}, err)
+}
+
+func TestParamSpecParseLimit(t *testing.T) {
+	ps := testParamSpec()
+	ps.DefaultLimit = 0
+	sc, err := ps.Parse(url.Values{})
+	assert.NoError(t, err)
+	assert.Equal(t, 100, *sc.Limit)
+
+	// Only the limit given by the client is validated
+	ps.DefaultLimit, ps.MaxLimit = 200, 100
+	sc, err = ps.Parse(url.Values{})
+	assert.NoError(t, err)
+	assert.Equal(t, 200, *sc.Limit)
+
+	ps.DefaultLimit, ps.MaxLimit = 0, 0
+	sc, err = ps.Parse(url.Values{"limit": {"1000"}})
+	assert.NoError(t, err)
+	assert.Equal(t, 1000, *sc.Limit)
+	sc, err = ps.Parse(url.Values{})
+	assert.NoError(t, err)
+	assert.Nil(t, sc.Limit)
+}
+
+func TestParamSpecParseJSON(t *testing.T) {
+	sc, err := testParamSpec().ParseJSON([]byte(`{"status": ["open", "closed"], "score": {"gt": 50, "lt": 80}, "sort": ["-id"], "limit": 10}`))
+	assert.NoError(t, err)
+	stm, val, err := sc.SQLStm()
+	assert.NoError(t, err)
+	assert.Equal(t, "SELECT * FROM `answers` WHERE `user_id` = ? AND `score` > ? AND `score` < ? AND `status` IN (?,?) ORDER BY `id` DESC LIMIT 10", stm)
+	assert.Equal(t, []interface{}{7, float64(50), float64(80), "open", "closed"}, val)
+
+	_, err = testParamSpec().ParseJSON([]byte(`{"status": {"eq": [1]}, "limit": 1.5}`))
+	assert.Equal(t, ParamErrors{"status[eq]": "must be a single value", "limit": "must be an integer"}, err)
+
+	_, err = testParamSpec().ParseJSON([]byte(`{"status": ["open"], "status[in]": "void"}`))
+	assert.Equal(t, ParamErrors{"status[in]": "must be given once"}, err)
+
+	_, err = testParamSpec().ParseJSON([]byte(`[`))
+	assert.IsType(t, ParamErrors{}, err)
+}
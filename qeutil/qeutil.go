@@ -1,9 +1,13 @@
 package qeutil
 
 import (
-	"bytes"
+	"context"
+	"database/sql/driver"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"reflect"
+	"sort"
 	"strings"
 
 	sq "github.com/Masterminds/squirrel"
@@ -11,11 +15,17 @@ import (
 )
 
 var (
+	// ErrUnknownOperator is returned when a where clause uses an operator which is not supported.
+	ErrUnknownOperator = errors.New("unknown where clause operator")
+
 	// ErrNotExist is returned when the required resources not exist in DB.
 	ErrNotExist = errors.New("required resources not exist in DB")
 
 	// ErrNotChanged is returned when the sql is accpeted but no row is affected..
 	ErrNotChanged = errors.New("no row affected")
+
+	// ErrFullTable is returned when an update or delete has no conditions restricting its rows, see AllowFullTable.
+	ErrFullTable = errors.New("update or delete of the full table")
 )
 
 // // WhereSQL returns the SQL statement of where clause.
@@ -76,79 +86,57 @@ var (
 // 	return nil
 // }
 
+// execAffected executes the statement of the operation on the table and returns the number of affected rows,
+// ErrNotChanged is returned when no row is affected.
+func execAffected(ctx context.Context, db sqlx.ExtContext, op, table, stm string, val []interface{}) (int64, error) {
+	var affected int64
+	err := traceQuery(ctx, op, table, stm, val, func(ctx context.Context) (int64, error) {
+		res, err := db.ExecContext(ctx, stm, val...)
+		if err != nil {
+			return -1, err
+		}
+		affected, err = res.RowsAffected()
+		return affected, err
+	})
+	if err != nil {
+		return 0, err
+	}
+	if affected == 0 {
+		return 0, ErrNotChanged
+	}
+	return affected, nil
+}
+
 // ExistInDB check if the required resources exists in DB.
+//
+// Deprecated: use ExistsInDB, which accepts a context, a transaction and the cache.
 func ExistInDB(db *sqlx.DB, target string, wheres []Wh) (bool, error) {
-	builder := sq.Select("*").From(target)
-	for i := range wheres {
-		builder = builder.Where(wheres[i].ToWhBuilder())
+	err := ExistsInDB(context.Background(), db, nil, target, wheres)
+	if err == ErrNotExist {
+		return false, nil
 	}
-	stm, val, err := builder.ToSql()
-	if err != nil {
-		return false, err
-	}
-
-	buf := bytes.Buffer{}
-	buf.WriteString("SELECT EXISTS (")
-	buf.WriteString(stm)
-	buf.WriteString(")")
-	var exist bool
-	if err := db.Get(&exist, buf.String(), val...); err != nil {
-		return exist, err
-	}
-	return exist, nil
-}
-
-// // Code for research in future
-// func (qc *QueryClause) QueryWithCache(obj interface{}, db *sqlx.DB, redis *rediscli.Client, cacheExpiry time.Duration) (interface{}, error) {
-// 	result := reflect.MakeSlice(reflect.SliceOf(reflect.TypeOf(obj)), 0, 0).Interface()
-// 	fmt.Println("arrtype:", reflect.TypeOf(result))
-// 	fmt.Println("objtype:", reflect.TypeOf(obj))
-
-// 	// Try to get record from redis
-// 	if err := redis.Get(qc.CacheKey(), &result); err != nil {
-// 		// Missed for whatever reason, try to get from DB
-// 		if rows, err := db.Queryx(qc.SQLStm()); err == nil {
-// 			// Returned query result, scan struct
-// 			inf := []interface{}{}
-// 			defer rows.Close()
-// 			for rows.Next() {
-// 				item := obj
-// 				fmt.Println("itemtype:", reflect.TypeOf(item))
-// 				// item.Elem().Set(reflect.ValueOf(obj))
-// 				if err := rows.StructScan(item); err != nil {
-// 					fmt.Println(err)
-// 					return nil, ErrNotFoundInDB
-// 				}
-// 				fmt.Println("item:", item, "type:", reflect.TypeOf(item))
-// 				fmt.Println("Indirect:", reflect.ValueOf(item).Elem())
-// 				inf = append(inf, item)
-// 				result = reflect.Append(reflect.ValueOf(result), reflect.ValueOf(reflect.ValueOf(item).Pointer()))
-// 			}
-// 			fmt.Println("inf:", inf)
-// 		} else {
-// 			return nil, ErrDBWentWrong
-// 		}
+	return err == nil, err
+}
 
-// 		if err == cache.ErrCacheMiss {
-// 			// Try to write result to cache
-// 			// fmt.Println("result:", reflect.TypeOf(reflect.Indirect(result)))
-// 			// if err := redis.Set(qc.CacheKey(), result, cacheExpiry); err != nil {
-// 			// 	return nil, err
-// 			// }
-// 		} else {
-// 			// Log unknown Redis errors
-// 			return nil, err
-// 		}
-// 	}
-// 	return result, nil
-// }
+// Col refers to a column instead of a value in where clause values,
+// e.g. `Wh{Operator: Eq, Values: map[string]interface{}{"u.id": Col("t.user_id")}}` renders `u.id = t.user_id`.
+type Col string
 
-// Wh contains the operator and values of a MySQL where clause.
+// Wh contains the operator and values of a MySQL where clause, the keys of Values are columns which are
+// validated and quoted. The group operators (And, Or, Not) hold their conditions as a Group, and Exists and
+// NotExists hold their subquery, under the empty column of Values, see AndWh and ExistsWh.
 type Wh struct {
 	Operator string
 	Values   map[string]interface{}
 }
 
+// Group is the conditions of a group operator in the Values of a Wh,
+// e.g. `Wh{Or, map[string]interface{}{"": Group{...}}}`.
+type Group []Wh
+
+// groupKey is the column of Values holding the Group of a group operator or the subquery of Exists and NotExists.
+const groupKey = ""
+
 const (
 	// Eq representing the equal operator in MySQL
 	Eq string = "="
@@ -166,44 +154,538 @@ const (
 	Like string = "like"
 	// In representing the in operator in MySQL
 	In string = "in"
+	// NotIn representing the not in operator in MySQL
+	NotIn string = "not in"
+	// Between representing the between operator in MySQL, the value must be a slice of 2 elements
+	Between string = "between"
+	// NotBetween representing the not between operator in MySQL, the value must be a slice of 2 elements
+	NotBetween string = "not between"
+	// IsNull representing the is null operator in MySQL, the value is ignored
+	IsNull string = "is null"
+	// IsNotNull representing the is not null operator in MySQL, the value is ignored
+	IsNotNull string = "is not null"
+	// NotLike representing the not like operator in MySQL
+	NotLike string = "not like"
+	// Regexp representing the regexp operator in MySQL
+	Regexp string = "regexp"
+	// JSONContains representing the JSON_CONTAINS function in MySQL, non-string values are encoded to JSON
+	JSONContains string = "json_contains"
+	// And representing a group of conditions joined by AND in MySQL
+	And string = "and"
+	// Or representing a group of conditions joined by OR in MySQL
+	Or string = "or"
+	// Not representing a group of conditions negated by NOT in MySQL
+	Not string = "not"
+	// Exists representing the EXISTS operator of a subquery in MySQL
+	Exists string = "exists"
+	// NotExists representing the NOT EXISTS operator of a subquery in MySQL
+	NotExists string = "not exists"
 )
 
-// ToStr returns a string format of where clause.
+// AndWh returns a where clause which is true when all of the given conditions are true.
+func AndWh(whs ...Wh) Wh {
+	return Wh{Operator: And, Values: map[string]interface{}{groupKey: Group(whs)}}
+}
+
+// OrWh returns a where clause which is true when any of the given conditions is true.
+func OrWh(whs ...Wh) Wh {
+	return Wh{Operator: Or, Values: map[string]interface{}{groupKey: Group(whs)}}
+}
+
+// NotWh returns a where clause which is true when the given conditions are not all true.
+func NotWh(whs ...Wh) Wh {
+	return Wh{Operator: Not, Values: map[string]interface{}{groupKey: Group(whs)}}
+}
+
+// ExistsWh returns a where clause which is true when the subquery returns any row.
+func ExistsWh(sc *SelectClause) Wh {
+	return Wh{Operator: Exists, Values: map[string]interface{}{groupKey: sc}}
+}
+
+// NotExistsWh returns a where clause which is true when the subquery returns no row.
+func NotExistsWh(sc *SelectClause) Wh {
+	return Wh{Operator: NotExists, Values: map[string]interface{}{groupKey: sc}}
+}
+
+// isGroup reports whether the where clause is a group of nested conditions.
+func (wh *Wh) isGroup() bool {
+	return wh.Operator == And || wh.Operator == Or || wh.Operator == Not
+}
+
+// group returns the conditions of a group operator.
+func (wh *Wh) group() []Wh {
+	group, _ := wh.Values[groupKey].(Group)
+	return group
+}
+
+// query returns the subquery of Exists and NotExists.
+func (wh *Wh) query() *SelectClause {
+	sc, _ := wh.Values[groupKey].(*SelectClause)
+	return sc
+}
+
+// ToStr returns a string format of where clause, which is written into the cache keys.
+// A condition is written as `{col=val}`, `{col>val}` or `{col like val}` with its column and values escaped by
+// keyEscape, so that it never matches a part of another condition, and an In list is written as
+// `in({col=a}{col=b})`. Groups are written as `and(...)`, `or(...)` and `not(...)` with their conditions
+// separated by "&" or "|".
+// The format is canonical: the conditions of groups and the elements of sets are sorted without duplicates, so
+// that equivalent where clauses share their cache entries. Columns are lowercased while values keep their case
+// and type, see keyValue.
 func (wh *Wh) ToStr() string {
+	if wh.isGroup() {
+		op, sep := And, "&"
+		if wh.Operator == Or {
+			op, sep = Or, "|"
+		}
+		var conds []string
+		for _, w := range wh.group() {
+			conds = append(conds, w.conds(op)...)
+		}
+		conds = sortedSet(conds)
+		if len(conds) == 1 && wh.Operator != Not {
+			return conds[0]
+		}
+		return wh.Operator + "(" + strings.Join(conds, sep) + ")"
+	}
+
+	if wh.Operator == Exists || wh.Operator == NotExists {
+		return fmt.Sprintf("%v(%v)", keyEscape(wh.Operator), keyEscape(subCacheKey(wh.query())))
+	}
+
+	if len(wh.Values) > 1 {
+		return strings.Join(wh.conds(And), "&")
+	}
+	return wh.condStr()
+}
+
+// condStr returns the string format of a single column condition.
+func (wh *Wh) condStr() string {
 	var key string
 	var val interface{}
 	for k, v := range wh.Values {
-		key = k
+		key = keyIdent(k)
 		val = v
 	}
 	switch wh.Operator {
-	case "In":
-		return strings.ReplaceAll(fmt.Sprintf("%v=%v", key, val), " ", ",")
+	case Eq, In:
+		if _, ok := val.(*SelectClause); ok && wh.Operator == In {
+			return "{" + key + " in " + keyValue(val) + "}"
+		}
+		if list, ok := keyList(val); ok {
+			conds := make([]string, len(list))
+			for i, v := range list {
+				conds[i] = "{" + key + "=" + keyValue(v) + "}"
+			}
+			conds = sortedSet(conds)
+			if len(conds) == 1 {
+				return conds[0]
+			}
+			return "in(" + strings.Join(conds, "") + ")"
+		}
+		return "{" + key + "=" + keyValue(val) + "}"
+	case Gt, Lt, GtEq, LtEq, NotEq:
+		return "{" + key + wh.Operator + keyValue(val) + "}"
+	case IsNull, IsNotNull:
+		return "{" + key + " " + wh.Operator + "}"
+	case NotIn:
+		return "{" + key + " " + wh.Operator + " " + keyValueSet(val) + "}"
+	case Like, NotLike, Between, NotBetween, Regexp, JSONContains:
+		return "{" + key + " " + wh.Operator + " " + keyValues(val) + "}"
 	default:
-		return fmt.Sprintf("%v%v%v", key, wh.Operator, val)
+		return "{" + key + " " + keyEscape(wh.Operator) + " " + keyValues(val) + "}"
+	}
+}
+
+// conds returns the string formats of the conditions of the where clause in a group of the given operator.
+// Nested groups of the same operator are flattened, and the columns of a where clause are split in an And group.
+func (wh *Wh) conds(op string) []string {
+	switch {
+	case wh.Operator == op && (op == And || op == Or):
+		var conds []string
+		for _, w := range wh.group() {
+			conds = append(conds, w.conds(op)...)
+		}
+		return conds
+	case !wh.isGroup() && len(wh.Values) > 1:
+		whs := wh.split()
+		conds := make([]string, len(whs))
+		for i := range whs {
+			conds[i] = whs[i].ToStr()
+		}
+		conds = sortedSet(conds)
+		if op == And {
+			return conds
+		}
+		return []string{And + "(" + strings.Join(conds, "&") + ")"}
+	}
+	return []string{wh.ToStr()}
+}
+
+// whsKey returns the string format of where clauses joined by AND, sorted without duplicates.
+func whsKey(whs []Wh) string {
+	var conds []string
+	for i := range whs {
+		conds = append(conds, whs[i].conds(And)...)
 	}
+	return strings.Join(sortedSet(conds), "&")
 }
 
-// ToWhBuilder transforms the where clause to a squirrel where pred.
+// split returns the where clauses of each column of the where clause, sorted by column.
+func (wh *Wh) split() []Wh {
+	keys := make([]string, 0, len(wh.Values))
+	for k := range wh.Values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	whs := make([]Wh, len(keys))
+	for i, key := range keys {
+		whs[i] = Wh{Operator: wh.Operator, Values: map[string]interface{}{key: wh.Values[key]}}
+	}
+	return whs
+}
+
+// ToWhBuilder transforms the where clause to a squirrel where pred of MySQL with quoted columns.
+// An unknown operator or an invalid column results in a pred which returns the error when it is built.
 func (wh *Wh) ToWhBuilder() interface{} {
+	return wh.toWhBuilder(newIdents(MySQL, nil))
+}
+
+// toWhBuilder transforms the where clause to a squirrel where pred of the statement identifiers.
+func (wh *Wh) toWhBuilder(q *idents) interface{} {
+	switch wh.Operator {
+	case And:
+		return sq.And(wh.groupSqlizers(q))
+	case Or:
+		return sq.Or(wh.groupSqlizers(q))
+	case Not:
+		return notSqlizer{sq.And(wh.groupSqlizers(q))}
+	case Exists:
+		return sq.Expr("EXISTS (?)", subquery{q, wh.query()})
+	case NotExists:
+		return sq.Expr("NOT EXISTS (?)", subquery{q, wh.query()})
+	}
+	if wh.hasSub() {
+		return wh.subSqlizer(q)
+	}
+	values, err := q.values(wh.Values)
+	if err != nil {
+		return errSqlizer{err}
+	}
+	if opr, ok := colOperators[wh.Operator]; ok && wh.hasCol() {
+		return colSqlizer{opr, values}
+	}
+
+	d := q.d.orDefault()
 	switch wh.Operator {
 	case In:
 		fallthrough
 	case Eq:
-		return wh.Values
+		return values
 	case Gt:
-		return sq.Gt(wh.Values)
+		return sq.Gt(values)
 	case Lt:
-		return sq.Lt(wh.Values)
+		return sq.Lt(values)
 	case GtEq:
-		return sq.GtOrEq(wh.Values)
+		return sq.GtOrEq(values)
 	case LtEq:
-		return sq.LtOrEq(wh.Values)
+		return sq.LtOrEq(values)
 	case NotEq:
-		return sq.NotEq(wh.Values)
-	case Like:
-		return sq.Like(wh.Values)
+		return sq.NotEq(values)
+	case NotIn:
+		if err := checkNotIn(values); err != nil {
+			return errSqlizer{err}
+		}
+		return sq.NotEq(values)
+	case Like, NotLike, Between, NotBetween, IsNull, IsNotNull, Regexp, JSONContains:
+		return opSqlizer{d, wh.Operator, values}
 	default:
-		return nil
+		return errSqlizer{fmt.Errorf("%w: %q", ErrUnknownOperator, wh.Operator)}
+	}
+}
+
+// checkNotIn returns an error if a NotIn value is null or has a null element, since `a NOT IN (NULL)` is never
+// true while squirrel would render `a IS NOT NULL` for a null value. An empty list is always true, like NOT IN of
+// the empty set.
+func checkNotIn(values map[string]interface{}) error {
+	for key, val := range values {
+		list, ok := keyList(val)
+		if !ok {
+			list = []interface{}{val}
+		}
+		for _, v := range list {
+			if v == nil {
+				return fmt.Errorf("%v operator cannot compare %v with null, use %v instead", NotIn, key, IsNotNull)
+			}
+		}
+	}
+	return nil
+}
+
+// groupSqlizers returns the squirrel preds of the conditions in a group.
+func (wh *Wh) groupSqlizers(q *idents) []sq.Sqlizer {
+	group := wh.group()
+	preds := make([]sq.Sqlizer, len(group))
+	for i := range group {
+		preds[i] = toSqlizer(group[i].toWhBuilder(q))
+	}
+	return preds
+}
+
+// hasSub reports whether any value of the where clause is a subquery.
+func (wh *Wh) hasSub() bool {
+	for _, v := range wh.Values {
+		if _, ok := v.(*SelectClause); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// subOperators maps the operators to their SQL form when comparing columns to subqueries.
+var subOperators = map[string]string{
+	In:    "IN",
+	NotIn: "NOT IN",
+	Eq:    "=",
+	Gt:    ">",
+	Lt:    "<",
+	GtEq:  ">=",
+	LtEq:  "<=",
+	NotEq: "<>",
+}
+
+// subSqlizer returns the pred of a where clause comparing columns to subqueries, e.g. `a IN (SELECT ...)`.
+func (wh *Wh) subSqlizer(q *idents) sq.Sqlizer {
+	opr, ok := subOperators[wh.Operator]
+	if !ok {
+		return errSqlizer{fmt.Errorf("%v operator does not accept subqueries", wh.Operator)}
+	}
+	and := sq.And{}
+	for _, w := range wh.split() {
+		for key, val := range w.Values {
+			sub, ok := val.(*SelectClause)
+			if !ok {
+				and = append(and, toSqlizer(w.toWhBuilder(q)))
+				continue
+			}
+			col, err := q.col(key)
+			if err != nil {
+				return errSqlizer{err}
+			}
+			and = append(and, sq.Expr(col+" "+opr+" (?)", subquery{q, sub}))
+		}
+	}
+	if len(and) == 1 {
+		return and[0]
+	}
+	return and
+}
+
+// subCacheKey returns the cache key of a subquery.
+func subCacheKey(sc *SelectClause) string {
+	if sc == nil {
+		return ""
+	}
+	return sc.CacheKey()
+}
+
+// hasCol reports whether any value of the where clause is a column reference.
+func (wh *Wh) hasCol() bool {
+	for _, v := range wh.Values {
+		if _, ok := v.(Col); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// toSqlizer converts a pred returned by ToWhBuilder to a squirrel Sqlizer.
+func toSqlizer(pred interface{}) sq.Sqlizer {
+	if m, ok := pred.(map[string]interface{}); ok {
+		return sq.Eq(m)
+	}
+	return pred.(sq.Sqlizer)
+}
+
+// whsSQLStm returns the given where clauses of the statement joined by AND.
+func whsSQLStm(q *idents, whs []Wh) (string, []interface{}, error) {
+	var args []interface{}
+	exprs := make([]string, 0, len(whs))
+	for i := range whs {
+		stm, val, err := toSqlizer(whs[i].toWhBuilder(q)).ToSql()
+		if err != nil {
+			return "", nil, err
+		}
+		if stm != "" {
+			exprs = append(exprs, stm)
+			args = append(args, val...)
+		}
+	}
+	return strings.Join(exprs, " AND "), args, nil
+}
+
+// colOperators maps the comparison operators to their SQL form when comparing columns.
+var colOperators = map[string]string{
+	Eq:    "=",
+	Gt:    ">",
+	Lt:    "<",
+	GtEq:  ">=",
+	LtEq:  "<=",
+	NotEq: "<>",
+}
+
+// colSqlizer builds comparisons whose values may refer to other columns.
+type colSqlizer struct {
+	opr    string
+	values map[string]interface{}
+}
+
+// ToSql implements sq.Sqlizer.
+func (c colSqlizer) ToSql() (string, []interface{}, error) {
+	keys := make([]string, 0, len(c.values))
+	for k := range c.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var args []interface{}
+	exprs := make([]string, len(keys))
+	for i, key := range keys {
+		if col, ok := c.values[key].(Col); ok {
+			exprs[i] = fmt.Sprintf("%v %v %v", key, c.opr, col)
+			continue
+		}
+		exprs[i] = fmt.Sprintf("%v %v ?", key, c.opr)
+		args = append(args, c.values[key])
+	}
+	return strings.Join(exprs, " AND "), args, nil
+}
+
+// opSqlizer builds the operators which are not provided by squirrel.
+type opSqlizer struct {
+	d      Dialect
+	op     string
+	values map[string]interface{}
+}
+
+// ToSql implements sq.Sqlizer.
+func (o opSqlizer) ToSql() (string, []interface{}, error) {
+	keys := make([]string, 0, len(o.values))
+	for k := range o.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var args []interface{}
+	exprs := make([]string, len(keys))
+	for i, key := range keys {
+		val := o.values[key]
+		switch o.op {
+		case IsNull:
+			exprs[i] = key + " IS NULL"
+		case IsNotNull:
+			exprs[i] = key + " IS NOT NULL"
+		case Like, NotLike:
+			if v, ok := val.(driver.Valuer); ok {
+				var err error
+				if val, err = v.Value(); err != nil {
+					return "", nil, err
+				}
+			}
+			if val == nil {
+				return "", nil, fmt.Errorf("%v operator cannot compare %v with null", o.op, key)
+			}
+			if _, ok := keyList(val); ok {
+				return "", nil, fmt.Errorf("%v operator cannot compare %v with a list", o.op, key)
+			}
+			opr := strings.ToUpper(o.op)
+			if o.d.orDefault() == PostgreSQL {
+				// LIKE of MySQL is case-insensitive
+				opr = strings.Replace(opr, "LIKE", "ILIKE", 1)
+			}
+			exprs[i] = key + " " + opr + " ?"
+			args = append(args, val)
+		case Between, NotBetween:
+			rv := reflect.ValueOf(val)
+			if (rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array) || rv.Len() != 2 {
+				return "", nil, fmt.Errorf("%v operator requires a slice of 2 elements for %v, got %v", o.op, key, val)
+			}
+			exprs[i] = fmt.Sprintf("%v %v ? AND ?", key, strings.ToUpper(o.op))
+			args = append(args, rv.Index(0).Interface(), rv.Index(1).Interface())
+		case Regexp:
+			if o.d.orDefault() == PostgreSQL {
+				exprs[i] = key + " ~ ?"
+			} else {
+				exprs[i] = key + " REGEXP ?"
+			}
+			args = append(args, val)
+		case JSONContains:
+			if o.d.orDefault() == SQLite {
+				return "", nil, fmt.Errorf("%v operator is not supported by %v", o.op, SQLite)
+			}
+			switch val.(type) {
+			case string, []byte:
+			default:
+				doc, err := json.Marshal(val)
+				if err != nil {
+					return "", nil, err
+				}
+				val = string(doc)
+			}
+			if o.d.orDefault() == PostgreSQL {
+				exprs[i] = key + " @> CAST(? AS jsonb)"
+			} else {
+				exprs[i] = fmt.Sprintf("JSON_CONTAINS(%v, ?)", key)
+			}
+			args = append(args, val)
+		}
+	}
+	return strings.Join(exprs, " AND "), args, nil
+}
+
+// errSqlizer fails the statement building with the given error.
+type errSqlizer struct {
+	err error
+}
+
+// ToSql implements sq.Sqlizer.
+func (e errSqlizer) ToSql() (string, []interface{}, error) {
+	return "", nil, e.err
+}
+
+// notSqlizer negates a parenthesised squirrel pred.
+type notSqlizer struct {
+	pred sq.Sqlizer
+}
+
+// ToSql implements sq.Sqlizer.
+func (n notSqlizer) ToSql() (string, []interface{}, error) {
+	stm, val, err := n.pred.ToSql()
+	if err != nil {
+		return "", nil, err
+	}
+	return "NOT " + stm, val, nil
+}
+
+// tableUnlinkPatterns returns the redis key patterns of all cache entries of the table, which are the patterns of
+// every write on the table. Patterns cannot unlink exactly the entries affected by a write: an entry without a
+// condition on a column of the write, e.g. an unfiltered list, may contain its rows while an entry whose condition
+// on the column conflicts with it cannot, and a glob cannot match a key by the absence of a condition whose
+// position in the key depends on the other conditions. Patterns never miss an affected entry at the cost of the
+// other entries of the table, which are kept by the tags of a TagCacher, see tagSkips.
+// Hashed keys are matched as well, see MaxCacheKeyLen.
+func tableUnlinkPatterns(table string) []string {
+	prefix := keyPrefix() + strings.ToLower(table)
+	return append([]string{prefix, prefix + ":*"}, joinUnlinkPatterns(table)...)
+}
+
+// joinUnlinkPatterns returns the redis key patterns of the joined SelectClause cache entries involving the table,
+// either as the main table (`table:join:...`) or as a joined table (`...:join:...&inner=table@alias(...)`).
+func joinUnlinkPatterns(table string) []string {
+	table = strings.ToLower(table)
+	return []string{
+		keyPrefix() + table + ":join:*",
+		keyPrefix() + "*:join:*=" + table + "[@(]*",
 	}
 }
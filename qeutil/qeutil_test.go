@@ -0,0 +1,141 @@
+package qeutil
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"sync"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// fakeResp is the response of a statement executed in fakeConnector.
+type fakeResp struct {
+	cols     []string
+	rows     [][]driver.Value
+	lastID   int64
+	idErr    error // idErr is returned by LastInsertId like the PostgreSQL drivers.
+	affected int64
+	err      error
+}
+
+// fakeConnector is an in-memory database/sql driver which records the executed statements and
+// answers them with the response returned by its handler.
+type fakeConnector struct {
+	mu       sync.Mutex
+	stms     []string
+	prepares int
+	handler  func(stm string, args []driver.Value) fakeResp
+	prepare  func(stm string) // prepare is invoked before a statement is prepared if given.
+}
+
+// newFakeDB returns a sqlx.DB connecting to a fakeConnector with the given handler.
+func newFakeDB(handler func(stm string, args []driver.Value) fakeResp) (*sqlx.DB, *fakeConnector) {
+	c := &fakeConnector{handler: handler}
+	return sqlx.NewDb(sql.OpenDB(c), "mysql"), c
+}
+
+func (c *fakeConnector) Connect(context.Context) (driver.Conn, error) { return &fakeConn{c}, nil }
+
+func (c *fakeConnector) Driver() driver.Driver { return fakeDriver{c} }
+
+func (c *fakeConnector) do(stm string, args []driver.Value) fakeResp {
+	c.mu.Lock()
+	c.stms = append(c.stms, stm)
+	c.mu.Unlock()
+	if c.handler == nil {
+		return fakeResp{}
+	}
+	return c.handler(stm, args)
+}
+
+func (c *fakeConnector) statements() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string{}, c.stms...)
+}
+
+type fakeDriver struct{ c *fakeConnector }
+
+func (d fakeDriver) Open(string) (driver.Conn, error) { return &fakeConn{d.c}, nil }
+
+type fakeConn struct{ c *fakeConnector }
+
+func (cn *fakeConn) Prepare(stm string) (driver.Stmt, error) {
+	if cn.c.prepare != nil {
+		cn.c.prepare(stm)
+	}
+	cn.c.mu.Lock()
+	cn.c.prepares++
+	cn.c.mu.Unlock()
+	return &fakeStmt{cn.c, stm}, nil
+}
+
+func (cn *fakeConn) Close() error { return nil }
+
+func (cn *fakeConn) Begin() (driver.Tx, error) {
+	if resp := cn.c.do("BEGIN", nil); resp.err != nil {
+		return nil, resp.err
+	}
+	return fakeTx{cn.c}, nil
+}
+
+type fakeTx struct{ c *fakeConnector }
+
+func (tx fakeTx) Commit() error { return tx.c.do("COMMIT", nil).err }
+
+func (tx fakeTx) Rollback() error { return tx.c.do("ROLLBACK", nil).err }
+
+type fakeStmt struct {
+	c   *fakeConnector
+	stm string
+}
+
+func (s *fakeStmt) Close() error { return nil }
+
+func (s *fakeStmt) NumInput() int { return -1 }
+
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	resp := s.c.do(s.stm, args)
+	if resp.err != nil {
+		return nil, resp.err
+	}
+	return fakeResult{resp}, nil
+}
+
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	resp := s.c.do(s.stm, args)
+	if resp.err != nil {
+		return nil, resp.err
+	}
+	return &fakeRows{resp: resp}, nil
+}
+
+type fakeResult struct{ resp fakeResp }
+
+func (r fakeResult) LastInsertId() (int64, error) { return r.resp.lastID, r.resp.idErr }
+
+func (r fakeResult) RowsAffected() (int64, error) { return r.resp.affected, nil }
+
+type fakeRows struct {
+	resp fakeResp
+	i    int
+}
+
+func (r *fakeRows) Columns() []string { return r.resp.cols }
+
+func (r *fakeRows) Close() error { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.i >= len(r.resp.rows) {
+		return io.EOF
+	}
+	copy(dest, r.resp.rows[r.i])
+	r.i++
+	return nil
+}
+
+func intPtr(i int) *int {
+	return &i
+}
@@ -2,70 +2,384 @@ package qeutil
 
 import (
 	"bytes"
+	"context"
+	"database/sql"
+	"errors"
 	"fmt"
+	"math"
+	"reflect"
+	"sort"
 	"strconv"
+	"strings"
 
 	sq "github.com/Masterminds/squirrel"
+	"github.com/jmoiron/sqlx"
 )
 
+// Join types of a Join.
+const (
+	// InnerJoin representing the inner join in MySQL
+	InnerJoin string = "inner"
+	// LeftJoin representing the left join in MySQL
+	LeftJoin string = "left"
+	// RightJoin representing the right join in MySQL
+	RightJoin string = "right"
+	// CrossJoin representing the cross join in MySQL, it does not accept ON conditions
+	CrossJoin string = "cross"
+)
+
+// Join contains a table joined to a SelectClause, use Col values in On to compare columns.
+type Join struct {
+	Type  string
+	Table string
+	As    string
+	On    []Wh
+}
+
+// SQLStm return the MySQL join statment of the Join.
+func (j *Join) SQLStm() (string, []interface{}, error) {
+	return j.sqlStm(newIdents(MySQL, nil))
+}
+
+// sqlStm return the join statment of the Join, the joined table is registered to the statement identifiers.
+func (j *Join) sqlStm(q *idents) (string, []interface{}, error) {
+	buf := bytes.Buffer{}
+	switch j.Type {
+	case InnerJoin, LeftJoin, RightJoin, CrossJoin:
+		buf.WriteString(strings.ToUpper(j.Type))
+		buf.WriteString(" JOIN ")
+	default:
+		return "", nil, fmt.Errorf("unknown join type %q", j.Type)
+	}
+	table, err := q.table(j.Table, j.As)
+	if err != nil {
+		return "", nil, err
+	}
+	buf.WriteString(table)
+
+	if len(j.On) == 0 {
+		return buf.String(), nil, nil
+	}
+	if j.Type == CrossJoin {
+		return "", nil, fmt.Errorf("cross join of %v does not accept on conditions", j.Table)
+	}
+	stm, val, err := whsSQLStm(q, j.On)
+	if err != nil {
+		return "", nil, err
+	}
+	buf.WriteString(" ON ")
+	buf.WriteString(stm)
+	return buf.String(), val, nil
+}
+
+// ToStr returns a string format of the join, e.g. `left=users@u(u.id=t.user_id)`.
+func (j *Join) ToStr() string {
+	buf := bytes.Buffer{}
+	buf.WriteString(j.Type)
+	buf.WriteString("=")
+	buf.WriteString(strings.ToLower(j.Table))
+	if j.As != "" {
+		buf.WriteString("@")
+		buf.WriteString(strings.ToLower(j.As))
+	}
+	buf.WriteString("(")
+	buf.WriteString(whsKey(j.On))
+	buf.WriteString(")")
+	return buf.String()
+}
+
 // SelectClause .
+// All identifiers are validated and quoted, Select accepts columns optionally followed by `AS alias`.
+// Having is a raw expression whose values must be passed in HavingArgs. It accepts comparisons, arithmetic, AND, OR,
+// NOT, IS NULL, BETWEEN, IN and LIKE of columns, numbers and the aggregate functions COUNT, SUM, AVG, MIN and MAX.
 type SelectClause struct {
-	Select  []string
-	From    string
-	Where   []Wh
-	GroupBy []string
-	Having  string
-	OrderBy []string
-	Limit   *int
-	Offset  *int
+	Select     []string
+	From       string
+	As         string
+	Joins      []Join
+	Where      []Wh
+	GroupBy    []string
+	Having     string
+	HavingArgs []interface{}
+	OrderBy    []Order
+	Limit      *int
+	Offset     *int
+	After      string  // After is the cursor of the page to read, returned by NextCursor of the previous page.
+	Dialect    Dialect // Dialect of the statement, MySQL if not given.
+	Schema     Schema  // Schema limits the tables and columns of the statement if given.
+	Subqueries []Subquery
+	Trashed    string // Trashed controls the rows of soft-deleted tables, see RegisterSoftDelete.
+	Lock       string // Lock locks the read rows, ForUpdate or ForShare, which cannot be cached.
+	LockWait   string // LockWait controls the rows locked by other transactions, NoWait or SkipLocked.
+	IndexHints []IndexHint
+}
+
+// Subquery is a SelectClause selected as a column of the outer SelectClause, e.g. `(SELECT COUNT(*) ...) AS n`.
+type Subquery struct {
+	Query *SelectClause
+	As    string
+}
+
+// subquery builds a SelectClause nested in an outer statement.
+type subquery struct {
+	q  *idents
+	sc *SelectClause
 }
 
-// SQLStm return a MySQL query statment from the SelectClause.
+// ToSql implements sq.Sqlizer.
+func (s subquery) ToSql() (string, []interface{}, error) {
+	if s.sc == nil {
+		return "", nil, errors.New("subquery is nil")
+	}
+	builder, err := s.sc.selectBuilder(s.q.sub(s.sc.Schema), nil)
+	if err != nil {
+		return "", nil, err
+	}
+	return builder.ToSql()
+}
+
+// SQLStm return a query statment of the Dialect from the SelectClause.
 func (sc *SelectClause) SQLStm() (string, []interface{}, error) {
+	if err := sc.Dialect.check(); err != nil {
+		return "", nil, err
+	}
+	builder, err := sc.selectBuilder(newIdents(sc.Dialect, sc.Schema), nil)
+	if err != nil {
+		return "", nil, err
+	}
+	return builder.PlaceholderFormat(sc.Dialect.placeholder()).ToSql()
+}
+
+// selectBuilder returns the squirrel builder of the SelectClause with `?` placeholders. Subqueries are built
+// in the dialect of the outer statement and can refer to its tables. The aggregate replaces the select list if given.
+func (sc *SelectClause) selectBuilder(q *idents, agg *AggregateClause) (sq.SelectBuilder, error) {
+	var builder sq.SelectBuilder
 	if len(sc.Select) == 0 {
 		sc.Select = []string{"*"}
 	}
-	builder := sq.Select(sc.Select...).From(sc.From)
+	from, err := q.table(sc.From, sc.As)
+	if err != nil {
+		return builder, err
+	}
+	hints, err := indexHints(q, sc.IndexHints)
+	if err != nil {
+		return builder, err
+	}
+	lock, err := lockClause(q.d, sc.Lock, sc.LockWait)
+	if err != nil {
+		return builder, err
+	}
+	builder = sq.Select().From(from + hints)
+
+	// The trashed rows of the main table are filtered after the conditions of the SelectClause
+	var trashed []string
+	switch sc.Trashed {
+	case WithoutTrashed, OnlyTrashed:
+		ref := ""
+		if len(sc.Joins) > 0 {
+			ref = sc.As
+			if ref == "" {
+				ref = sc.From
+			}
+		}
+		if expr := trashedExpr(q.d, sc.From, ref, sc.Trashed == OnlyTrashed); expr != "" {
+			trashed = append(trashed, expr)
+		}
+	case WithTrashed:
+	default:
+		return builder, fmt.Errorf("unknown trashed mode %q", sc.Trashed)
+	}
+
+	for i := range sc.Joins {
+		j := &sc.Joins[i]
+		stm, val, err := j.sqlStm(q)
+		if err != nil {
+			return builder, err
+		}
+		// The trashed rows of joined tables are filtered in ON, so that outer joins still return the main rows
+		if sc.Trashed != WithTrashed {
+			ref := j.As
+			if ref == "" {
+				ref = j.Table
+			}
+			if expr := trashedExpr(q.d, j.Table, ref, false); expr != "" {
+				switch {
+				case j.Type == CrossJoin:
+					trashed = append(trashed, expr)
+				case len(j.On) == 0:
+					stm += " ON " + expr
+				default:
+					stm += " AND " + expr
+				}
+			}
+		}
+		builder = builder.JoinClause(stm, val...)
+	}
+
+	// Columns are quoted after all tables are registered by the joins
+	if agg != nil {
+		col, err := agg.column(q.col)
+		if err != nil {
+			return builder, err
+		}
+		builder = builder.Column(col)
+	} else {
+		cols := make([]string, len(sc.Select))
+		for i := range sc.Select {
+			if cols[i], err = q.selectCol(sc.Select[i]); err != nil {
+				return builder, err
+			}
+		}
+		builder = builder.Columns(cols...)
+	}
+	for _, sub := range sc.Subqueries {
+		if err := q.check(sub.As); err != nil || strings.Contains(sub.As, ".") {
+			return builder, fmt.Errorf("%w: invalid alias %q", ErrUnsafeSQL, sub.As)
+		}
+		q.alias[sub.As] = true
+		builder = builder.Column(sq.Expr("(?) AS "+q.d.Quote(sub.As), subquery{q, sub.Query}))
+	}
 
 	for i := range sc.Where {
-		builder = builder.Where(sc.Where[i].ToWhBuilder())
+		builder = builder.Where(sc.Where[i].toWhBuilder(q))
+	}
+	if sc.After != "" {
+		pred, err := sc.afterSqlizer(q)
+		if err != nil {
+			return builder, err
+		}
+		builder = builder.Where(pred)
+	}
+	for _, expr := range trashed {
+		builder = builder.Where(expr)
 	}
 
-	builder = builder.GroupBy(sc.GroupBy...)
+	for i := range sc.GroupBy {
+		col, err := q.col(sc.GroupBy[i])
+		if err != nil {
+			return builder, err
+		}
+		builder = builder.GroupBy(col)
+	}
 	if sc.Having != "" {
-		builder = builder.Having(sc.Having)
+		having, err := q.expr(sc.Having, sc.HavingArgs)
+		if err != nil {
+			return builder, err
+		}
+		builder = builder.Having(having, sc.HavingArgs...)
+	}
+	for _, ord := range sc.OrderBy {
+		col, err := q.col(ord.Col)
+		if err != nil {
+			return builder, err
+		}
+		if ord.Desc {
+			col += " DESC"
+		}
+		builder = builder.OrderBy(col)
 	}
-	builder = builder.OrderBy(sc.OrderBy...)
 	if sc.Limit != nil {
 		builder = builder.Limit(uint64(*sc.Limit))
-		if sc.Offset != nil {
-			builder = builder.Offset(uint64(*sc.Offset))
+	}
+	if sc.Offset != nil {
+		if sc.Limit == nil {
+			// MySQL and SQLite do not accept OFFSET without LIMIT
+			switch q.d.orDefault() {
+			case MySQL:
+				builder = builder.Limit(math.MaxUint64)
+			case SQLite:
+				// Locking reads are refused by SQLite
+				return builder.Suffix("LIMIT -1 OFFSET " + strconv.Itoa(*sc.Offset)), nil
+			}
 		}
+		builder = builder.Offset(uint64(*sc.Offset))
 	}
-	return builder.ToSql()
+	if lock != "" {
+		builder = builder.Suffix(lock)
+	}
+	return builder, nil
 }
 
 // CacheKey return a cache key from the SelectClause.
 func (sc *SelectClause) CacheKey() string {
+	return sc.cacheKey("")
+}
+
+// cacheKey returns the cache key of the SelectClause, the escaped aggregate segment is written before the
+// conditions so that the key is still matched by the unlink patterns of the table.
+// Identifiers are lowercased while the values keep their case, see Wh.ToStr.
+func (sc *SelectClause) cacheKey(agg string) string {
 	buf := bytes.Buffer{}
 
 	// Main key
 	if sc.From == "" {
 		panic("Target table not given.")
 	} else {
-		buf.WriteString(sc.From)
+		buf.WriteString(keyPrefix())
+		buf.WriteString(strings.ToLower(sc.From))
 	}
 
-	if len(sc.Where) > 0 {
-		buf.WriteString(":where:")
-		whereBuf := bytes.Buffer{}
-		for i := range sc.Where {
-			if whereBuf.Len() > 0 {
-				whereBuf.WriteString("&")
+	// Joined tables and the tables read by subqueries are listed after the main key, see joinUnlinkPatterns
+	joins := make([]string, 0, len(sc.Joins))
+	for i := range sc.Joins {
+		joins = append(joins, sc.Joins[i].ToStr())
+	}
+	for _, table := range sc.subTables() {
+		joins = append(joins, "sub="+strings.ToLower(table)+"()")
+	}
+	if len(joins) > 0 {
+		buf.WriteString(":join:")
+		if sc.As != "" {
+			buf.WriteString("@")
+			buf.WriteString(strings.ToLower(sc.As))
+			buf.WriteString("&")
+		}
+		buf.WriteString(strings.Join(joins, "&"))
+	} else if sc.As != "" {
+		buf.WriteString(":as:")
+		buf.WriteString(strings.ToLower(sc.As))
+	}
+
+	// The select list is part of the key since the cached rows only have its columns
+	if len(sc.Select) > 0 && !(len(sc.Select) == 1 && sc.Select[0] == "*") {
+		buf.WriteString(":sel:")
+		for i := range sc.Select {
+			if i > 0 {
+				buf.WriteString("&")
+			}
+			buf.WriteString(keyIdent(sc.Select[i]))
+		}
+	}
+
+	if len(sc.Subqueries) > 0 {
+		buf.WriteString(":sub:")
+		for i, sub := range sc.Subqueries {
+			if i > 0 {
+				buf.WriteString("&")
 			}
-			whereBuf.WriteString(sc.Where[i].ToStr())
+			buf.WriteString(keyIdent(sub.As))
+			buf.WriteString("(")
+			buf.WriteString(keyEscape(subCacheKey(sub.Query)))
+			buf.WriteString(")")
 		}
-		buf.Write(whereBuf.Bytes())
+	}
+
+	if agg != "" {
+		buf.WriteString(":agg:")
+		buf.WriteString(agg)
+	}
+
+	if sc.Trashed != WithoutTrashed {
+		buf.WriteString(":trashed:")
+		buf.WriteString(keyIdent(sc.Trashed))
+	}
+
+	// The conditions and the following segments may be hashed, see MaxCacheKeyLen
+	head := buf.Len()
+
+	if len(sc.Where) > 0 {
+		buf.WriteString(":where:")
+		buf.WriteString(whsKey(sc.Where))
 	}
 
 	if len(sc.GroupBy) > 0 {
@@ -74,13 +388,17 @@ func (sc *SelectClause) CacheKey() string {
 			if i > 0 {
 				buf.WriteString("&")
 			}
-			buf.WriteString(sc.GroupBy[i])
+			buf.WriteString(keyIdent(sc.GroupBy[i]))
 		}
 	}
 
 	if sc.Having != "" {
 		buf.WriteString(":hav:")
-		buf.WriteString(sc.Having)
+		buf.WriteString(keyIdent(sc.Having))
+		for _, arg := range sc.HavingArgs {
+			buf.WriteString("&")
+			buf.WriteString(keyValue(arg))
+		}
 	}
 
 	if len(sc.OrderBy) > 0 {
@@ -89,10 +407,15 @@ func (sc *SelectClause) CacheKey() string {
 			if i > 0 {
 				buf.WriteString("&")
 			}
-			buf.WriteString(sc.OrderBy[i])
+			buf.WriteString(strings.ToLower(sc.OrderBy[i].String()))
 		}
 	}
 
+	if sc.After != "" {
+		buf.WriteString(":aft:")
+		buf.WriteString(sc.cursorKey())
+	}
+
 	if sc.Limit != nil {
 		buf.WriteString(":lim:")
 		buf.WriteString(strconv.Itoa(*sc.Limit))
@@ -102,14 +425,104 @@ func (sc *SelectClause) CacheKey() string {
 		}
 	}
 
-	return string(bytes.ToLower(buf.Bytes()))
+	if MaxCacheKeyLen > 0 && buf.Len() > MaxCacheKeyLen {
+		return string(buf.Bytes()[:head]) + keyHash(string(buf.Bytes()[head:]))
+	}
+	return buf.String()
 }
 
-// ToUnlinks return an array of wh's ToStr() function result which can be used to unlink keys in redis.
+// ToUnlinks return the patterns which can be used to unlink keys in redis, which are all cache entries of the table
+// like the writes on it, see tableUnlinkPatterns. The joined cache entries of every table in a joined SelectClause,
+// or read by its subqueries, are included.
 func (sc *SelectClause) ToUnlinks() []string {
-	whsStr := make([]string, len(sc.Where))
-	for i := range sc.Where {
-		whsStr[i] = fmt.Sprintf("%v:*[:&]%v", sc.From, sc.Where[i].ToStr())
+	unlinks := tableUnlinkPatterns(sc.From)
+	subTables := sc.subTables()
+	if len(sc.Joins) > 0 || len(subTables) > 0 {
+		for i := range sc.Joins {
+			unlinks = append(unlinks, joinUnlinkPatterns(sc.Joins[i].Table)...)
+		}
+		for _, table := range subTables {
+			unlinks = append(unlinks, joinUnlinkPatterns(table)...)
+		}
+	}
+	return unlinks
+}
+
+// subTables returns the sorted tables read by the subqueries of the SelectClause.
+func (sc *SelectClause) subTables() []string {
+	var subs []*SelectClause
+	var walk func(whs []Wh)
+	walk = func(whs []Wh) {
+		for i := range whs {
+			walk(whs[i].group())
+			for _, v := range whs[i].Values {
+				if sub, ok := v.(*SelectClause); ok && sub != nil {
+					subs = append(subs, sub)
+				}
+			}
+		}
+	}
+	walk(sc.Where)
+	for i := range sc.Joins {
+		walk(sc.Joins[i].On)
+	}
+	for _, sub := range sc.Subqueries {
+		if sub.Query != nil {
+			subs = append(subs, sub.Query)
+		}
+	}
+
+	set := map[string]bool{}
+	for _, sub := range subs {
+		set[sub.From] = true
+		for i := range sub.Joins {
+			set[sub.Joins[i].Table] = true
+		}
+		for _, table := range sub.subTables() {
+			set[table] = true
+		}
+	}
+	tables := make([]string, 0, len(set))
+	for table := range set {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+	return tables
+}
+
+// Query executes the SelectClause and scans all rows into dest, which must be a pointer to a slice.
+func (sc *SelectClause) Query(ctx context.Context, db sqlx.ExtContext, dest interface{}) error {
+	stm, val, err := sc.SQLStm()
+	if err != nil {
+		return err
+	}
+	return traceQuery(ctx, OpQuery, sc.From, stm, val, func(ctx context.Context) (int64, error) {
+		if err := sqlx.SelectContext(ctx, db, dest, stm, val...); err != nil {
+			return -1, err
+		}
+		return int64(reflect.Indirect(reflect.ValueOf(dest)).Len()), nil
+	})
+}
+
+// Get executes the SelectClause and scans the first row into dest, ErrNotExist is returned when no row is found.
+func (sc *SelectClause) Get(ctx context.Context, db sqlx.ExtContext, dest interface{}) error {
+	stm, val, err := sc.SQLStm()
+	if err != nil {
+		return err
+	}
+	found := true
+	err = traceQuery(ctx, OpGet, sc.From, stm, val, func(ctx context.Context) (int64, error) {
+		if err := sqlx.GetContext(ctx, db, dest, stm, val...); err != nil {
+			if err == sql.ErrNoRows {
+				found = false
+				return 0, nil
+			}
+			return -1, err
+		}
+		return 1, nil
+	})
+	if err == nil && !found {
+		return ErrNotExist
 	}
-	return whsStr
+	return err
 }
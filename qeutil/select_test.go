@@ -1,6 +1,10 @@
 package qeutil
 
 import (
+	"context"
+	"database/sql/driver"
+	"path"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -19,12 +23,12 @@ func TestSelectClauseSQLStm(t *testing.T) {
 		},
 		GroupBy: []string{"grp"},
 		Having:  "1<>0",
-		OrderBy: []string{"ord"},
+		OrderBy: []Order{Asc("ord")},
 		Limit:   &limit,
 		Offset:  &offset,
 	}
 	stm, val, _ := sc.SQLStm()
-	assert.Equal(t, "SELECT id FROM table WHERE in_comp IN (?,?,?) AND gt_comp > ? AND lt_comp < ? GROUP BY grp HAVING 1<>0 ORDER BY ord LIMIT 10 OFFSET 50", stm)
+	assert.Equal(t, "SELECT `id` FROM `table` WHERE `in_comp` IN (?,?,?) AND `gt_comp` > ? AND `lt_comp` < ? GROUP BY `grp` HAVING 1<>0 ORDER BY `ord` LIMIT 10 OFFSET 50", stm)
 	assert.Equal(t, []interface{}{"hello", "world", "!", 1, 2}, val)
 }
 
@@ -41,9 +45,362 @@ func TestSelectClauseCacheKey(t *testing.T) {
 		},
 		GroupBy: []string{"grp"},
 		Having:  "1<>0",
-		OrderBy: []string{"ord"},
+		OrderBy: []Order{Asc("ord")},
 		Limit:   &limit,
 		Offset:  &offset,
 	}
-	assert.Equal(t, "table:where:in_comp=[hello,world,!]&gt_comp>1&lt_comp<2:grp:grp:hav:1<>0:ord:ord:lim:10:off:50", sc.CacheKey())
+	assert.Equal(t, "table:sel:id:where:in({in_comp='%21'}{in_comp='hello'}{in_comp='world'})&{gt_comp>1}&{lt_comp<2}:grp:grp:hav:1%3c%3e0:ord:ord:lim:10:off:50", sc.CacheKey())
+}
+
+func TestSelectClauseCacheKeyCanonical(t *testing.T) {
+	key := func(whs ...Wh) string {
+		return (&SelectClause{From: "users", Where: whs}).CacheKey()
+	}
+	eq := func(col string, val interface{}) Wh {
+		return Wh{Operator: Eq, Values: map[string]interface{}{col: val}}
+	}
+	// Conditions are sorted, And groups are flattened and In lists are sets
+	assert.Equal(t, "users:where:in({id=1}{id=2})&{name='Bob'}", key(eq("name", "Bob"), eq("id", []int{2, 1, 2})))
+	assert.Equal(t, key(eq("name", "Bob"), eq("id", []int{2, 1})), key(AndWh(Wh{Operator: In, Values: map[string]interface{}{"id": []int{1, 2}}}, eq("name", "Bob"))))
+	assert.Equal(t, key(Wh{Operator: Eq, Values: map[string]interface{}{"id": 1, "name": "Bob"}}), key(eq("name", "Bob"), eq("id", 1)))
+	assert.Equal(t, key(eq("id", 1)), key(eq("id", []int{1, 1})))
+	assert.Equal(t, key(OrWh(eq("b", 1), eq("a", 1))), key(OrWh(eq("a", 1), OrWh(eq("b", 1)))))
+	assert.Equal(t, key(Wh{Operator: NotIn, Values: map[string]interface{}{"id": []int{3, 1}}}), key(Wh{Operator: NotIn, Values: map[string]interface{}{"id": []int{1, 3, 1}}}))
+	assert.NotEqual(t, key(Wh{Operator: Between, Values: map[string]interface{}{"id": []int{1, 3}}}), key(Wh{Operator: Between, Values: map[string]interface{}{"id": []int{3, 1}}}))
+	assert.NotEqual(t, key(OrWh(eq("a", 1), eq("b", 1))), key(AndWh(eq("a", 1), eq("b", 1))))
+
+	// Values keep their case while identifiers are lowercased
+	assert.Equal(t, "users:where:{name='Bob'}", (&SelectClause{From: "Users", Where: []Wh{eq("NAME", "Bob")}}).CacheKey())
+	assert.NotEqual(t, key(eq("name", "Bob")), key(eq("name", "bob")))
+
+	// The select list is part of the key
+	assert.Equal(t, "users:sel:id&name:where:{id=1}", (&SelectClause{Select: []string{"id", "name"}, From: "users", Where: []Wh{eq("id", 1)}}).CacheKey())
+}
+
+func TestCacheKeyVersion(t *testing.T) {
+	CacheKeyVersion = "v2"
+	defer func() { CacheKeyVersion = "" }()
+	sc := SelectClause{From: "users", Where: []Wh{Wh{Operator: Eq, Values: map[string]interface{}{"id": 1}}}}
+	assert.Equal(t, "v2:users:where:{id=1}", sc.CacheKey())
+	uc := UpdateClause{Update: "users", Where: sc.Where}
+	assert.Equal(t, []string{"v2:users", "v2:users:*", "v2:users:join:*", "v2:*:join:*=users[@(]*"}, uc.ToUnlinks())
+	assert.True(t, matchAny(uc.ToUnlinks(), sc.CacheKey()))
+	assert.Equal(t, []string{"tag:v2:users", "tag:v2:users:{id=1}", "tag:v2:users:{id}"}, sc.CacheTags())
+}
+
+func TestMaxCacheKeyLen(t *testing.T) {
+	MaxCacheKeyLen = 40
+	defer func() { MaxCacheKeyLen = 0 }()
+	ids := []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+	sc := SelectClause{From: "users", Where: []Wh{Wh{Operator: In, Values: map[string]interface{}{"id": ids}}}}
+	key := sc.CacheKey()
+	assert.Regexp(t, "^users:#[0-9a-f]{32}$", key)
+	ids[0] = 11
+	assert.NotEqual(t, key, sc.CacheKey())
+	assert.Equal(t, "users:where:{id=1}", (&SelectClause{From: "users", Where: []Wh{Wh{Operator: Eq, Values: map[string]interface{}{"id": 1}}}}).CacheKey())
+
+	// Hashed keys are unlinked by any write on their table
+	uc := UpdateClause{Update: "users", Where: []Wh{Wh{Operator: Eq, Values: map[string]interface{}{"id": 20}}}}
+	assert.True(t, matchAny(uc.ToUnlinks(), key))
+	assert.False(t, matchAny((&UpdateClause{Update: "groups", Where: uc.Where}).ToUnlinks(), key))
+}
+
+func TestSelectClauseSQLStmGroup(t *testing.T) {
+	sc := SelectClause{
+		From: "table",
+		Where: []Wh{
+			OrWh(
+				Wh{Operator: Eq, Values: map[string]interface{}{"status": "open"}},
+				Wh{Operator: Eq, Values: map[string]interface{}{"owner_id": 1}},
+			),
+			NotWh(Wh{Operator: Eq, Values: map[string]interface{}{"deleted": true}}),
+		},
+	}
+	stm, val, _ := sc.SQLStm()
+	assert.Equal(t, "SELECT * FROM `table` WHERE (`status` = ? OR `owner_id` = ?) AND NOT (`deleted` = ?)", stm)
+	assert.Equal(t, []interface{}{"open", 1, true}, val)
+
+	// Groups are Wh literals as well
+	sc.Where = []Wh{
+		Wh{"or", map[string]interface{}{"": Group{
+			Wh{"=", map[string]interface{}{"status": "open"}},
+			Wh{"=", map[string]interface{}{"owner_id": 1}},
+		}}},
+		Wh{"not", map[string]interface{}{"": Group{Wh{"=", map[string]interface{}{"deleted": true}}}}},
+	}
+	stm2, _, _ := sc.SQLStm()
+	assert.Equal(t, stm, stm2)
+}
+
+func TestSelectClauseSQLStmNestedGroup(t *testing.T) {
+	sc := SelectClause{
+		From: "table",
+		Where: []Wh{
+			OrWh(
+				AndWh(
+					Wh{Operator: Gt, Values: map[string]interface{}{"score": 50}},
+					Wh{Operator: Lt, Values: map[string]interface{}{"score": 80}},
+				),
+				NotWh(OrWh(
+					Wh{Operator: Eq, Values: map[string]interface{}{"a": 1}},
+					Wh{Operator: In, Values: map[string]interface{}{"b": []int{2, 3}}},
+				)),
+			),
+		},
+	}
+	stm, val, _ := sc.SQLStm()
+	assert.Equal(t, "SELECT * FROM `table` WHERE ((`score` > ? AND `score` < ?) OR NOT ((`a` = ? OR `b` IN (?,?))))", stm)
+	assert.Equal(t, []interface{}{50, 80, 1, 2, 3}, val)
+}
+
+func TestSelectClauseCacheKeyGroup(t *testing.T) {
+	sc := SelectClause{
+		From: "table",
+		Where: []Wh{
+			OrWh(
+				Wh{Operator: Eq, Values: map[string]interface{}{"status": "open"}},
+				Wh{Operator: Eq, Values: map[string]interface{}{"owner_id": 1}},
+			),
+			NotWh(Wh{Operator: Eq, Values: map[string]interface{}{"deleted": true}}),
+		},
+	}
+	assert.Equal(t, "table:where:not({deleted=true})&or({owner_id=1}|{status='open'})", sc.CacheKey())
+	assert.Equal(t, []string{"table", "table:*", "table:join:*", "*:join:*=table[@(]*"}, sc.ToUnlinks())
+}
+
+func TestSelectClauseSQLStmOperators(t *testing.T) {
+	sc := SelectClause{
+		From: "table",
+		Where: []Wh{
+			Wh{Operator: NotIn, Values: map[string]interface{}{"status": []string{"closed", "void"}}},
+			Wh{Operator: Between, Values: map[string]interface{}{"score": []int{50, 80}}},
+			Wh{Operator: NotBetween, Values: map[string]interface{}{"rank": [2]int{1, 3}}},
+			Wh{Operator: IsNull, Values: map[string]interface{}{"deleted_at": nil}},
+			Wh{Operator: IsNotNull, Values: map[string]interface{}{"submitted_at": nil}},
+			Wh{Operator: NotLike, Values: map[string]interface{}{"name": "test%"}},
+			Wh{Operator: Regexp, Values: map[string]interface{}{"code": "^[A-Z]+$"}},
+			Wh{Operator: JSONContains, Values: map[string]interface{}{"tags": []string{"math"}}},
+		},
+	}
+	stm, val, err := sc.SQLStm()
+	assert.NoError(t, err)
+	assert.Equal(t, "SELECT * FROM `table` WHERE `status` NOT IN (?,?) AND `score` BETWEEN ? AND ? AND `rank` NOT BETWEEN ? AND ? AND `deleted_at` IS NULL AND `submitted_at` IS NOT NULL AND `name` NOT LIKE ? AND `code` REGEXP ? AND JSON_CONTAINS(`tags`, ?)", stm)
+	assert.Equal(t, []interface{}{"closed", "void", 50, 80, 1, 3, "test%", "^[A-Z]+$", `["math"]`}, val)
+}
+
+func TestSelectClauseSQLStmBadBetween(t *testing.T) {
+	sc := SelectClause{
+		From:  "table",
+		Where: []Wh{Wh{Operator: Between, Values: map[string]interface{}{"score": 50}}},
+	}
+	_, _, err := sc.SQLStm()
+	assert.Error(t, err)
+}
+
+func TestSelectClauseSQLStmNotIn(t *testing.T) {
+	sc := SelectClause{From: "table"}
+	for _, val := range []interface{}{nil, []interface{}{1, nil}} {
+		sc.Where = []Wh{Wh{Operator: NotIn, Values: map[string]interface{}{"status": val}}}
+		_, _, err := sc.SQLStm()
+		assert.Error(t, err)
+	}
+
+	// An empty list excludes nothing
+	sc.Where = []Wh{Wh{Operator: NotIn, Values: map[string]interface{}{"status": []string{}}}}
+	stm, _, err := sc.SQLStm()
+	assert.NoError(t, err)
+	assert.Equal(t, "SELECT * FROM `table` WHERE (1=1)", stm)
+}
+
+func TestSelectClauseSQLStmJoin(t *testing.T) {
+	sc := SelectClause{
+		Select: []string{"t.id", "u.name"},
+		From:   "table",
+		As:     "t",
+		Joins: []Join{
+			Join{Type: LeftJoin, Table: "users", As: "u", On: []Wh{
+				Wh{Operator: Eq, Values: map[string]interface{}{"u.id": Col("t.user_id")}},
+				Wh{Operator: Eq, Values: map[string]interface{}{"u.active": true}},
+			}},
+			Join{Type: InnerJoin, Table: "groups", On: []Wh{
+				Wh{Operator: Eq, Values: map[string]interface{}{"groups.id": Col("t.group_id")}},
+			}},
+			Join{Type: CrossJoin, Table: "settings"},
+		},
+		Where: []Wh{
+			Wh{Operator: Gt, Values: map[string]interface{}{"t.score": 50}},
+		},
+	}
+	stm, val, err := sc.SQLStm()
+	assert.NoError(t, err)
+	assert.Equal(t, "SELECT `t`.`id`, `u`.`name` FROM `table` AS `t` LEFT JOIN `users` AS `u` ON `u`.`id` = `t`.`user_id` AND `u`.`active` = ? INNER JOIN `groups` ON `groups`.`id` = `t`.`group_id` CROSS JOIN `settings` WHERE `t`.`score` > ?", stm)
+	assert.Equal(t, []interface{}{true, 50}, val)
+
+	sc.Joins = []Join{Join{Type: "outer", Table: "users"}}
+	_, _, err = sc.SQLStm()
+	assert.Error(t, err)
+
+	sc.Joins = []Join{Join{Type: CrossJoin, Table: "users", On: sc.Where}}
+	_, _, err = sc.SQLStm()
+	assert.Error(t, err)
+}
+
+func TestSelectClauseCacheKeyJoin(t *testing.T) {
+	sc := SelectClause{
+		From: "table",
+		As:   "t",
+		Joins: []Join{
+			Join{Type: LeftJoin, Table: "users", As: "u", On: []Wh{
+				Wh{Operator: Eq, Values: map[string]interface{}{"u.id": Col("t.user_id")}},
+			}},
+		},
+		Where: []Wh{
+			Wh{Operator: Eq, Values: map[string]interface{}{"t.id": 1}},
+		},
+	}
+	key := sc.CacheKey()
+	assert.Equal(t, "table:join:@t&left=users@u({u.id=@t.user_id}):where:{t.id=1}", key)
+
+	// Writes to either table unlink the joined entry
+	for _, unlinks := range [][]string{
+		(&UpdateClause{Update: "table", Where: []Wh{Wh{Operator: Eq, Values: map[string]interface{}{"id": 2}}}}).ToUnlinks(),
+		(&DeleteClause{From: "users", Where: []Wh{Wh{Operator: Eq, Values: map[string]interface{}{"id": 2}}}}).ToUnlinks(),
+	} {
+		matched := false
+		for _, pattern := range unlinks {
+			if ok, _ := path.Match(pattern, key); ok {
+				matched = true
+			}
+		}
+		assert.True(t, matched, unlinks)
+	}
+
+	// Writes to other tables do not
+	for _, pattern := range (&DeleteClause{From: "user"}).ToUnlinks() {
+		ok, _ := path.Match(pattern, key)
+		assert.False(t, ok, pattern)
+	}
+	assert.Equal(t, []string{"table", "table:*", "table:join:*", "*:join:*=table[@(]*", "users:join:*", "*:join:*=users[@(]*"}, sc.ToUnlinks())
+}
+
+type testRow struct {
+	ID   int64  `db:"id"`
+	Name string `db:"name"`
+}
+
+func TestSelectClauseQuery(t *testing.T) {
+	db, conn := newFakeDB(func(stm string, args []driver.Value) fakeResp {
+		if args[0] == int64(0) {
+			return fakeResp{cols: []string{"id", "name"}}
+		}
+		return fakeResp{cols: []string{"id", "name"}, rows: [][]driver.Value{{int64(1), "a"}, {int64(2), "b"}}}
+	})
+	sc := SelectClause{
+		Select: []string{"id", "name"},
+		From:   "table",
+		Where:  []Wh{Wh{Operator: Gt, Values: map[string]interface{}{"id": 1}}},
+	}
+
+	var rows []testRow
+	assert.NoError(t, sc.Query(context.Background(), db, &rows))
+	assert.Equal(t, []testRow{{1, "a"}, {2, "b"}}, rows)
+
+	var row testRow
+	assert.NoError(t, sc.Get(context.Background(), db, &row))
+	assert.Equal(t, testRow{1, "a"}, row)
+	assert.Equal(t, []string{"SELECT `id`, `name` FROM `table` WHERE `id` > ?", "SELECT `id`, `name` FROM `table` WHERE `id` > ?"}, conn.statements())
+
+	sc.Where[0].Values["id"] = 0
+	assert.Equal(t, ErrNotExist, sc.Get(context.Background(), db, &row))
+}
+
+func TestSelectClauseSQLStmSubquery(t *testing.T) {
+	members := &SelectClause{
+		Select: []string{"user_id"},
+		From:   "group_members",
+		Where:  []Wh{Wh{Operator: Eq, Values: map[string]interface{}{"group_id": 3}}},
+	}
+	sc := SelectClause{
+		From: "answers",
+		As:   "a",
+		Subqueries: []Subquery{
+			{As: "n", Query: &SelectClause{Select: []string{"id"}, From: "comments", Where: []Wh{
+				Wh{Operator: Eq, Values: map[string]interface{}{"comments.answer_id": Col("a.id")}},
+			}, Limit: intPtr(1)}},
+		},
+		Where: []Wh{
+			Wh{Operator: Gt, Values: map[string]interface{}{"score": 50}},
+			Wh{Operator: In, Values: map[string]interface{}{"user_id": members, "exam_id": 7}},
+			NotExistsWh(&SelectClause{From: "flags", Where: []Wh{
+				Wh{Operator: Eq, Values: map[string]interface{}{"flags.answer_id": Col("a.id")}},
+				Wh{Operator: Eq, Values: map[string]interface{}{"flags.open": true}},
+			}}),
+		},
+		Limit:   intPtr(10),
+		Dialect: PostgreSQL,
+	}
+	stm, val, err := sc.SQLStm()
+	assert.NoError(t, err)
+	assert.Equal(t, `SELECT *, (SELECT "id" FROM "comments" WHERE "comments"."answer_id" = "a"."id" LIMIT 1) AS "n" FROM "answers" AS "a" `+
+		`WHERE "score" > $1 AND ("exam_id" = $2 AND "user_id" IN (SELECT "user_id" FROM "group_members" WHERE "group_id" = $3)) `+
+		`AND NOT EXISTS (SELECT * FROM "flags" WHERE "flags"."answer_id" = "a"."id" AND "flags"."open" = $4) LIMIT 10`, stm)
+	assert.Equal(t, []interface{}{50, 7, 3, true}, val)
+
+	sc.Where = []Wh{Wh{Operator: Like, Values: map[string]interface{}{"user_id": members}}}
+	_, _, err = sc.SQLStm()
+	assert.Error(t, err)
+}
+
+func TestSelectClauseCacheKeySubquery(t *testing.T) {
+	sc := SelectClause{
+		From: "answers",
+		Where: []Wh{
+			Wh{Operator: In, Values: map[string]interface{}{"user_id": &SelectClause{
+				Select: []string{"user_id"},
+				From:   "group_members",
+				Where:  []Wh{Wh{Operator: Eq, Values: map[string]interface{}{"group_id": 3}}},
+			}}},
+		},
+	}
+	key := sc.CacheKey()
+	assert.Equal(t, "answers:join:sub=group_members():where:{user_id in (group_members%3asel%3auser_id%3awhere%3a%7bgroup_id%3d3%7d)}", key)
+	assert.NotEqual(t, key, (&SelectClause{From: "answers", Where: []Wh{
+		Wh{Operator: In, Values: map[string]interface{}{"user_id": &SelectClause{
+			Select: []string{"user_id"},
+			From:   "group_members",
+			Where:  []Wh{Wh{Operator: Eq, Values: map[string]interface{}{"group_id": 4}}},
+		}}},
+	}}).CacheKey())
+
+	// Writes to the table of the subquery unlink the entry
+	matched := false
+	for _, pattern := range (&InsertClause{Into: "group_members", Values: map[string]interface{}{"group_id": 3}}).ToUnlinks() {
+		if ok, _ := path.Match(pattern, key); ok {
+			matched = true
+		}
+	}
+	assert.True(t, matched)
+	assert.Equal(t, []string{"answers", "answers:*", "answers:join:*", "*:join:*=answers[@(]*", "group_members:join:*", "*:join:*=group_members[@(]*"}, sc.ToUnlinks())
+}
+
+func TestWhSQLStmStable(t *testing.T) {
+	vals := map[string]interface{}{
+		Eq: "x", Gt: 1, Lt: 1, GtEq: 1, LtEq: 1, NotEq: "x", Like: "x%", NotLike: "x%", Regexp: "^x", JSONContains: "[1]",
+		In: []string{"x", "y"}, NotIn: []string{"x", "y"}, Between: []int{1, 2}, NotBetween: []int{1, 2}, IsNull: nil, IsNotNull: nil,
+	}
+	for _, d := range []Dialect{MySQL, PostgreSQL} {
+		for op, v := range vals {
+			sc := SelectClause{From: "table", Dialect: d, Where: []Wh{Wh{Operator: op, Values: map[string]interface{}{"c": v, "a": v, "b": v}}}}
+			first, _, err := sc.SQLStm()
+			assert.NoError(t, err, op)
+			a, b, c := strings.Index(first, d.Quote("a")), strings.Index(first, d.Quote("b")), strings.Index(first, d.Quote("c"))
+			assert.True(t, a < b && b < c, "%v: %v", op, first)
+			for i := 0; i < 50; i++ {
+				stm, _, _ := sc.SQLStm()
+				if !assert.Equal(t, first, stm, op) {
+					break
+				}
+			}
+		}
+	}
 }
@@ -0,0 +1,114 @@
+package qeutil
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/jmoiron/sqlx"
+)
+
+// Trashed modes of a SelectClause, which control the soft-deleted rows of its tables.
+const (
+	// WithoutTrashed filters the soft-deleted rows of every table, it is the default mode
+	WithoutTrashed string = ""
+	// WithTrashed reads the soft-deleted rows as well
+	WithTrashed string = "with"
+	// OnlyTrashed reads only the soft-deleted rows of the main table, joined tables are still filtered
+	OnlyTrashed string = "only"
+)
+
+var (
+	softDeletesMu sync.RWMutex
+	softDeletes   = map[string]string{}
+)
+
+// RegisterSoftDelete registers a soft-deleted table with its deletion time column, e.g. `deleted_at`, which
+// is NULL for the rows not deleted. It is usually called in init of the package owning the table, and panics
+// if the names are not valid identifiers.
+func RegisterSoftDelete(table, col string) {
+	q := newIdents(MySQL, nil)
+	if err := q.check(table); err != nil {
+		panic(err)
+	}
+	if err := q.check(col); err != nil {
+		panic(err)
+	}
+	softDeletesMu.Lock()
+	softDeletes[table] = col
+	softDeletesMu.Unlock()
+}
+
+// SoftDeleteColumn returns the deletion time column of a table, and whether the table is soft-deleted.
+func SoftDeleteColumn(table string) (string, bool) {
+	softDeletesMu.RLock()
+	defer softDeletesMu.RUnlock()
+	col, ok := softDeletes[table]
+	return col, ok
+}
+
+// trashedExpr returns the condition of the trashed rows of a table referred to by ref, or the rows not trashed.
+// An empty string is returned if the table is not soft-deleted.
+func trashedExpr(d Dialect, table, ref string, trashed bool) string {
+	col, ok := SoftDeleteColumn(table)
+	if !ok {
+		return ""
+	}
+	if ref != "" {
+		col = ref + "." + col
+	}
+	if trashed {
+		return d.Quote(col) + " IS NOT NULL"
+	}
+	return d.Quote(col) + " IS NULL"
+}
+
+// RestoreClause restores the soft-deleted rows of a table, the rows not deleted are left unchanged.
+// A restore without conditions in Where is refused with ErrFullTable unless AllowFullTable is set.
+type RestoreClause struct {
+	From           string
+	Where          []Wh
+	AllowFullTable bool    // AllowFullTable allows the restore of every deleted row of the table.
+	Dialect        Dialect // Dialect of the statement, MySQL if not given.
+	Schema         Schema  // Schema limits the table and columns of the statement if given.
+}
+
+// SQLStm return a query statment of the Dialect from the RestoreClause.
+func (rc *RestoreClause) SQLStm() (string, []interface{}, error) {
+	if err := rc.Dialect.check(); err != nil {
+		return "", nil, err
+	}
+	col, ok := SoftDeleteColumn(rc.From)
+	if !ok {
+		return "", nil, fmt.Errorf("table %v is not soft-deleted", rc.From)
+	}
+	q := newIdents(rc.Dialect, rc.Schema)
+	table, err := q.table(rc.From, "")
+	if err != nil {
+		return "", nil, err
+	}
+	if err := checkFullTable(rc.From, rc.Where, rc.AllowFullTable); err != nil {
+		return "", nil, err
+	}
+	builder := sq.Update(table).PlaceholderFormat(rc.Dialect.placeholder()).Set(rc.Dialect.Quote(col), nil)
+	for i := range rc.Where {
+		builder = builder.Where(rc.Where[i].toWhBuilder(q))
+	}
+	return builder.Where(trashedExpr(rc.Dialect, rc.From, "", true)).ToSql()
+}
+
+// ToUnlinks return the patterns which can be used to unlink keys in redis.
+// All cache entries of the table are included since the restored rows may appear in any list of the table.
+func (rc *RestoreClause) ToUnlinks() []string {
+	return tableUnlinkPatterns(rc.From)
+}
+
+// Exec executes the RestoreClause and returns the number of restored rows, ErrNotChanged is returned when no row is restored.
+func (rc *RestoreClause) Exec(ctx context.Context, db sqlx.ExtContext) (int64, error) {
+	stm, val, err := rc.SQLStm()
+	if err != nil {
+		return 0, err
+	}
+	return execAffected(ctx, db, OpRestore, rc.From, stm, val)
+}
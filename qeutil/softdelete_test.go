@@ -0,0 +1,161 @@
+package qeutil
+
+import (
+	"context"
+	"database/sql/driver"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func init() {
+	RegisterSoftDelete("exam_sessions", "deleted_at")
+	RegisterSoftDelete("examiners", "removed_at")
+}
+
+func TestRegisterSoftDelete(t *testing.T) {
+	col, ok := SoftDeleteColumn("exam_sessions")
+	assert.True(t, ok)
+	assert.Equal(t, "deleted_at", col)
+	_, ok = SoftDeleteColumn("answers")
+	assert.False(t, ok)
+	assert.Panics(t, func() { RegisterSoftDelete("exam_sessions", "deleted_at; --") })
+}
+
+func TestSelectClauseSQLStmTrashed(t *testing.T) {
+	sc := SelectClause{From: "exam_sessions", Where: []Wh{Wh{Operator: Eq, Values: map[string]interface{}{"user_id": 7}}}}
+	stm, _, err := sc.SQLStm()
+	assert.NoError(t, err)
+	assert.Equal(t, "SELECT * FROM `exam_sessions` WHERE `user_id` = ? AND `deleted_at` IS NULL", stm)
+
+	sc.Trashed = OnlyTrashed
+	stm, _, err = sc.SQLStm()
+	assert.NoError(t, err)
+	assert.Equal(t, "SELECT * FROM `exam_sessions` WHERE `user_id` = ? AND `deleted_at` IS NOT NULL", stm)
+
+	sc.Trashed = WithTrashed
+	stm, _, err = sc.SQLStm()
+	assert.NoError(t, err)
+	assert.Equal(t, "SELECT * FROM `exam_sessions` WHERE `user_id` = ?", stm)
+
+	sc.Trashed = "all"
+	_, _, err = sc.SQLStm()
+	assert.Error(t, err)
+
+	// Joined tables are filtered in ON
+	sc = SelectClause{
+		From: "exam_sessions",
+		As:   "s",
+		Joins: []Join{
+			Join{Type: LeftJoin, Table: "examiners", As: "e", On: []Wh{Wh{Operator: Eq, Values: map[string]interface{}{"e.id": Col("s.examiner_id")}}}},
+			Join{Type: InnerJoin, Table: "answers", As: "a", On: []Wh{Wh{Operator: Eq, Values: map[string]interface{}{"a.session_id": Col("s.id")}}}},
+		},
+	}
+	stm, _, err = sc.SQLStm()
+	assert.NoError(t, err)
+	assert.Equal(t, "SELECT * FROM `exam_sessions` AS `s` LEFT JOIN `examiners` AS `e` ON `e`.`id` = `s`.`examiner_id` AND `e`.`removed_at` IS NULL INNER JOIN `answers` AS `a` ON `a`.`session_id` = `s`.`id` WHERE `s`.`deleted_at` IS NULL", stm)
+
+	sc = SelectClause{From: "answers", Joins: []Join{Join{Type: CrossJoin, Table: "examiners"}}}
+	stm, _, err = sc.SQLStm()
+	assert.NoError(t, err)
+	assert.Equal(t, "SELECT * FROM `answers` CROSS JOIN `examiners` WHERE `examiners`.`removed_at` IS NULL", stm)
+
+	// Counts of a list are filtered as well
+	stm, _, err = (&SelectClause{From: "exam_sessions", Limit: intPtr(10)}).Count().SQLStm()
+	assert.NoError(t, err)
+	assert.Equal(t, "SELECT COUNT(*) FROM `exam_sessions` WHERE `deleted_at` IS NULL", stm)
+}
+
+func TestSelectClauseCacheKeyTrashed(t *testing.T) {
+	sc := SelectClause{From: "exam_sessions", Where: []Wh{Wh{Operator: Eq, Values: map[string]interface{}{"user_id": 7}}}}
+	assert.Equal(t, "exam_sessions:where:{user_id=7}", sc.CacheKey())
+	sc.Trashed = WithTrashed
+	assert.Equal(t, "exam_sessions:trashed:with:where:{user_id=7}", sc.CacheKey())
+}
+
+func TestDeleteClauseSQLStmSoft(t *testing.T) {
+	dc := DeleteClause{From: "exam_sessions", Where: []Wh{Wh{Operator: Eq, Values: map[string]interface{}{"id": 1}}}}
+	stm, val, err := dc.SQLStm()
+	assert.NoError(t, err)
+	assert.Equal(t, "UPDATE `exam_sessions` SET `deleted_at` = NOW() WHERE `id` = ? AND `deleted_at` IS NULL", stm)
+	assert.Equal(t, []interface{}{1}, val)
+
+	dc.Dialect = SQLite
+	stm, _, err = dc.SQLStm()
+	assert.NoError(t, err)
+	assert.Equal(t, `UPDATE "exam_sessions" SET "deleted_at" = CURRENT_TIMESTAMP WHERE "id" = ? AND "deleted_at" IS NULL`, stm)
+
+	dc.Dialect = MySQL
+	dc.Force = true
+	stm, _, err = dc.SQLStm()
+	assert.NoError(t, err)
+	assert.Equal(t, "DELETE FROM `exam_sessions` WHERE `id` = ?", stm)
+}
+
+func TestRestoreClause(t *testing.T) {
+	rc := RestoreClause{From: "exam_sessions", Where: []Wh{Wh{Operator: Eq, Values: map[string]interface{}{"id": 1}}}, Dialect: PostgreSQL}
+	stm, val, err := rc.SQLStm()
+	assert.NoError(t, err)
+	assert.Equal(t, `UPDATE "exam_sessions" SET "deleted_at" = $1 WHERE "id" = $2 AND "deleted_at" IS NOT NULL`, stm)
+	assert.Equal(t, []interface{}{nil, 1}, val)
+
+	_, _, err = (&RestoreClause{From: "answers"}).SQLStm()
+	assert.Error(t, err)
+
+	// Restored rows may appear in any list of the table
+	db, _ := newFakeDB(func(stm string, args []driver.Value) fakeResp {
+		return fakeResp{affected: 1}
+	})
+	fc := newFakeCache()
+	fc.Set("exam_sessions:where:{user_id=7}", 1, time.Minute)
+	fc.Set("answers:where:{user_id=7}", 1, time.Minute)
+	e := Executor{DB: db, Cache: fc}
+	n, err := e.Restore(context.Background(), &RestoreClause{From: "exam_sessions", Where: rc.Where})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), n)
+	assert.Equal(t, []string{"answers:where:{user_id=7}"}, fc.keys())
+}
+
+func TestExecutorSoftDelete(t *testing.T) {
+	db, _ := newFakeDB(func(stm string, args []driver.Value) fakeResp {
+		return fakeResp{affected: 1}
+	})
+	eq := func(col string, val interface{}) []Wh {
+		return []Wh{Wh{Operator: Eq, Values: map[string]interface{}{col: val}}}
+	}
+	list := &SelectClause{From: "exam_sessions", OrderBy: []Order{Desc("id")}, Limit: intPtr(20)}
+	trashed := &SelectClause{From: "exam_sessions", Trashed: OnlyTrashed}
+	entries := []*SelectClause{list, trashed, &SelectClause{From: "exam_sessions", Where: eq("user_id", 7)}, &SelectClause{From: "exam_sessions", Where: eq("id", 1)}, &SelectClause{From: "exam_sessions", Where: eq("id", 2)}, &SelectClause{From: "answers", Where: eq("id", 1)}}
+
+	// Soft deleted and restored rows leave and join the lists of the table. Patterns unlink every entry of the
+	// table, tags keep the entries of other ids.
+	for c, kept := range map[Cacher][]string{
+		newFakeCache():    []string{"answers:where:{id=1}"},
+		newFakeTagCache(): []string{"answers:where:{id=1}", "exam_sessions:where:{id=2}"},
+	} {
+		set := func() {
+			for _, sc := range entries {
+				assert.NoError(t, setCache(c, sc.CacheKey(), 1, time.Minute, sc.CacheTags))
+			}
+			assert.NoError(t, setCache(c, list.Count().CacheKey(), 1, time.Minute, list.CacheTags))
+		}
+		keys := func() []string {
+			if tc, ok := c.(*fakeTagCache); ok {
+				return tc.keys()
+			}
+			return c.(*fakeCache).keys()
+		}
+		e := Executor{DB: db, Cache: c}
+
+		set()
+		_, err := e.Delete(context.Background(), &DeleteClause{From: "exam_sessions", Where: eq("id", 1)})
+		assert.NoError(t, err)
+		assert.Equal(t, kept, keys())
+
+		set()
+		_, err = e.Restore(context.Background(), &RestoreClause{From: "exam_sessions", Where: eq("id", 1)})
+		assert.NoError(t, err)
+		assert.Equal(t, kept, keys())
+	}
+}
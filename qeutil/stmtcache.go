@@ -0,0 +1,154 @@
+package qeutil
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// StmtCache reuses the prepared statements of the same SQL text, it can be passed to every helper accepting
+// a sqlx.ExtContext. Statements beyond MaxStmts are executed without being prepared.
+type StmtCache struct {
+	db       *sqlx.DB
+	MaxStmts int
+
+	mu    sync.Mutex
+	stmts map[string]*stmtEntry
+}
+
+// stmtEntry is a statement of a StmtCache, which is prepared once by the first caller while the others wait for
+// ready without holding the lock of the cache.
+type stmtEntry struct {
+	ready chan struct{}
+	stmt  *sqlx.Stmt
+	err   error
+}
+
+// NewStmtCache initialises a StmtCache of the given DB, at most maxStmts statements are kept prepared.
+func NewStmtCache(db *sqlx.DB, maxStmts int) *StmtCache {
+	return &StmtCache{db: db, MaxStmts: maxStmts, stmts: map[string]*stmtEntry{}}
+}
+
+// stmt returns the prepared statement of the query, nil is returned if the cache is full or the statement cannot
+// be prepared by another caller, then the query is executed without being prepared.
+func (sc *StmtCache) stmt(ctx context.Context, query string) (*sqlx.Stmt, error) {
+	sc.mu.Lock()
+	if e, ok := sc.stmts[query]; ok {
+		sc.mu.Unlock()
+		select {
+		case <-e.ready:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		return e.stmt, nil
+	}
+	if sc.MaxStmts > 0 && len(sc.stmts) >= sc.MaxStmts {
+		sc.mu.Unlock()
+		return nil, nil
+	}
+	e := &stmtEntry{ready: make(chan struct{})}
+	sc.stmts[query] = e
+	sc.mu.Unlock()
+
+	stmt, err := sc.db.PreparexContext(ctx, query)
+	sc.mu.Lock()
+	switch {
+	case err != nil:
+		delete(sc.stmts, query)
+	case sc.stmts[query] != e:
+		// The cache is closed while preparing
+		stmt.Close()
+		stmt = nil
+	default:
+		e.stmt = stmt
+	}
+	sc.mu.Unlock()
+	close(e.ready)
+	return stmt, err
+}
+
+// Len returns the number of prepared statements.
+func (sc *StmtCache) Len() int {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	return len(sc.stmts)
+}
+
+// Close closes all prepared statements, the statements being prepared are closed once they are prepared.
+func (sc *StmtCache) Close() error {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	var firstErr error
+	for query, e := range sc.stmts {
+		if e.stmt != nil {
+			if err := e.stmt.Close(); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+		delete(sc.stmts, query)
+	}
+	return firstErr
+}
+
+// ExecContext implements sqlx.ExecerContext.
+func (sc *StmtCache) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	stmt, err := sc.stmt(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	if stmt == nil {
+		return sc.db.ExecContext(ctx, query, args...)
+	}
+	return stmt.ExecContext(ctx, args...)
+}
+
+// QueryContext implements sqlx.QueryerContext.
+func (sc *StmtCache) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	stmt, err := sc.stmt(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	if stmt == nil {
+		return sc.db.QueryContext(ctx, query, args...)
+	}
+	return stmt.QueryContext(ctx, args...)
+}
+
+// QueryxContext implements sqlx.QueryerContext.
+func (sc *StmtCache) QueryxContext(ctx context.Context, query string, args ...interface{}) (*sqlx.Rows, error) {
+	stmt, err := sc.stmt(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	if stmt == nil {
+		return sc.db.QueryxContext(ctx, query, args...)
+	}
+	return stmt.QueryxContext(ctx, args...)
+}
+
+// QueryRowxContext implements sqlx.QueryerContext.
+func (sc *StmtCache) QueryRowxContext(ctx context.Context, query string, args ...interface{}) *sqlx.Row {
+	stmt, err := sc.stmt(ctx, query)
+	if err != nil || stmt == nil {
+		// Fall back to an unprepared query, whose error is returned when the row is scanned
+		return sc.db.QueryRowxContext(ctx, query, args...)
+	}
+	return stmt.QueryRowxContext(ctx, args...)
+}
+
+// DriverName returns the driver name of the DB.
+func (sc *StmtCache) DriverName() string {
+	return sc.db.DriverName()
+}
+
+// Rebind transforms a query from QUESTION to the bindvar type of the DB.
+func (sc *StmtCache) Rebind(query string) string {
+	return sc.db.Rebind(query)
+}
+
+// BindNamed binds a query using the bindvar type of the DB.
+func (sc *StmtCache) BindNamed(query string, arg interface{}) (string, []interface{}, error) {
+	return sc.db.BindNamed(query, arg)
+}
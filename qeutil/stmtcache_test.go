@@ -0,0 +1,95 @@
+package qeutil
+
+import (
+	"context"
+	"database/sql/driver"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStmtCache(t *testing.T) {
+	db, conn := newFakeDB(func(stm string, args []driver.Value) fakeResp {
+		return fakeResp{cols: []string{"id", "name"}, rows: [][]driver.Value{{int64(1), "a"}}, affected: 1}
+	})
+	sc := NewStmtCache(db, 2)
+	defer sc.Close()
+
+	for i := 0; i < 3; i++ {
+		uc := UpdateClause{
+			Update: "table",
+			Set:    map[string]interface{}{"name": "a", "score": i},
+			Where:  []Wh{Wh{Operator: Eq, Values: map[string]interface{}{"id": i}}},
+		}
+		_, err := uc.Exec(context.Background(), sc)
+		assert.NoError(t, err)
+
+		var rows []testRow
+		assert.NoError(t, (&SelectClause{Select: []string{"id", "name"}, From: "table"}).Query(context.Background(), sc, &rows))
+		assert.Equal(t, []testRow{{1, "a"}}, rows)
+	}
+	assert.Equal(t, 2, sc.Len())
+	assert.Equal(t, 2, conn.prepares)
+
+	// Statements beyond MaxStmts are not prepared
+	var row testRow
+	assert.NoError(t, (&SelectClause{From: "other"}).Get(context.Background(), sc, &row))
+	assert.Equal(t, 2, sc.Len())
+	// database/sql prepares the statement beyond MaxStmts for its single execution
+	assert.Equal(t, 3, conn.prepares)
+
+	// The cached statements are reused without being prepared again
+	_, err := (&UpdateClause{Update: "table", Set: map[string]interface{}{"name": "b", "score": 1}, Where: []Wh{Wh{Operator: Eq, Values: map[string]interface{}{"id": 1}}}}).Exec(context.Background(), sc)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, conn.prepares)
+	assert.Len(t, conn.statements(), 8)
+}
+
+func TestStmtCacheConcurrentPrepare(t *testing.T) {
+	db, conn := newFakeDB(func(stm string, args []driver.Value) fakeResp {
+		return fakeResp{cols: []string{"id", "name"}, rows: [][]driver.Value{{int64(1), "a"}}}
+	})
+	slow := "SELECT * FROM `slow`"
+	release := make(chan struct{})
+	conn.prepare = func(stm string) {
+		if stm == slow {
+			<-release
+		}
+	}
+	sc := NewStmtCache(db, 0)
+	defer sc.Close()
+	ctx := context.Background()
+
+	// Callers of the slow statement wait for a single prepare
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var rows []testRow
+			assert.NoError(t, (&SelectClause{From: "slow"}).Query(ctx, sc, &rows))
+		}()
+	}
+
+	// Other statements are not blocked by the slow prepare
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		var rows []testRow
+		assert.NoError(t, (&SelectClause{From: "fast"}).Query(ctx, sc, &rows))
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("statement blocked by the prepare of another statement")
+	}
+
+	close(release)
+	wg.Wait()
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	assert.Equal(t, 2, conn.prepares)
+	assert.Equal(t, 2, sc.Len())
+}
@@ -0,0 +1,178 @@
+package qeutil
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// StructOpts defines how the fields of a struct are turned into columns. Columns are named by their `db` tags
+// like sqlx does, and the tag options `pk`, `readonly` and `omitempty` can be given after the name,
+// e.g. `db:"id,pk"`. Fields tagged `db:"-"` are skipped and untagged embedded structs are flattened.
+type StructOpts struct {
+	OmitEmpty  bool     // OmitEmpty skips all fields with zero value, as if they are tagged omitempty.
+	ReadOnly   []string // ReadOnly columns are never set by update, in addition to the fields tagged readonly.
+	PrimaryKey []string // PrimaryKey columns identify the row, in addition to the fields tagged pk.
+}
+
+// structField is a column of a struct.
+type structField struct {
+	col       string
+	val       interface{}
+	zero      bool
+	pk        bool
+	readOnly  bool
+	omitEmpty bool
+}
+
+// structFields returns the columns of the struct or pointer to struct in field order.
+func structFields(obj interface{}, opts *StructOpts) ([]structField, error) {
+	v := reflect.Indirect(reflect.ValueOf(obj))
+	if v.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("expected a struct, not %T", obj)
+	}
+	if opts == nil {
+		opts = &StructOpts{}
+	}
+
+	var fields []structField
+	var walk func(v reflect.Value)
+	walk = func(v reflect.Value) {
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			tag, ok := f.Tag.Lookup("db")
+			if tag == "-" {
+				continue
+			}
+			if f.Anonymous && !ok {
+				fv := v.Field(i)
+				if fv.Kind() == reflect.Ptr {
+					if fv.IsNil() {
+						continue
+					}
+					fv = fv.Elem()
+				}
+				if fv.Kind() == reflect.Struct {
+					walk(fv)
+					continue
+				}
+			}
+			if f.PkgPath != "" {
+				continue
+			}
+
+			name, tagOpts := tag, ""
+			if j := strings.Index(tag, ","); j >= 0 {
+				name, tagOpts = tag[:j], tag[j+1:]
+			}
+			if name == "" {
+				name = sqlx.NameMapper(f.Name)
+			}
+			field := structField{col: name, val: v.Field(i).Interface(), zero: v.Field(i).IsZero()}
+			field.omitEmpty = opts.OmitEmpty
+			for _, opt := range strings.Split(tagOpts, ",") {
+				switch opt {
+				case "pk":
+					field.pk = true
+				case "readonly":
+					field.readOnly = true
+				case "omitempty":
+					field.omitEmpty = true
+				}
+			}
+			for _, col := range opts.PrimaryKey {
+				field.pk = field.pk || col == name
+			}
+			for _, col := range opts.ReadOnly {
+				field.readOnly = field.readOnly || col == name
+			}
+			fields = append(fields, field)
+		}
+	}
+	walk(v)
+	return fields, nil
+}
+
+// pkWhs returns the where clauses of the primary key columns.
+func pkWhs(fields []structField) ([]Wh, error) {
+	var whs []Wh
+	for _, field := range fields {
+		if field.pk {
+			whs = append(whs, Wh{Operator: Eq, Values: map[string]interface{}{field.col: field.val}})
+		}
+	}
+	if len(whs) == 0 {
+		return nil, errors.New("no primary key column in struct")
+	}
+	return whs, nil
+}
+
+// InsertFromStruct returns an InsertClause inserting the struct as a row. Primary key columns with zero value
+// are skipped so that they can be generated by DB, e.g. by AUTO_INCREMENT, a single skipped primary key is the
+// Returning column of PostgreSQL.
+func InsertFromStruct(table string, obj interface{}, opts *StructOpts) (*InsertClause, error) {
+	fields, err := structFields(obj, opts)
+	if err != nil {
+		return nil, err
+	}
+	ic := InsertClause{Into: table, Values: map[string]interface{}{}}
+	var generated []string
+	for _, field := range fields {
+		if field.zero && field.pk {
+			generated = append(generated, field.col)
+		}
+		if field.zero && (field.omitEmpty || field.pk) {
+			continue
+		}
+		ic.Values[field.col] = field.val
+		ic.Columns = append(ic.Columns, field.col)
+	}
+	if len(generated) == 1 {
+		ic.Returning = generated[0]
+	}
+	return &ic, nil
+}
+
+// UpdateFromStruct returns an UpdateClause setting the columns of the struct to the row identified by its
+// primary key. Primary key and read-only columns are not set.
+func UpdateFromStruct(table string, obj interface{}, opts *StructOpts) (*UpdateClause, error) {
+	fields, err := structFields(obj, opts)
+	if err != nil {
+		return nil, err
+	}
+	whs, err := pkWhs(fields)
+	if err != nil {
+		return nil, err
+	}
+	uc := UpdateClause{Update: table, Set: map[string]interface{}{}, Where: whs}
+	for _, field := range fields {
+		if field.pk || field.readOnly || (field.zero && field.omitEmpty) {
+			continue
+		}
+		uc.Set[field.col] = field.val
+		uc.Columns = append(uc.Columns, field.col)
+	}
+	return &uc, nil
+}
+
+// DeleteFromStruct returns a DeleteClause deleting the row identified by the primary key of the struct.
+func DeleteFromStruct(table string, obj interface{}, opts *StructOpts) (*DeleteClause, error) {
+	whs, err := PKWhs(obj, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &DeleteClause{From: table, Where: whs}, nil
+}
+
+// PKWhs returns the where clauses identifying the row of the struct by its primary key.
+func PKWhs(obj interface{}, opts *StructOpts) ([]Wh, error) {
+	fields, err := structFields(obj, opts)
+	if err != nil {
+		return nil, err
+	}
+	return pkWhs(fields)
+}
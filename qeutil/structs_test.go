@@ -0,0 +1,65 @@
+package qeutil
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testTimestamps struct {
+	CreatedAt string `db:"created_at,readonly"`
+	UpdatedAt string `db:"updated_at,omitempty"`
+}
+
+type testAnswer struct {
+	ID       int64  `db:"id,pk"`
+	ExamID   int64  `db:"exam_id"`
+	Answer   string `db:"answer"`
+	Score    int
+	Internal string `db:"-"`
+	testTimestamps
+	secret string
+}
+
+func TestInsertFromStruct(t *testing.T) {
+	ic, err := InsertFromStruct("answers", &testAnswer{ExamID: 3, Answer: "A", testTimestamps: testTimestamps{CreatedAt: "now"}}, nil)
+	assert.NoError(t, err)
+	stm, val, _ := ic.SQLStm()
+	assert.Equal(t, "INSERT INTO `answers` (`exam_id`,`answer`,`score`,`created_at`) VALUES (?,?,?,?)", stm)
+	assert.Equal(t, []interface{}{int64(3), "A", 0, "now"}, val)
+	assert.Equal(t, "id", ic.Returning)
+	ic.Dialect = PostgreSQL
+	stm, _, _ = ic.SQLStm()
+	assert.Equal(t, `INSERT INTO "answers" ("exam_id","answer","score","created_at") VALUES ($1,$2,$3,$4) RETURNING "id"`, stm)
+
+	ic, err = InsertFromStruct("answers", testAnswer{ID: 1, Answer: "A"}, &StructOpts{OmitEmpty: true})
+	assert.NoError(t, err)
+	stm, val, _ = ic.SQLStm()
+	assert.Equal(t, "INSERT INTO `answers` (`id`,`answer`) VALUES (?,?)", stm)
+	assert.Equal(t, []interface{}{int64(1), "A"}, val)
+
+	_, err = InsertFromStruct("answers", 1, nil)
+	assert.Error(t, err)
+}
+
+func TestUpdateFromStruct(t *testing.T) {
+	uc, err := UpdateFromStruct("answers", &testAnswer{ID: 1, ExamID: 3, Answer: "B"}, &StructOpts{ReadOnly: []string{"exam_id"}})
+	assert.NoError(t, err)
+	stm, val, _ := uc.SQLStm()
+	assert.Equal(t, "UPDATE `answers` SET `answer` = ?, `score` = ? WHERE `id` = ?", stm)
+	assert.Equal(t, []interface{}{"B", 0, int64(1)}, val)
+
+	type noPK struct {
+		Name string `db:"name"`
+	}
+	_, err = UpdateFromStruct("answers", noPK{}, nil)
+	assert.Error(t, err)
+}
+
+func TestDeleteFromStruct(t *testing.T) {
+	dc, err := DeleteFromStruct("answers", testAnswer{ID: 1, ExamID: 3}, &StructOpts{PrimaryKey: []string{"exam_id"}})
+	assert.NoError(t, err)
+	stm, val, _ := dc.SQLStm()
+	assert.Equal(t, "DELETE FROM `answers` WHERE `id` = ? AND `exam_id` = ?", stm)
+	assert.Equal(t, []interface{}{int64(1), int64(3)}, val)
+}
@@ -0,0 +1,53 @@
+package qeutil
+
+import (
+	"context"
+)
+
+// Span is a span of a Tracer, e.g. an adapter of an OpenTelemetry trace.Span.
+type Span interface {
+	SetAttribute(key string, value interface{})
+	RecordError(err error)
+	End()
+}
+
+// Tracer starts the spans of the statements, e.g. an adapter of an OpenTelemetry trace.Tracer.
+type Tracer interface {
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// TraceHook is a Hook recording a span of each statement by Tracer, e.g. `qeutil.update answers`, with the
+// attributes of the OpenTelemetry database conventions: `db.operation`, `db.sql.table`, `db.statement` and
+// `db.rows_affected`. The arguments are not recorded.
+type TraceHook struct {
+	Tracer Tracer
+}
+
+// spanKey is the context key of the span of a statement started by a TraceHook.
+type spanKey struct {
+	h *TraceHook
+}
+
+// BeforeQuery implements Hook.
+func (th *TraceHook) BeforeQuery(ctx context.Context, e *QueryEvent) context.Context {
+	ctx, span := th.Tracer.Start(ctx, "qeutil."+e.Op+" "+e.Table)
+	span.SetAttribute("db.operation", e.Op)
+	span.SetAttribute("db.sql.table", e.Table)
+	span.SetAttribute("db.statement", e.Stm)
+	return context.WithValue(ctx, spanKey{th}, span)
+}
+
+// AfterQuery implements Hook.
+func (th *TraceHook) AfterQuery(ctx context.Context, e *QueryEvent) {
+	span, ok := ctx.Value(spanKey{th}).(Span)
+	if !ok {
+		return
+	}
+	if e.Rows >= 0 {
+		span.SetAttribute("db.rows_affected", e.Rows)
+	}
+	if e.Err != nil {
+		span.RecordError(e.Err)
+	}
+	span.End()
+}
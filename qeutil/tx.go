@@ -0,0 +1,197 @@
+package qeutil
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jmoiron/sqlx"
+)
+
+// TxOptions configures the transactions of WithTx.
+type TxOptions struct {
+	Tx         *sql.TxOptions   // Tx sets the isolation level and read-only flag of the transaction.
+	MaxRetries int              // MaxRetries is the number of times the function is retried on retryable errors.
+	Backoff    time.Duration    // Backoff is the delay before the first retry, which is doubled on each retry.
+	Retryable  func(error) bool // Retryable reports whether an error is retryable, IsRetryable if not given.
+	Cache      Cacher           // Cache unlinks the cache entries of the transaction after it is committed.
+}
+
+// DefaultTxOptions is used by WithTx when no TxOptions is given.
+var DefaultTxOptions = TxOptions{MaxRetries: 3, Backoff: 20 * time.Millisecond}
+
+// Tx is the transaction of WithTx, which can be passed to every helper accepting a sqlx.ExtContext.
+// The cache entries of its writes and the patterns given to Unlink are unlinked only after the transaction is committed.
+type Tx struct {
+	*sqlx.Tx
+	unlinks cacheUnlinks
+	depth   int
+}
+
+// txBeginner begins transactions, e.g. *sqlx.DB.
+type txBeginner interface {
+	BeginTxx(ctx context.Context, opts *sql.TxOptions) (*sqlx.Tx, error)
+}
+
+// IsRetryable reports whether the error is a MySQL deadlock (1213) or lock wait timeout (1205), or a
+// PostgreSQL serialization failure (40001) or deadlock (40P01), after which the transaction can be retried.
+func IsRetryable(err error) bool {
+	var myErr *mysql.MySQLError
+	if errors.As(err, &myErr) {
+		return myErr.Number == 1213 || myErr.Number == 1205
+	}
+	for ; err != nil; err = errors.Unwrap(err) {
+		if e, ok := err.(interface{ SQLState() string }); ok {
+			if state := e.SQLState(); state == "40001" || state == "40P01" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// WithTx runs fn in a transaction of db, which is committed if fn returns nil and rolled back otherwise.
+// The whole function is retried with backoff on retryable errors, so it must not have other side effects.
+// If db is the Tx of an outer WithTx, fn runs in a savepoint of the outer transaction instead.
+func WithTx(ctx context.Context, db sqlx.ExtContext, opts *TxOptions, fn func(tx *Tx) error) error {
+	if tx, ok := db.(*Tx); ok {
+		return tx.Savepoint(ctx, fn)
+	}
+	beginner, ok := db.(txBeginner)
+	if !ok {
+		return fmt.Errorf("%T cannot begin transactions", db)
+	}
+	if opts == nil {
+		opts = &DefaultTxOptions
+	}
+	retryable := opts.Retryable
+	if retryable == nil {
+		retryable = IsRetryable
+	}
+
+	backoff := opts.Backoff
+	for retry := 0; ; retry++ {
+		tx, err := runTx(ctx, beginner, opts.Tx, fn)
+		if err == nil {
+			if opts.Cache != nil && !tx.unlinks.empty() {
+				if err := tx.unlinks.unlink(opts.Cache); err != nil {
+					return fmt.Errorf("unlink cache after commit: %w", err)
+				}
+			}
+			return nil
+		}
+		if retry >= opts.MaxRetries || !retryable(err) {
+			return err
+		}
+
+		// Jitter keeps the retries of the conflicting transactions apart
+		delay := backoff
+		if delay > 0 {
+			delay = time.Duration(rand.Int63n(int64(delay))) + delay/2
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+		backoff *= 2
+	}
+}
+
+// runTx runs fn in a new transaction and returns the committed transaction.
+func runTx(ctx context.Context, db txBeginner, opts *sql.TxOptions, fn func(tx *Tx) error) (*Tx, error) {
+	sqlxTx, err := db.BeginTxx(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	tx := &Tx{Tx: sqlxTx}
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		}
+	}()
+
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
+
+// Savepoint runs fn in a savepoint of the transaction, which is rolled back if fn returns an error.
+// The patterns unlinked by fn are discarded as well when it is rolled back.
+func (tx *Tx) Savepoint(ctx context.Context, fn func(tx *Tx) error) error {
+	name := fmt.Sprintf("sp_%d", tx.depth+1)
+	if _, err := tx.ExecContext(ctx, "SAVEPOINT "+name); err != nil {
+		return err
+	}
+	nested := &Tx{Tx: tx.Tx, depth: tx.depth + 1}
+	defer func() {
+		if p := recover(); p != nil {
+			tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT "+name)
+			panic(p)
+		}
+	}()
+
+	if err := fn(nested); err != nil {
+		if _, rbErr := tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT "+name); rbErr != nil {
+			return fmt.Errorf("rollback to savepoint: %v: %w", rbErr, err)
+		}
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "RELEASE SAVEPOINT "+name); err != nil {
+		return err
+	}
+	tx.unlinks.merge(nested.unlinks)
+	return nil
+}
+
+// Unlink defers unlinking the cache patterns until the transaction is committed, they are unlinked by patterns
+// even if the cache is a TagCacher.
+func (tx *Tx) Unlink(patterns ...string) {
+	tx.unlinks.keys = append(tx.unlinks.keys, patterns...)
+}
+
+// Insert executes the InsertClause in the transaction and unlinks its cache entries after commit.
+func (tx *Tx) Insert(ctx context.Context, ic *InsertClause) (int64, error) {
+	id, err := ic.Exec(ctx, tx)
+	if err == nil {
+		tx.unlinks.add(ic)
+	}
+	return id, err
+}
+
+// Update executes the UpdateClause in the transaction and unlinks its cache entries after commit.
+func (tx *Tx) Update(ctx context.Context, uc *UpdateClause) (int64, error) {
+	n, err := uc.Exec(ctx, tx)
+	if err == nil {
+		tx.unlinks.add(uc)
+	}
+	return n, err
+}
+
+// Delete executes the DeleteClause in the transaction and unlinks its cache entries after commit.
+func (tx *Tx) Delete(ctx context.Context, dc *DeleteClause) (int64, error) {
+	n, err := dc.Exec(ctx, tx)
+	if err == nil {
+		tx.unlinks.add(dc)
+	}
+	return n, err
+}
+
+// Restore executes the RestoreClause in the transaction and unlinks its cache entries after commit.
+func (tx *Tx) Restore(ctx context.Context, rc *RestoreClause) (int64, error) {
+	n, err := rc.Exec(ctx, tx)
+	if err == nil {
+		tx.unlinks.add(rc)
+	}
+	return n, err
+}
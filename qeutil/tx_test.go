@@ -0,0 +1,95 @@
+package qeutil
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithTx(t *testing.T) {
+	deadlocks := 2
+	db, conn := newFakeDB(func(stm string, args []driver.Value) fakeResp {
+		if stm == "UPDATE `table` SET `name` = ? WHERE `id` = ?" && deadlocks > 0 {
+			deadlocks--
+			return fakeResp{err: &mysql.MySQLError{Number: 1213, Message: "Deadlock found when trying to get lock; try restarting transaction"}}
+		}
+		return fakeResp{affected: 1}
+	})
+	fc := newFakeCache()
+	fc.Set("table:where:{id=1}", 1, time.Minute)
+	opts := &TxOptions{MaxRetries: 3, Backoff: time.Millisecond, Cache: fc}
+
+	calls := 0
+	err := WithTx(context.Background(), db, opts, func(tx *Tx) error {
+		calls++
+		_, err := tx.Update(context.Background(), &UpdateClause{
+			Update: "table",
+			Set:    map[string]interface{}{"name": "a"},
+			Where:  []Wh{Wh{Operator: Eq, Values: map[string]interface{}{"id": 1}}},
+		})
+		if err == nil {
+			// Cache entries are kept until commit
+			assert.Equal(t, []string{"table:where:{id=1}"}, fc.keys())
+		}
+		return err
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, calls)
+	assert.Empty(t, fc.keys())
+	assert.Equal(t, []string{
+		"BEGIN", "UPDATE `table` SET `name` = ? WHERE `id` = ?", "ROLLBACK",
+		"BEGIN", "UPDATE `table` SET `name` = ? WHERE `id` = ?", "ROLLBACK",
+		"BEGIN", "UPDATE `table` SET `name` = ? WHERE `id` = ?", "COMMIT",
+	}, conn.statements())
+
+	// Other errors are not retried and nothing is unlinked
+	fc.Set("table:where:{id=1}", 1, time.Minute)
+	calls = 0
+	err = WithTx(context.Background(), db, opts, func(tx *Tx) error {
+		calls++
+		tx.Unlink("table:*")
+		return ErrNotExist
+	})
+	assert.Equal(t, ErrNotExist, err)
+	assert.Equal(t, 1, calls)
+	assert.Equal(t, []string{"table:where:{id=1}"}, fc.keys())
+}
+
+func TestWithTxSavepoint(t *testing.T) {
+	db, conn := newFakeDB(nil)
+	fc := newFakeCache()
+	for _, key := range []string{"a:1", "b:1", "c:1"} {
+		fc.Set(key, 1, time.Minute)
+	}
+
+	err := WithTx(context.Background(), db, &TxOptions{Cache: fc}, func(tx *Tx) error {
+		tx.Unlink("a:*")
+		assert.Error(t, WithTx(context.Background(), tx, nil, func(tx *Tx) error {
+			tx.Unlink("b:*")
+			return errors.New("failed")
+		}))
+		return tx.Savepoint(context.Background(), func(tx *Tx) error {
+			tx.Unlink("c:*")
+			return nil
+		})
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"b:1"}, fc.keys())
+	assert.Equal(t, []string{
+		"BEGIN", "SAVEPOINT sp_1", "ROLLBACK TO SAVEPOINT sp_1", "SAVEPOINT sp_1", "RELEASE SAVEPOINT sp_1", "COMMIT",
+	}, conn.statements())
+}
+
+func TestIsRetryable(t *testing.T) {
+	assert.True(t, IsRetryable(&mysql.MySQLError{Number: 1205, Message: "Lock wait timeout exceeded; try restarting transaction"}))
+	assert.True(t, IsRetryable(fmt.Errorf("update: %w", &mysql.MySQLError{Number: 1213, Message: "Deadlock found"})))
+	assert.False(t, IsRetryable(&mysql.MySQLError{Number: 1062, Message: "Duplicate entry"}))
+	assert.False(t, IsRetryable(errors.New("Error 1213: Deadlock found")))
+	assert.False(t, IsRetryable(nil))
+}
@@ -1,35 +1,101 @@
 package qeutil
 
 import (
+	"context"
 	"fmt"
+	"sort"
 
 	sq "github.com/Masterminds/squirrel"
+	"github.com/jmoiron/sqlx"
 )
 
 // UpdateClause .
+// The columns of Set are ordered as Columns, or sorted by name if Columns is not given.
+// An update without conditions in Where is refused with ErrFullTable unless AllowFullTable is set.
 type UpdateClause struct {
-	Update string
-	Set    map[string]interface{}
-	Where  []Wh
+	Update         string
+	Set            map[string]interface{}
+	Columns        []string
+	Where          []Wh
+	OrderBy        []Order // OrderBy orders the updated rows with Limit, it is only supported by MySQL.
+	Limit          *int    // Limit bounds the number of updated rows, it is only supported by MySQL.
+	AllowFullTable bool    // AllowFullTable allows the update of every row of the table.
+	Dialect        Dialect // Dialect of the statement, MySQL if not given.
+	Schema         Schema  // Schema limits the table and columns of the statement if given.
 }
 
-// SQLStm return a MySQL query statment from the InsertClause.
+// columns returns the order of the columns in Set.
+func (uc *UpdateClause) columns() ([]string, error) {
+	if len(uc.Columns) == 0 {
+		cols := make([]string, 0, len(uc.Set))
+		for k := range uc.Set {
+			cols = append(cols, k)
+		}
+		sort.Strings(cols)
+		return cols, nil
+	}
+
+	if len(uc.Columns) != len(uc.Set) {
+		return nil, fmt.Errorf("update of %v sets %d columns, but %d are ordered", uc.Update, len(uc.Set), len(uc.Columns))
+	}
+	for _, col := range uc.Columns {
+		if _, ok := uc.Set[col]; !ok {
+			return nil, fmt.Errorf("update of %v does not set the ordered column %v", uc.Update, col)
+		}
+	}
+	return uc.Columns, nil
+}
+
+// SQLStm return a query statment of the Dialect from the UpdateClause.
 func (uc *UpdateClause) SQLStm() (string, []interface{}, error) {
-	builder := sq.Update(uc.Update)
-	for k, v := range uc.Set {
-		builder = builder.Set(k, v)
+	if err := uc.Dialect.check(); err != nil {
+		return "", nil, err
+	}
+	cols, err := uc.columns()
+	if err != nil {
+		return "", nil, err
+	}
+	q := newIdents(uc.Dialect, uc.Schema)
+	table, err := q.table(uc.Update, "")
+	if err != nil {
+		return "", nil, err
+	}
+	builder := sq.Update(table).PlaceholderFormat(uc.Dialect.placeholder())
+	for _, col := range cols {
+		name, err := q.col(col)
+		if err != nil {
+			return "", nil, err
+		}
+		builder = builder.Set(name, uc.Set[col])
+	}
+	if err := checkFullTable(uc.Update, uc.Where, uc.AllowFullTable); err != nil {
+		return "", nil, err
+	}
+	orders, err := writeOrders(q, uc.Update, uc.OrderBy, uc.Limit)
+	if err != nil {
+		return "", nil, err
 	}
 	for i := range uc.Where {
-		builder = builder.Where(uc.Where[i].ToWhBuilder())
+		builder = builder.Where(uc.Where[i].toWhBuilder(q))
+	}
+	builder = builder.OrderBy(orders...)
+	if uc.Limit != nil {
+		builder = builder.Limit(uint64(*uc.Limit))
 	}
 	return builder.ToSql()
 }
 
-// ToUnlinks return an array of wh's ToStr() function result which can be used to unlink keys in redis.
+// ToUnlinks return the patterns which can be used to unlink keys in redis, which are all cache entries of the table
+// and the joined SelectClause cache entries involving it, see tableUnlinkPatterns.
 func (uc *UpdateClause) ToUnlinks() []string {
-	whsStr := make([]string, len(uc.Where))
-	for i := range uc.Where {
-		whsStr[i] = fmt.Sprintf("%v:*[:&]%v", uc.Update, uc.Where[i].ToStr())
+	return tableUnlinkPatterns(uc.Update)
+}
+
+// Exec executes the UpdateClause and returns the number of affected rows, ErrNotChanged is returned when no row is affected.
+func (uc *UpdateClause) Exec(ctx context.Context, db sqlx.ExtContext) (int64, error) {
+	stm, val, err := uc.SQLStm()
+	if err != nil {
+		return 0, err
 	}
-	return whsStr
+	return execAffected(ctx, db, OpUpdate, uc.Update, stm, val)
 }
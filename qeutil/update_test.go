@@ -1,6 +1,8 @@
 package qeutil
 
 import (
+	"context"
+	"database/sql/driver"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -20,6 +22,57 @@ func TestUpdateClauseSQLStm(t *testing.T) {
 		},
 	}
 	stm, val, _ := uc.SQLStm()
-	assert.Equal(t, "UPDATE table SET field1 = ?, field2 = ? WHERE in_comp IN (?,?,?) AND gt_comp > ? AND lt_comp < ?", stm)
+	assert.Equal(t, "UPDATE `table` SET `field1` = ?, `field2` = ? WHERE `in_comp` IN (?,?,?) AND `gt_comp` > ? AND `lt_comp` < ?", stm)
 	assert.Equal(t, []interface{}{1, "2", "hello", "world", "!", 1, 2}, val)
 }
+
+func TestUpdateClauseExec(t *testing.T) {
+	affected := int64(3)
+	db, _ := newFakeDB(func(stm string, args []driver.Value) fakeResp {
+		return fakeResp{affected: affected}
+	})
+	uc := UpdateClause{
+		Update: "table",
+		Set:    map[string]interface{}{"field1": 1},
+		Where:  []Wh{Wh{Operator: Eq, Values: map[string]interface{}{"id": 1}}},
+	}
+	n, err := uc.Exec(context.Background(), db)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), n)
+
+	affected = 0
+	_, err = uc.Exec(context.Background(), db)
+	assert.Equal(t, ErrNotChanged, err)
+}
+
+func TestUpdateClauseSQLStmColumns(t *testing.T) {
+	uc := UpdateClause{
+		Update:  "table",
+		Set:     map[string]interface{}{"b": 1, "a": 2, "c": 3},
+		Columns: []string{"c", "a", "b"},
+		Where:   []Wh{Wh{Operator: Eq, Values: map[string]interface{}{"id": 1, "group_id": 2}}},
+	}
+	stm, val, err := uc.SQLStm()
+	assert.NoError(t, err)
+	assert.Equal(t, "UPDATE `table` SET `c` = ?, `a` = ?, `b` = ? WHERE `group_id` = ? AND `id` = ?", stm)
+	assert.Equal(t, []interface{}{3, 2, 1, 2, 1}, val)
+	assert.Equal(t, []string{"table", "table:*", "table:join:*", "*:join:*=table[@(]*"}, uc.ToUnlinks())
+
+	uc.Columns = []string{"c", "a", "d"}
+	_, _, err = uc.SQLStm()
+	assert.Error(t, err)
+}
+
+func TestUpdateClauseSQLStmStable(t *testing.T) {
+	uc := UpdateClause{
+		Update:         "table",
+		Set:            map[string]interface{}{"f": 1, "e": 2, "d": 3, "c": 4, "b": 5, "a": 6},
+		AllowFullTable: true,
+	}
+	first, _, _ := uc.SQLStm()
+	for i := 0; i < 20; i++ {
+		stm, _, _ := uc.SQLStm()
+		assert.Equal(t, first, stm)
+	}
+	assert.Equal(t, "UPDATE `table` SET `a` = ?, `b` = ?, `c` = ?, `d` = ?, `e` = ?, `f` = ?", first)
+}
@@ -71,6 +71,129 @@ func (cli *Client) UnlinkKeys(keys []string) error {
 	return nil
 }
 
+// setTagsScript sets the cache key KEYS[1] to the value ARGV[1] with the TTL ARGV[2] in milliseconds, and adds
+// it to the tag sets KEYS[2..]. The TTL of a tag set is only extended, so that it outlives all of its keys. Since a
+// busy tag set may never expire, a few of its members are sampled on each add and the expired keys are removed,
+// which keeps the set within a small multiple of its live keys.
+var setTagsScript = redis.NewScript(`
+redis.replicate_commands()
+local ttl = tonumber(ARGV[2])
+if ttl > 0 then
+	redis.call('SET', KEYS[1], ARGV[1], 'PX', ttl)
+else
+	redis.call('SET', KEYS[1], ARGV[1])
+end
+for i = 2, #KEYS do
+	local cur = redis.call('PTTL', KEYS[i])
+	redis.call('SADD', KEYS[i], KEYS[1])
+	if ttl <= 0 then
+		redis.call('PERSIST', KEYS[i])
+	elseif cur == -2 or (cur >= 0 and cur < ttl) then
+		redis.call('PEXPIRE', KEYS[i], ttl)
+	end
+	for _, key in ipairs(redis.call('SRANDMEMBER', KEYS[i], 2)) do
+		if redis.call('EXISTS', key) == 0 then
+			redis.call('SREM', KEYS[i], key)
+		end
+	end
+end
+return 1
+`)
+
+// unlinkTagScript unlinks the keys ARGV[n+2..] of the tag set KEYS[1], which are removed from the set, except the
+// keys skipped by the n = ARGV[1] skip groups. The sizes of the groups are ARGV[2..n+1] and their tags are KEYS[2..]
+// in order. The skipped keys which have expired are removed from the set as well. It returns the number of keys.
+var unlinkTagScript = redis.NewScript(`
+local groups, k = {}, 2
+for g = 1, tonumber(ARGV[1]) do
+	local size = tonumber(ARGV[1 + g])
+	groups[g] = {unpack(KEYS, k, k + size - 1)}
+	k = k + size
+end
+local n = 0
+for i = #groups + 2, #ARGV do
+	local key = ARGV[i]
+	local skipped = false
+	for _, group in ipairs(groups) do
+		if not skipped and redis.call('SISMEMBER', group[1], key) == 1 then
+			skipped = true
+			for j = 2, #group do
+				if redis.call('SISMEMBER', group[j], key) == 1 then
+					skipped = false
+					break
+				end
+			end
+		end
+	end
+	if not skipped then
+		redis.call('UNLINK', key)
+		redis.call('SREM', KEYS[1], key)
+		n = n + 1
+	elseif redis.call('EXISTS', key) == 0 then
+		redis.call('SREM', KEYS[1], key)
+	end
+end
+return n
+`)
+
+// unlinkTagsBatch is the number of keys of a tag set unlinked at once.
+const unlinkTagsBatch = 500
+
+// SetTags sets object into redis client like Set, and adds its key to the sets of the given tags atomically.
+// The tag sets expire after the longest living key in them.
+func (cli *Client) SetTags(key string, obj interface{}, exp time.Duration, tags []string) error {
+	b, err := cli.codec.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	// Same expiration as cache.Item
+	if exp < 0 {
+		exp = 0
+	} else if exp < time.Second {
+		exp = time.Hour
+	}
+	keys := append([]string{key}, tags...)
+	return setTagsScript.Run(cli.client, keys, b, int64(exp/time.Millisecond)).Err()
+}
+
+// UnlinkTags remove all keys in redis that are added to the given tags by SetTags, which takes a set lookup for
+// each tag instead of scanning the keyspace like UnlinkKeys. The keys of a tag are skipped by any of its skip
+// groups if they are in the set of the first tag of the group and in none of the others. The sets are walked in
+// batches, so that a large set does not block redis, and the unlinked keys are removed from them.
+func (cli *Client) UnlinkTags(tags []string, skips map[string][][]string) error {
+	for _, tag := range tags {
+		keys := []string{tag}
+		args := []interface{}{len(skips[tag])}
+		for _, group := range skips[tag] {
+			keys = append(keys, group...)
+			args = append(args, len(group))
+		}
+
+		var cursor uint64
+		for {
+			members, next, err := cli.client.SScan(tag, cursor, "", unlinkTagsBatch).Result()
+			if err != nil {
+				return err
+			}
+			if len(members) > 0 {
+				batch := make([]interface{}, len(args), len(args)+len(members))
+				copy(batch, args)
+				for _, key := range members {
+					batch = append(batch, key)
+				}
+				if err := unlinkTagScript.Run(cli.client, keys, batch...).Err(); err != nil {
+					return err
+				}
+			}
+			if next == 0 {
+				break
+			}
+			cursor = next
+		}
+	}
+	return nil
+}
+
 // Client return the redis connection client.
 func (cli *Client) Client() *redis.Client {
 	return cli.client